@@ -0,0 +1,127 @@
+package goinject
+
+import (
+	"go/token"
+	"strconv"
+
+	"github.com/dave/dst"
+)
+
+// allocMetric is the runtime/metrics sample counting the bytes allocated on the heap.
+const allocMetric = "/gc/heap/allocs:bytes"
+
+// AllocMeasure describes a user-provided function receiving the number of bytes
+// allocated on the heap during a call of an instrumented function.
+//
+// The measurement is based on the cumulative runtime/metrics counter, which is much cheaper
+// than runtime.ReadMemStats as it does not stop the world. The counter is process-wide,
+// so allocations made by other goroutines during the call are attributed to it as well.
+// Small allocations are accounted in batches, so short functions may report none of them.
+type AllocMeasure struct {
+	// Path is the import path of the package providing the reporting function.
+	Path string
+	// Report is the name of the reporting function, e.g. `func Report(name string, bytes uint64)`.
+	Report string
+	// Limit, if not zero, restricts reporting to calls that allocated more than Limit bytes.
+	Limit uint64
+}
+
+// Inject measures the allocations of the function and reports them when the function returns:
+//
+//	func Handle() {
+//		__goinject_alloc := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+//		metrics.Read(__goinject_alloc)
+//		defer func(start uint64) {
+//			metrics.Read(__goinject_alloc)
+//			if allocated := __goinject_alloc[0].Value.Uint64() - start; allocated > limit {
+//				alloc.Report("Handle", allocated)
+//			}
+//		}(__goinject_alloc[0].Value.Uint64())
+//		...
+//	}
+//
+// The measurement is only injected if it is enabled with [WithAllocMeasurement], so it can be
+// kept out of release builds. Inject reports whether the function was instrumented.
+// Functions without a body are skipped.
+func (a AllocMeasure) Inject(ctx *ModifyContext, decl *dst.FuncDecl) bool {
+	if !ctx.unit.allocMeasurement || decl.Body == nil {
+		return false
+	}
+
+	samples := ctx.Ident("alloc")
+	counter := func() dst.Expr {
+		return &dst.CallExpr{
+			Fun: &dst.SelectorExpr{
+				X: &dst.SelectorExpr{
+					X:   &dst.IndexExpr{X: dst.NewIdent(samples), Index: &dst.BasicLit{Kind: token.INT, Value: "0"}},
+					Sel: dst.NewIdent("Value"),
+				},
+				Sel: dst.NewIdent("Uint64"),
+			},
+		}
+	}
+	read := func() dst.Stmt {
+		return &dst.ExprStmt{X: &dst.CallExpr{
+			Fun:  &dst.Ident{Path: "runtime/metrics", Name: "Read"},
+			Args: []dst.Expr{dst.NewIdent(samples)},
+		}}
+	}
+
+	declare := &dst.AssignStmt{
+		Lhs: []dst.Expr{dst.NewIdent(samples)},
+		Tok: token.DEFINE,
+		Rhs: []dst.Expr{&dst.CompositeLit{
+			Type: &dst.ArrayType{Elt: &dst.Ident{Path: "runtime/metrics", Name: "Sample"}},
+			Elts: []dst.Expr{&dst.CompositeLit{Elts: []dst.Expr{&dst.KeyValueExpr{
+				Key:   dst.NewIdent("Name"),
+				Value: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(allocMetric)},
+			}}}},
+		}},
+	}
+
+	allocated := ctx.Ident("allocated")
+	start := ctx.Ident("start")
+	report := &dst.IfStmt{
+		Init: &dst.AssignStmt{
+			Lhs: []dst.Expr{dst.NewIdent(allocated)},
+			Tok: token.DEFINE,
+			Rhs: []dst.Expr{&dst.BinaryExpr{X: counter(), Op: token.SUB, Y: dst.NewIdent(start)}},
+		},
+		Cond: &dst.BinaryExpr{
+			X:  dst.NewIdent(allocated),
+			Op: token.GTR,
+			Y:  &dst.BasicLit{Kind: token.INT, Value: strconv.FormatUint(a.Limit, 10)},
+		},
+		Body: &dst.BlockStmt{List: []dst.Stmt{&dst.ExprStmt{X: &dst.CallExpr{
+			Fun:  &dst.Ident{Path: a.Path, Name: a.Report},
+			Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcName(decl))}, dst.NewIdent(allocated)},
+		}}}},
+	}
+
+	// The start value is passed as an argument, so it is evaluated right away
+	// and the deferred function stays free of captured state besides the samples.
+	deferStmt := &dst.DeferStmt{Call: &dst.CallExpr{
+		Fun: &dst.FuncLit{
+			Type: &dst.FuncType{Params: &dst.FieldList{List: []*dst.Field{{
+				Names: []*dst.Ident{dst.NewIdent(start)},
+				Type:  dst.NewIdent("uint64"),
+			}}}},
+			Body: &dst.BlockStmt{List: []dst.Stmt{read(), report}},
+		},
+		Args: []dst.Expr{counter()},
+	}}
+
+	decl.Body.List = append([]dst.Stmt{declare, read(), deferStmt}, decl.Body.List...)
+
+	return true
+}
+
+// funcName returns the name of the function as it is referred to in Go code,
+// e.g. `Handle` or `Server.Handle` for methods.
+func funcName(decl *dst.FuncDecl) string {
+	if recv := receiverTypeName(decl); recv != "" {
+		return recv + "." + decl.Name.Name
+	}
+
+	return decl.Name.Name
+}
@@ -0,0 +1,18 @@
+package goinject
+
+import "testing"
+
+func TestVersionProbeAllocMeasurement(t *testing.T) {
+	dir := t.TempDir()
+	tool, _ := fakeCompiler(t, 0)
+
+	// The measurement is injected only when enabled, so the packages must be rebuilt when it is toggled.
+	enabled := probeVersion(t, dir, tool, WithAllocMeasurement(true))
+	disabled := probeVersion(t, dir, tool, WithAllocMeasurement(false))
+	if enabled == disabled {
+		t.Errorf("version is the same with the alloc measurement enabled and disabled: %s", enabled)
+	}
+	if again := probeVersion(t, dir, tool, WithAllocMeasurement(true)); again != enabled {
+		t.Errorf("version changed between runs with the same options: %s, then %s", enabled, again)
+	}
+}
@@ -0,0 +1,119 @@
+package goinject
+
+import (
+	"go/ast"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// directivePrefix starts the comments holding the directives of goinject.
+const directivePrefix = "//goinject:"
+
+// Directive is a `//goinject:name args` comment of the source, which allows the users
+// to control the modification from the code being modified. The core of goinject
+// acts on the following directives, while the others are left for the modifiers:
+//
+//   - //goinject:skip above the package clause compiles the file as is.
+//   - //goinject:skip in the doc comment of a function leaves the function as it is,
+//     whatever the modifier does to it.
+//
+// The directive reserved for the modifiers is //goinject:apply, which marks the files
+// and the functions a modifier working on an opt-in basis instruments, along with
+// the optional names of the modifications to apply, e.g. `//goinject:apply tracing metrics`.
+type Directive struct {
+	// Name is the name of the directive, e.g. "skip".
+	Name string
+	// Args are the space-separated arguments following the name.
+	Args []string
+}
+
+// FileDirectives returns the directives in the comments above the package clause of the file.
+func FileDirectives(f *dst.File) []Directive {
+	return parseDirectives(f.Decs.Start.All())
+}
+
+// FuncDirectives returns the directives in the doc comment of the function.
+func FuncDirectives(decl *dst.FuncDecl) []Directive {
+	return parseDirectives(decl.Decs.Start.All())
+}
+
+// HasDirective reports whether the directives include the one with the name.
+func HasDirective(directives []Directive, name string) bool {
+	return slices.ContainsFunc(directives, func(d Directive) bool { return d.Name == name })
+}
+
+// parseDirectives returns the directives among the comments.
+func parseDirectives(comments []string) []Directive {
+	var directives []Directive
+	for _, comment := range comments {
+		text, ok := strings.CutPrefix(comment, directivePrefix)
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		directives = append(directives, Directive{Name: fields[0], Args: fields[1:]})
+	}
+
+	return directives
+}
+
+// skipsFile reports whether the original file is marked with //goinject:skip above its package clause.
+// It is checked before the file is decorated, so the skipped files cost nothing more than parsing.
+func skipsFile(f *ast.File) bool {
+	var comments []string
+	for _, group := range f.Comments {
+		if group.Pos() > f.Package {
+			break
+		}
+		for _, comment := range group.List {
+			comments = append(comments, comment.Text)
+		}
+	}
+
+	return HasDirective(parseDirectives(comments), "skip")
+}
+
+// protectSkippedFuncs hands the modifier copies of the functions of the file marked
+// with //goinject:skip, and returns the function putting the original functions back
+// into the modified file in place of the copies, so the modifications of them are undone.
+// The copies are found in the modified file by identity, or by name if the modifier
+// replaced them.
+func protectSkippedFuncs(f *dst.File) (restore func(*dst.File)) {
+	type skippedFunc struct {
+		original *dst.FuncDecl
+		copy     *dst.FuncDecl
+	}
+
+	var skipped []skippedFunc
+	for idx, decl := range f.Decls {
+		funcDecl, ok := decl.(*dst.FuncDecl)
+		if !ok || !HasDirective(FuncDirectives(funcDecl), "skip") {
+			continue
+		}
+
+		funcCopy := dst.Clone(funcDecl).(*dst.FuncDecl)
+		f.Decls[idx] = funcCopy
+		skipped = append(skipped, skippedFunc{original: funcDecl, copy: funcCopy})
+	}
+
+	return func(modified *dst.File) {
+		for _, fn := range skipped {
+			idx := slices.IndexFunc(modified.Decls, func(decl dst.Decl) bool { return decl == fn.copy })
+			if idx < 0 {
+				idx = slices.IndexFunc(modified.Decls, func(decl dst.Decl) bool {
+					funcDecl, ok := decl.(*dst.FuncDecl)
+					return ok && funcName(funcDecl) == funcName(fn.original)
+				})
+			}
+			if idx >= 0 {
+				modified.Decls[idx] = fn.original
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// buildCheckEnv marks the processes run by the go command as the -toolexec program of [BuildCheck].
+const buildCheckEnv = "GOINJECT_BUILD_CHECK"
+
+// BuildCheck builds all the packages of the module in the current directory with the modifier
+// applied, and returns an error with the output of the build if any of them fails to compile.
+// It allows CI to assert that the instrumented build is green with a single call.
+//
+// The build runs `go build -toolexec` with the current executable as the preprocessor,
+// so the executable must call BuildCheck before doing anything else: when it is run
+// by the go command, BuildCheck does the work of [Main] and exits. In a test binary,
+// this means calling it in TestMain before m.Run:
+//
+//	func TestMain(m *testing.M) {
+//		if err := goinject.BuildCheck(modifier{}); err != nil {
+//			fmt.Println(err)
+//			os.Exit(1)
+//		}
+//		os.Exit(m.Run())
+//	}
+func BuildCheck(modifier Modifier, opts ...Option) error {
+	if os.Getenv(buildCheckEnv) != "" {
+		Main(modifier, opts...)
+		os.Exit(0)
+	}
+
+	toolexec, err := ToolexecFlag()
+	if err != nil {
+		return err
+	}
+
+	config := &config{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	build := newBuildContext(config.buildTags, config.buildEnv, config.goBinary)
+
+	args := []string{"build", toolexec}
+	args = append(args, build.buildFlags()...)
+	args = append(args, "./...")
+
+	cmd := build.goCommand(args...)
+	cmd.Env = append(cmd.Env, buildCheckEnv+"=1")
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("instrumented build failed: %w\n%s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package goinject
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// buildCheckModifierEnv selects the modifier of [buildCheckModifiers] the test binary applies
+// when the go command runs it as the -toolexec program of [BuildCheck].
+const buildCheckModifierEnv = "GOINJECT_TEST_BUILD_CHECK_MODIFIER"
+
+var buildCheckModifiers = map[string]Modifier{
+	"valid":  identity,
+	"broken": brokenModifier,
+}
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(buildCheckModifierEnv); name != "" && os.Getenv(buildCheckEnv) != "" {
+		BuildCheck(buildCheckModifiers[name])
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestBuildCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building a module with the test binary as the preprocessor in short mode")
+	}
+
+	tests := []struct {
+		modifier string
+		// want is a part of the error expected from the check, empty if it passes.
+		want string
+	}{
+		{modifier: "valid"},
+		{modifier: "broken", want: "instrumented build failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.modifier, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":     "module example.com/app\n\ngo 1.22\n",
+				"main.go":    "package main\n\nimport \"example.com/app/lib\"\n\nfunc main() { lib.Hello() }\n",
+				"lib/lib.go": "package lib\n\nfunc Hello() {}\n",
+			})
+			chdir(t, dir)
+			t.Setenv(buildCheckModifierEnv, tt.modifier)
+
+			err := BuildCheck(buildCheckModifiers[tt.modifier])
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("the broken build passed the check")
+			}
+			// The details carry the output of the compiler.
+			if msg := err.Error(); !strings.Contains(msg, tt.want) || !strings.Contains(msg, "syntax error") {
+				t.Errorf("got error %q, want %q with the details of the build", msg, tt.want)
+			}
+		})
+	}
+}
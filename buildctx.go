@@ -0,0 +1,196 @@
+package goinject
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// buildContext describes the build configuration the go toolchain
+// runs the compiler with. It must be propagated to every subprocess that
+// inspects packages, otherwise import resolution may diverge from what
+// the compiler actually sees on cross-compiles or tagged builds.
+type buildContext struct {
+	goos   string
+	goarch string
+	tags   []string
+	// vendor forces the vendor mode, see [buildContext.detectVendor].
+	vendor bool
+
+	// extraEnv is the environment given to [WithBuildEnv].
+	extraEnv []string
+	// goBinary is the path to the go command given to [WithGoBinary].
+	goBinary string
+	// ctx bounds the subprocesses, see [ProcessContext].
+	ctx context.Context
+	// profile counts the subprocesses, see [WithProfile].
+	profile *profile
+
+	// stdout and stderr receive the output of the tools, see [WithStdout] and [WithStderr].
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// newBuildContext derives the build context of the current compilation.
+//
+// cmd/go exports GOOS and GOARCH to every tool it runs, so they are taken
+// from the environment. Build tags however are never passed to the tools,
+// so they are collected from GOFLAGS and from the tags given to [WithBuildTags].
+// GOFLAGS may be overridden by the environment given to [WithBuildEnv].
+func newBuildContext(extraTags []string, extraEnv []string, goBinary string) buildContext {
+	goFlags := os.Getenv("GOFLAGS")
+	if value, ok := lookupEnv(extraEnv, "GOFLAGS"); ok {
+		goFlags = value
+	}
+
+	tags := tagsFromGoFlags(goFlags)
+	for _, tag := range extraTags {
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return buildContext{
+		goos:     os.Getenv("GOOS"),
+		goarch:   os.Getenv("GOARCH"),
+		tags:     tags,
+		extraEnv: extraEnv,
+		goBinary: goBinary,
+		ctx:      context.Background(),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+	}
+}
+
+// arch returns the architecture the package is compiled for. GOARCH is unset
+// for native builds, which target the architecture the preprocessor itself runs on.
+func (b buildContext) arch() string {
+	if b.goarch != "" {
+		return b.goarch
+	}
+
+	return runtime.GOARCH
+}
+
+// buildFlags returns the flags for `go list` and [packages.Config]
+// that reproduce the build context.
+// The -tags flag overrides the one from GOFLAGS, so it carries the merged set of tags.
+func (b buildContext) buildFlags() []string {
+	var flags []string
+	if len(b.tags) > 0 {
+		flags = append(flags, "-tags="+strings.Join(b.tags, ","))
+	}
+	if b.vendor {
+		flags = append(flags, "-mod=vendor")
+	}
+
+	return flags
+}
+
+// detectVendor enables the vendor mode if the module at root vendors its dependencies
+// and the -mod flag is not set explicitly. The go command defaults to the vendor mode
+// in such modules only when it runs inside of them, while the subprocesses inspecting
+// packages may run elsewhere, and would resolve the dependencies from the module cache
+// instead of the vendored copies the compiler uses.
+func (b *buildContext) detectVendor(root string) {
+	goFlags := os.Getenv("GOFLAGS")
+	if value, ok := lookupEnv(b.extraEnv, "GOFLAGS"); ok {
+		goFlags = value
+	}
+
+	if slices.ContainsFunc(strings.Fields(goFlags), func(flag string) bool {
+		return strings.HasPrefix(strings.TrimLeft(flag, "-"), "mod=")
+	}) {
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "vendor", "modules.txt")); err == nil {
+		b.vendor = true
+	}
+}
+
+// env returns the environment for subprocesses: the environment of the process
+// extended with the one given to [WithBuildEnv], with GOOS and GOARCH pinned
+// to the ones of the current compilation.
+func (b buildContext) env() []string {
+	env := append(os.Environ(), b.extraEnv...)
+	if b.goos != "" {
+		env = append(env, "GOOS="+b.goos)
+	}
+	if b.goarch != "" {
+		env = append(env, "GOARCH="+b.goarch)
+	}
+
+	return env
+}
+
+// command returns the command running the named program within the build context.
+// The command is killed when the context of the build is done. The zero build context,
+// used by the exported helpers like [ResolvePkg], has no context and never kills it.
+func (b buildContext) command(name string, args ...string) *exec.Cmd {
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = b.env()
+
+	return cmd
+}
+
+// goCommand returns the go command with the given arguments running within the build context.
+func (b buildContext) goCommand(args ...string) *exec.Cmd {
+	goBinary := b.goBinary
+	if goBinary == "" {
+		goBinary = "go"
+	}
+	b.profile.countGoCommand()
+
+	return b.command(goBinary, args...)
+}
+
+// preferGoBinary puts the directory of the custom go binary first in PATH of the process.
+// Tools running the go command themselves, like [golang.org/x/tools/go/packages.Load],
+// look it up in PATH of the process rather than of the environment they are given.
+func (b buildContext) preferGoBinary() error {
+	if b.goBinary == "" {
+		return nil
+	}
+
+	return os.Setenv("PATH", filepath.Dir(b.goBinary)+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// lookupEnv returns the value of the last definition of the variable in env.
+func lookupEnv(env []string, name string) (string, bool) {
+	for idx := len(env) - 1; idx >= 0; idx-- {
+		if value, found := strings.CutPrefix(env[idx], name+"="); found {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// tagsFromGoFlags extracts build tags from the GOFLAGS value.
+// GOFLAGS only allows flags in the -flag=value form, and tags may be
+// separated by commas or, in the legacy form, by spaces.
+func tagsFromGoFlags(goFlags string) []string {
+	var tags []string
+	for _, flag := range strings.Fields(goFlags) {
+		value, found := strings.CutPrefix(strings.TrimLeft(flag, "-"), "tags=")
+		if !found {
+			continue
+		}
+
+		// The last occurrence of the flag wins, just like in cmd/go.
+		tags = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+
+	return tags
+}
@@ -0,0 +1,248 @@
+package goinject
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessBuildTags(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// wantErr reports whether the import is expected to be unresolvable.
+		wantErr bool
+	}{
+		{name: "option", opts: []Option{WithBuildTags("integration")}},
+		{name: "GOFLAGS", opts: []Option{WithBuildEnv([]string{"GOFLAGS=-tags=integration"})}},
+		{name: "untagged", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":  "module example.com/app\n\ngo 1.22\n",
+				"main.go": "package main\n\nfunc main() {}\n",
+				// The package only has files under the tag, so it does not build without it.
+				"lib/lib_integration.go": "//go:build integration\n\npackage lib\n\nfunc Integration() {}\n",
+				"importcfg":              "",
+			})
+
+			tool, _ := fakeCompiler(t, 0)
+			importcfg := filepath.Join(dir, "importcfg")
+			args := []string{"-p", "main", "-importcfg", importcfg, "-pack", filepath.Join(dir, "main.go")}
+			err := runCompile(t, dir, tool, args, appendCall("main", "example.com/app/lib", "Integration"), tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("the import only built under the tag was resolved without it")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(importcfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), "packagefile example.com/app/lib=") {
+				t.Errorf("importcfg does not resolve the package built under the tag:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestResolvePkgBuildEnv(t *testing.T) {
+	realGo, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	// The go command is a script recording the sentinel of its environment.
+	binDir := t.TempDir()
+	goBinary, seenFile := filepath.Join(binDir, "go"), filepath.Join(binDir, "seen")
+	script := "#!/bin/sh\necho \"$GOINJECT_SENTINEL\" >> '" + seenFile + "'\nexec '" + realGo + "' \"$@\"\n"
+	if err := os.WriteFile(goBinary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"})
+	chdir(t, dir)
+
+	build := newBuildContext(nil, []string{"GOINJECT_SENTINEL=sentinel"}, goBinary)
+	if _, err := resolvePkg(build, "fmt"); err != nil {
+		t.Fatal(err)
+	}
+
+	seen, err := os.ReadFile(seenFile)
+	if err != nil {
+		t.Fatalf("the go binary of the option was not run: %s", err)
+	}
+	if string(seen) != "sentinel\n" {
+		t.Errorf("resolver saw the sentinel %q, want %q", seen, "sentinel\n")
+	}
+}
+
+func TestProcessArchConstraint(t *testing.T) {
+	no386 := WithArchConstraint(func(goarch string) bool { return goarch != "386" })
+
+	tests := []struct {
+		goarch   string
+		modified bool
+	}{
+		{goarch: "386"},
+		{goarch: "amd64", modified: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goarch, func(t *testing.T) {
+			// cmd/go exports the GOARCH of a cross-compile to the tools it runs.
+			t.Setenv("GOARCH", tt.goarch)
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			tool, argsFile := fakeCompiler(t, 0)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			if err := runCompile(t, dir, tool, args, identity, no386); err != nil {
+				t.Fatal(err)
+			}
+
+			compiled := compiledArgs(t, argsFile)
+			if modified := compiled[len(compiled)-1] != mainFile; modified != tt.modified {
+				t.Errorf("modified for %s: %t, want %t", tt.goarch, modified, tt.modified)
+			}
+		})
+	}
+}
+
+func TestProcessVendoredImport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                        "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+		"main.go":                       "package main\n\nfunc main() {}\n",
+		"vendor/modules.txt":            "# example.com/dep v1.0.0\n## explicit\nexample.com/dep\n",
+		"vendor/example.com/dep/dep.go": "package dep\n\nfunc Hello() {}\n",
+		"importcfg":                     "",
+	})
+	// The dependency is only available in the vendor directory, not in the module cache.
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Cleanup(resetImportcfgAdditions)
+
+	tool, argsFile := fakeCompiler(t, 0)
+	importcfg := filepath.Join(dir, "importcfg")
+	args := []string{"-p", "main", "-importcfg", importcfg, "-pack", filepath.Join(dir, "main.go")}
+	if err := runCompile(t, dir, tool, args, appendCall("main", "example.com/dep", "Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(importcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "packagefile example.com/dep=") {
+		t.Errorf("importcfg does not resolve the vendored package:\n%s", content)
+	}
+
+	// The vendored package itself is a dependency, compiled as is.
+	depFile := filepath.Join(dir, "vendor", "example.com", "dep", "dep.go")
+	args = []string{"-p", "example.com/dep", "-importcfg", importcfg, "-pack", depFile}
+	if err := runCompile(t, dir, tool, args, appendCall("Hello", "fmt", "Println")); err != nil {
+		t.Fatal(err)
+	}
+	if compiled := compiledArgs(t, argsFile); compiled[len(compiled)-1] != depFile {
+		t.Errorf("vendored file was compiled from %s, want the original %s", compiled[len(compiled)-1], depFile)
+	}
+}
+
+func TestDetectVendor(t *testing.T) {
+	tests := []struct {
+		name    string
+		vendor  bool
+		goFlags string
+		want    []string
+	}{
+		{name: "vendored", vendor: true, want: []string{"-mod=vendor"}},
+		{name: "explicit mod flag", vendor: true, goFlags: "-mod=mod"},
+		{name: "not vendored"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.vendor {
+				writeFiles(t, dir, map[string]string{"vendor/modules.txt": ""})
+			}
+
+			build := newBuildContext(nil, []string{"GOFLAGS=" + tt.goFlags}, "")
+			build.detectVendor(dir)
+			if got := build.buildFlags(); !slices.Equal(got, tt.want) {
+				t.Errorf("got build flags %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessOutputRedirect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake compiler is a shell script")
+	}
+
+	tests := []struct {
+		name string
+		// std compiles a std package, which is passed through rather than modified.
+		std bool
+	}{
+		{name: "modified"},
+		{name: "passed through", std: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+				"compile":   "#!/bin/sh\nif [ \"$1\" = -V=full ]; then echo 'compile version go1.22.0'; exit 0; fi\necho 'compiler output'\necho 'compiler warning' >&2\n",
+			})
+			tool := filepath.Join(dir, "compile")
+			if err := os.Chmod(tool, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+			if tt.std {
+				args = append([]string{"-std"}, args...)
+			}
+
+			var stdout, stderr bytes.Buffer
+			realStdout := captureStdout(t, func() {
+				if err := runCompile(t, dir, tool, args, identity, WithStdout(&stdout), WithStderr(&stderr)); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			if stdout.String() != "compiler output\n" || stderr.String() != "compiler warning\n" {
+				t.Errorf("got stdout %q and stderr %q, want the ones of the compiler", stdout.String(), stderr.String())
+			}
+			if realStdout != "" {
+				t.Errorf("compiler printed %q to the standard output", realStdout)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ package goinject
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
@@ -19,8 +20,8 @@ var (
 
 const buildIDHashLength = 15
 
-func alterToolVersion(tool string, args []string) error {
-	line, err := execCmd(tool, args...)
+func alterToolVersion(build buildContext, tool string, args []string, cacheInputs [][]byte) error {
+	line, err := execCmd(build.command(tool, args...))
 	if err != nil {
 		return fmt.Errorf("calling %s %q: %w", tool, args, err)
 	}
@@ -32,13 +33,21 @@ func alterToolVersion(tool string, args []string) error {
 		return nil
 	}
 
+	// Only the inputs of the compiler are modified, so the outputs of asm and link
+	// are cached as usual. Links still follow the modified packages, since their
+	// cache keys include the build IDs of the packages they link.
+	if toolName != "compile" {
+		fmt.Print(line)
+		return nil
+	}
+
 	execPath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("retrieving executable path: %w", err)
 	}
 
 	packageID := []byte(line)
-	contentID, err := addToolToHash(execPath, packageID)
+	contentID, err := addToolToHash(build, execPath, packageID, cacheInputs)
 	if err != nil {
 		return fmt.Errorf("adding tool id to hash: %w", err)
 	}
@@ -54,20 +63,26 @@ func alterToolVersion(tool string, args []string) error {
 	return nil
 }
 
-func addToolToHash(execPath string, inputHash []byte) ([sha256.Size]byte, error) {
+func addToolToHash(build buildContext, execPath string, inputHash []byte, cacheInputs [][]byte) ([sha256.Size]byte, error) {
 	// Join the two content IDs together into a single base64-encoded sha256
 	// sum. This includes the original tool's content ID, and tool's own
 	// content ID.
 	hasher.Reset()
 	hasher.Write(inputHash)
 
-	toolID, err := buildidOf(execPath)
+	toolID, err := buildidOf(build, execPath)
 	if err != nil {
 		return [sha256.Size]byte{}, fmt.Errorf("retrieving buildid of %s: %w", execPath, err)
 	}
 
 	hasher.Write([]byte(toolID))
 
+	// Inputs that affect the generated code without being part of the tool
+	// itself, like configuration files, must invalidate the cache too.
+	for _, input := range cacheInputs {
+		hasher.Write(input)
+	}
+
 	// addToolToHash returns the sum buffer, so we need a new copy.
 	// Otherwise the next use of the global sumBuffer would conflict.
 	var sumBuffer [sha256.Size]byte
@@ -75,12 +90,11 @@ func addToolToHash(execPath string, inputHash []byte) ([sha256.Size]byte, error)
 	return sumBuffer, nil
 }
 
-func buildidOf(path string) (string, error) {
-	return execCmd("go", "tool", "buildid", path)
+func buildidOf(build buildContext, path string) (string, error) {
+	return execCmd(build.goCommand("tool", "buildid", path))
 }
 
-func execCmd(name string, arg ...string) (string, error) {
-	cmd := exec.Command(name, arg...)
+func execCmd(cmd *exec.Cmd) (string, error) {
 	out, err := cmd.Output()
 	if err != nil {
 		if err, _ := err.(*exec.ExitError); err != nil {
@@ -95,3 +109,32 @@ func execCmd(name string, arg ...string) (string, error) {
 func encodeBuildIDHash(h [sha256.Size]byte) string {
 	return base64.RawURLEncoding.EncodeToString(h[:buildIDHashLength])
 }
+
+// reproducibleTmpDir creates the tmp dir for [WithReproducible], named after the hash of the project root,
+// the package, its files and the build ID of the preprocessor, so the same inputs always get the same dir.
+func reproducibleTmpDir(build buildContext, root string, pkgPath string, goFiles []string) (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("retrieving executable path: %w", err)
+	}
+
+	toolID, err := buildidOf(build, execPath)
+	if err != nil {
+		return "", fmt.Errorf("retrieving buildid of %s: %w", execPath, err)
+	}
+
+	// The test variant of a package has the same path, but different files.
+	h := sha256.New()
+	for _, input := range append([]string{root, pkgPath, build.goos, build.arch(), toolID}, goFiles...) {
+		h.Write([]byte(input))
+		h.Write([]byte{0})
+	}
+	sum := h.Sum(nil)
+
+	dir := filepath.Join(os.TempDir(), goinject+"-"+hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,113 @@
+package goinject
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersionProbeTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tools are shell scripts")
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"})
+
+	tests := []struct {
+		tool string
+		// altered reports whether the version is expected to carry the build ID of the preprocessor.
+		altered bool
+	}{
+		{tool: "compile", altered: true},
+		{tool: "asm"},
+		{tool: "link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			version := tt.tool + " version go1.22.0 buildID=abc"
+			tool := filepath.Join(t.TempDir(), tt.tool)
+			if err := os.WriteFile(tool, []byte("#!/bin/sh\necho '"+version+"'\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			out := captureStdout(t, func() {
+				err = runCompile(t, dir, tool, []string{"-V=full"}, identity)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.altered {
+				if out != version {
+					t.Errorf("got version %q, want the one of the tool %q", out, version)
+				}
+				return
+			}
+			if !strings.HasPrefix(out, version+" +compile buildID=_/_/_/") {
+				t.Errorf("got version %q, want the one of the tool with the build ID of the preprocessor", out)
+			}
+		})
+	}
+}
+
+func TestProcessReproducible(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// wantSame tells whether both builds compile the same paths.
+		wantSame bool
+	}{
+		{name: "reproducible", opts: []Option{WithReproducible()}, wantSame: true},
+		{name: "random", wantSame: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The reproducible tmp dirs are kept, so they are made within the test's own.
+			t.Setenv("TMPDIR", t.TempDir())
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			// build returns the path to the modified file and its content.
+			build := func() (string, string) {
+				var path, content string
+				capture := WithBeforeCompile(func(args []string) error {
+					path = args[len(args)-1]
+					b, err := os.ReadFile(path)
+					content = string(b)
+					return err
+				})
+
+				tool, _ := fakeCompiler(t, 0)
+				args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+				if err := runCompile(t, dir, tool, args, appendCall("main", "", "println"), append(tt.opts, capture)...); err != nil {
+					t.Fatal(err)
+				}
+				return path, content
+			}
+
+			firstPath, firstContent := build()
+			secondPath, secondContent := build()
+			if firstContent != secondContent {
+				t.Errorf("got different modified files:\n%s\nand:\n%s", firstContent, secondContent)
+			}
+			if same := firstPath == secondPath; same != tt.wantSame {
+				t.Errorf("compiled %s and %s, same: %t, want %t", firstPath, secondPath, same, tt.wantSame)
+			}
+			// The line directive points at the original file, which is the only path in the output.
+			if strings.Contains(firstContent, filepath.Dir(firstPath)) {
+				t.Errorf("modified file refers to its tmp dir:\n%s", firstContent)
+			}
+		})
+	}
+}
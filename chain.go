@@ -0,0 +1,75 @@
+package goinject
+
+import (
+	"fmt"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// ModifierFunc is an adapter allowing the use of an ordinary function as a [Modifier],
+// e.g. to compose independent injectors with [Chain] without declaring a type for each:
+//
+//	goinject.Process(goinject.Chain(
+//		goinject.ModifierFunc(injectTracing),
+//		goinject.ModifierFunc(injectMetrics),
+//	))
+type ModifierFunc func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File
+
+// Modify calls fn(f, dec, res).
+func (fn ModifierFunc) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return fn(f, dec, res)
+}
+
+// chain applies several modifiers to a file in sequence, see [Chain].
+type chain []Modifier
+
+// Chain returns a modifier applying the given modifiers to each file in order.
+// Every modifier receives the file returned by the previous one, along with the same
+// decorator and restorer, and the imports injected by all of them are resolved together.
+//
+// Modifiers implementing [ModifierV2] receive the context of the file, and the first
+// error of a modifier implementing [ModifierE] stops the chain, as does a modifier returning nil.
+//
+// A [PackageModifier] or a [PackageFilesModifier] can not be chained, since it modifies
+// the whole package at once rather than the file, so Chain panics if it is given one.
+func Chain(modifiers ...Modifier) Modifier {
+	for _, modifier := range modifiers {
+		if isPackageModifier(modifier) {
+			panic(fmt.Sprintf("goinject: package modifier %T can not be chained", modifier))
+		}
+	}
+
+	return chain(modifiers)
+}
+
+func (c chain) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	for _, modifier := range c {
+		f = modifier.Modify(f, dec, res)
+		if f == nil {
+			return nil
+		}
+	}
+
+	return f
+}
+
+func (c chain) ModifyE(ctx *ModifyContext, f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) (*dst.File, error) {
+	for _, modifier := range c {
+		var err error
+		f, err = modify(modifier, ctx, f, dec, res)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, errNilFile(modifier)
+		}
+	}
+
+	return f, nil
+}
+
+// errNilFile is the error of a modifier returning nil instead of the file.
+func errNilFile(modifier Modifier) error {
+	return fmt.Errorf("modifier %T returned nil, files are dropped with ModifyContext.DropFile", modifier)
+}
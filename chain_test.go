@@ -0,0 +1,86 @@
+package goinject
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// failingModifier fails to modify every file with err.
+type failingModifier struct {
+	err error
+}
+
+func (m failingModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (m failingModifier) ModifyE(ctx *ModifyContext, f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) (*dst.File, error) {
+	return nil, m.err
+}
+
+func TestChainModifierError(t *testing.T) {
+	config, unit := testUnit(t, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	file, err := decorateFile(config, unit, unit.goFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsupported := errors.New("unsupported statement")
+	called := false
+	after := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		called = true
+		return f
+	})
+
+	// The error is reported against the file rather than crashing the preprocessor.
+	_, err = modify(Chain(identity, failingModifier{err: unsupported}, after), file.Context, file.File, file.Decorator, file.Restorer)
+	var modifyErr *ModifyError
+	if !errors.As(err, &modifyErr) || modifyErr.Path != unit.goFiles[0] || !errors.Is(err, unsupported) {
+		t.Errorf("got error %v, want the error of the modifier reported against %s", err, unit.goFiles[0])
+	}
+	if called {
+		t.Error("modifier after the failed one was called")
+	}
+}
+
+func TestChainPackageModifier(t *testing.T) {
+	for _, modifier := range []Modifier{panickingPackageModifier{Modifier: identity}, typeListModifier{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("chaining %T did not panic", modifier)
+				}
+			}()
+			Chain(identity, modifier)
+		}()
+	}
+}
+
+func TestChainImports(t *testing.T) {
+	config, unit := testUnit(t, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	modifier := Chain(appendCall("main", "strings", "ToUpper"), appendCall("main", "os", "Exit"))
+
+	file := modifiedFile(t, config, unit, unit.goFiles[0], modifier)
+	path, imports, err := processFile(config, unit, file, modifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each modifier gets the file of the previous one, so both calls survive.
+	if want := "strings.ToUpper(); os.Exit()"; !strings.Contains(string(content), want) {
+		t.Errorf("modified file does not contain %q:\n%s", want, content)
+	}
+	if got, want := importPaths(imports), []string{"os", "strings"}; !slices.Equal(got, want) {
+		t.Errorf("got imports %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package goinject
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	tmpDirsMu sync.Mutex
+	// tmpDirs are the tmp dirs of the runs in progress, removed if the process is interrupted.
+	tmpDirs = make(map[string]bool)
+)
+
+// trackTmpDir registers the directory for removal by [handleSignals]
+// until the returned function is called.
+//
+// The deferred removal runs on every return and panic, but not when cmd/go is interrupted
+// while the compiler is running: the signal is delivered to the whole process group,
+// and terminates the process without running deferred calls.
+func trackTmpDir(dir string) (untrack func()) {
+	tmpDirsMu.Lock()
+	defer tmpDirsMu.Unlock()
+
+	tmpDirs[dir] = true
+
+	return func() {
+		tmpDirsMu.Lock()
+		defer tmpDirsMu.Unlock()
+
+		delete(tmpDirs, dir)
+	}
+}
+
+// removeTmpDirs removes the tracked tmp dirs.
+func removeTmpDirs() {
+	tmpDirsMu.Lock()
+	defer tmpDirsMu.Unlock()
+
+	for dir := range tmpDirs {
+		os.RemoveAll(dir)
+		delete(tmpDirs, dir)
+	}
+}
+
+// handleSignals removes the tmp dirs of the runs in progress if the process is interrupted
+// or terminated before the returned stop function is called, and then exits as the signal
+// would have done.
+//
+// Only [Process] and [Main] handle the signals, since they own the process of the preprocessor.
+// [ProcessE] and [ProcessContext] leave the signals to the program embedding them.
+func handleSignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			removeTmpDirs()
+
+			// Mimic the exit status of a process terminated by the signal.
+			code := 1
+			if sysSig, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(sysSig)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,63 @@
+package goinject
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestRemoveTmpDirs(t *testing.T) {
+	tracked, untracked := t.TempDir(), t.TempDir()
+	trackTmpDir(tracked)
+	trackTmpDir(untracked)()
+
+	removeTmpDirs()
+
+	if _, err := os.Stat(tracked); !os.IsNotExist(err) {
+		t.Errorf("tracked dir was not removed: %v", err)
+	}
+	if _, err := os.Stat(untracked); err != nil {
+		t.Errorf("untracked dir was removed: %s", err)
+	}
+	if len(tmpDirs) != 0 {
+		t.Errorf("removed dirs are still tracked: %v", tmpDirs)
+	}
+}
+
+func TestProcessRemovesTmpDirOnPanic(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	panicking := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		panic("modifier failed")
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+	err := runCompile(t, dir, tool, args, panicking)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "modifier failed" {
+		t.Fatalf("got error %v, want the panic of the modifier", err)
+	}
+
+	if left := leftoverTmpDirs(t, tmp); len(left) > 0 {
+		t.Errorf("tmp dirs %q were left behind after the modifier panicked", left)
+	}
+	if len(tmpDirs) != 0 {
+		t.Errorf("tmp dirs of finished runs are still tracked: %v", tmpDirs)
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Error("compiler was run despite the panic")
+	}
+}
@@ -0,0 +1,38 @@
+package goinject
+
+import "github.com/dave/dst"
+
+// A node must appear in the tree at most once, otherwise modifying it through one
+// occurrence silently changes the other one, and the restorer may misplace decorations.
+// The helpers below deep-copy nodes, including their decorations and the import paths
+// of identifiers, so a declaration can be duplicated and the copy modified safely.
+//
+// Cloned nodes are new nodes: they have no original position, so [ModifyContext.Pos]
+// and [ModifyContext.TypeOf] know nothing about them.
+
+// CloneDecl returns a deep copy of the declaration, or nil if decl is nil.
+func CloneDecl(decl dst.Decl) dst.Decl {
+	if decl == nil {
+		return nil
+	}
+
+	return dst.Clone(decl).(dst.Decl)
+}
+
+// CloneStmt returns a deep copy of the statement, or nil if stmt is nil.
+func CloneStmt(stmt dst.Stmt) dst.Stmt {
+	if stmt == nil {
+		return nil
+	}
+
+	return dst.Clone(stmt).(dst.Stmt)
+}
+
+// CloneExpr returns a deep copy of the expression, or nil if expr is nil.
+func CloneExpr(expr dst.Expr) dst.Expr {
+	if expr == nil {
+		return nil
+	}
+
+	return dst.Clone(expr).(dst.Expr)
+}
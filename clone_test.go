@@ -0,0 +1,71 @@
+package goinject
+
+import (
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestCloneDecl(t *testing.T) {
+	src := `package main
+
+import "fmt"
+
+// greet greets.
+func greet() {
+	fmt.Println("hello") // say hello
+}
+
+func main() {}
+`
+	modifier := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		var greet *dst.FuncDecl
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == "greet" {
+				greet = fn
+			}
+		}
+
+		clone := CloneDecl(greet).(*dst.FuncDecl)
+		clone.Name.Name = "greetTwice"
+		clone.Decs.Start.Replace("// greetTwice greets twice.")
+		clone.Body.List = append(clone.Body.List, CloneStmt(clone.Body.List[0]))
+		call := clone.Body.List[1].(*dst.ExprStmt).X.(*dst.CallExpr)
+		call.Args[0] = &dst.BasicLit{Kind: token.STRING, Value: `"again"`}
+		f.Decls = append(f.Decls, clone)
+
+		if CloneDecl(nil) != nil || CloneStmt(nil) != nil || CloneExpr(nil) != nil {
+			t.Error("cloning nil returned a node")
+		}
+
+		return f
+	})
+	got := modifiedSource(t, src, modifier)
+
+	want := `package main
+
+import "fmt"
+
+// greet greets.
+func greet() {
+	fmt.Println("hello") // say hello
+}
+
+func main() {}
+
+// greetTwice greets twice.
+func greetTwice() {
+	fmt.Println("hello") // say hello
+	fmt.Println("again") // say hello
+}
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if strings.Count(got, "import") != 1 {
+		t.Errorf("the import of the cloned call was duplicated:\n%s", got)
+	}
+}
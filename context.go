@@ -0,0 +1,253 @@
+package goinject
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	dstresolver "github.com/dave/dst/decorator/resolver"
+)
+
+// ModifierV2 is an extension of [Modifier] for modifiers that need to know
+// more about the file being modified, or to report diagnostics.
+//
+// If the modifier passed to [Process] implements ModifierV2,
+// ModifyWithContext is called instead of Modify.
+type ModifierV2 interface {
+	Modifier
+	ModifyWithContext(*ModifyContext, *dst.File, *decorator.Decorator, *decorator.Restorer) *dst.File
+}
+
+// ModifierE is an extension of [Modifier] for modifiers that may fail to modify a file,
+// e.g. on code they do not support, and report it rather than panicking.
+//
+// If the modifier passed to [Process] implements ModifierE, ModifyE is called instead of Modify
+// and ModifyWithContext. If it returns an error, the build fails with the error reported
+// against the file, like a diagnostic of the compiler, see [ModifyContext.Errorf].
+type ModifierE interface {
+	Modifier
+	ModifyE(*ModifyContext, *dst.File, *decorator.Decorator, *decorator.Restorer) (*dst.File, error)
+}
+
+// ModifyError is the error a [ModifierE] failed to modify a file with.
+type ModifyError struct {
+	// Path is the path to the original file.
+	Path string
+	// Position is the position in the original file the error is reported at, if known.
+	Position token.Position
+	// Err is the error returned by the modifier.
+	Err error
+}
+
+// Error formats the error like a diagnostic of the compiler, e.g. `main.go:12:3: unsupported statement`.
+func (e *ModifyError) Error() string {
+	if e.Position.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Position, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *ModifyError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is the error [ProcessE] returns if the modifier panics,
+// so a driver embedding the preprocessor survives a bug of the modifier.
+// [Process] panics with it.
+type PanicError struct {
+	// Value is the value the modifier panicked with.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("modifier panicked: %v\n\n%s", e.Value, e.Stack)
+}
+
+// ModifyContext describes the file being modified.
+type ModifyContext struct {
+	// Path is the path to the original file being modified.
+	Path string
+	// PackagePath is the import path of the package the file belongs to, as passed to the compiler
+	// with the -p flag. It is "main" for main packages, and has the "_test" suffix
+	// for the external test packages.
+	PackagePath string
+	// ModuleRoot is the directory of the go.mod file of the module the file belongs to,
+	// or an empty string if the file is not inside of a module.
+	ModuleRoot string
+	// GoVersion is the Go language version the file is compiled with, as declared
+	// by the go directive of the module, in the form of [go/version], e.g. go1.21.
+	// Modifiers may compare it with [go/version.Compare] to decide whether newer
+	// language features, like generics or the min and max builtins, can be injected.
+	GoVersion string
+	// CompileArgs are the arguments the compiler was called with, including the original files.
+	// Modifiers may inspect them for the flags in effect, like -N or -l.
+	CompileArgs []string
+	// Race reports whether the package is compiled with the race detector enabled, see `go build -race`.
+	Race bool
+	// Cover reports whether the package is compiled with coverage instrumentation, see `go build -cover`.
+	// The go command usually hands the compiler the copies of the files instrumented by the cover tool,
+	// which are not project files, so it is only set for the project files compiled along with them.
+	Cover bool
+	// Settings are the settings of the package from the file given to [WithConfigFile], or nil.
+	Settings map[string]any
+	// BuildTags are the build tags of the build, as set with -tags in GOFLAGS and with [WithBuildTags].
+	// The go command does not pass the -tags flag of `go build` to the tools, so those are unknown.
+	BuildTags []string
+	// Test reports whether the package is compiled for `go test`, along with its _test.go files.
+	// The external test package is compiled with its _test.go files only, so it is always set for it.
+	Test bool
+	// TmpDir is the directory the modified files of the package are written to.
+	// Modifiers may write the other files they generate for the package there,
+	// which are removed along with it after compilation, unless it is retained
+	// with [WithKeepTempFiles] or [WithReproducible].
+	TmpDir string
+
+	dec    *decorator.Decorator
+	unit   *compileUnit
+	stderr io.Writer
+
+	// identResolver resolves the packages of the identifiers of the file, see [ModifyContext.SiblingFiles].
+	identResolver dstresolver.DecoratorResolver
+}
+
+// TypesInfo returns the type information of the package the file belongs to.
+// The package is type-checked on the first call, so modifiers that do not
+// need types do not pay for it.
+//
+// The information describes the original source, so it is only available
+// for nodes that were not added by modifiers.
+func (c *ModifyContext) TypesInfo() (*types.Info, error) {
+	_, info, err := c.unit.typeCheck(c.Path)
+	return info, err
+}
+
+// TypesPackage returns the type-checked package the file belongs to, e.g. to look up
+// the objects declared at the package level with its scope. Like [ModifyContext.TypesInfo],
+// the package is type-checked on the first call, and describes the original source.
+func (c *ModifyContext) TypesPackage() (*types.Package, error) {
+	pkg, _, err := c.unit.typeCheck(c.Path)
+	return pkg, err
+}
+
+// TypeOf returns the type of the given expression, or the type of the function
+// declared by the given [dst.FuncDecl]. It returns nil if the type is unknown,
+// for example for nodes added by modifiers or if the package failed to type-check.
+func (c *ModifyContext) TypeOf(node dst.Node) types.Type {
+	info, err := c.TypesInfo()
+	if err != nil {
+		return nil
+	}
+
+	switch astNode := c.dec.Ast.Nodes[node].(type) {
+	case *ast.FuncDecl:
+		if obj := info.Defs[astNode.Name]; obj != nil {
+			return obj.Type()
+		}
+	case ast.Expr:
+		return info.TypeOf(astNode)
+	}
+
+	return nil
+}
+
+// ObjectOf returns the object the identifier denotes or defines, e.g. to tell a call of a method
+// from a call of a function or of a local variable shadowing it. It returns nil if the object
+// is unknown, for example for identifiers added by modifiers or if the package failed to type-check.
+func (c *ModifyContext) ObjectOf(ident *dst.Ident) types.Object {
+	info, err := c.TypesInfo()
+	if err != nil {
+		return nil
+	}
+
+	// Qualified identifiers, like fmt.Println, are decorated into a single identifier
+	// with the path of the package, so they come from the selector expression.
+	switch astNode := c.dec.Ast.Nodes[ident].(type) {
+	case *ast.Ident:
+		return info.ObjectOf(astNode)
+	case *ast.SelectorExpr:
+		return info.ObjectOf(astNode.Sel)
+	}
+
+	return nil
+}
+
+// Ident returns the name for an injected identifier, namespaced with the prefix configured with
+// [WithIdentPrefix] so it does not collide with identifiers of the user code. Modifiers should
+// name their own injected variables, functions and labels with it as well.
+func (c *ModifyContext) Ident(name string) string {
+	return c.unit.identPrefix + name
+}
+
+// Pos returns the position of the node in the original source file.
+// Nodes added by modifiers have no original position, so [token.NoPos] is returned for them.
+func (c *ModifyContext) Pos(node dst.Node) token.Pos {
+	astNode, ok := c.dec.Ast.Nodes[node]
+	if !ok {
+		return token.NoPos
+	}
+
+	return astNode.Pos()
+}
+
+// Warnf reports a warning at the given position of the original source file
+// without failing the build. Positions can be obtained with [ModifyContext.Pos].
+//
+// The warning is printed to stderr in the same `file:line:col: warning: message`
+// form the compiler uses for its diagnostics, so editors and CI tools can pick it up.
+func (c *ModifyContext) Warnf(pos token.Pos, format string, args ...any) {
+	position := c.dec.Fset.Position(pos)
+	if !position.IsValid() {
+		position = token.Position{Filename: c.Path}
+	}
+
+	fmt.Fprintf(c.stderr, "%s: warning: %s\n", position, fmt.Sprintf(format, args...))
+}
+
+// Errorf returns a [ModifyError] at the given position of the original source file,
+// for a [ModifierE] to fail with. Positions can be obtained with [ModifyContext.Pos].
+func (c *ModifyContext) Errorf(pos token.Pos, format string, args ...any) error {
+	return &ModifyError{
+		Path:     c.Path,
+		Position: c.dec.Fset.Position(pos),
+		Err:      fmt.Errorf(format, args...),
+	}
+}
+
+// Skipper is an optional interface of a [Modifier] that decides whether the file it was applied to
+// must be compiled as is. Skip is called with the modified file, before it is printed,
+// and if it returns true, the original file is compiled instead, without patching importcfg for it.
+//
+// This allows a modifier to inspect a file within Modify and leave it alone if there is nothing
+// to change, which spares printing it. The package is still compiled under the build ID of
+// the preprocessor, so the build cache is not shared with builds without it.
+type Skipper interface {
+	Skip(*dst.File) bool
+}
+
+// modify applies the modifier to the file, passing the context
+// to modifiers that are able to accept it. The errors of [ModifierE] are returned
+// as [ModifyError], so they are reported against the file.
+func modify(modifier Modifier, ctx *ModifyContext, f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) (*dst.File, error) {
+	if modifierE, ok := modifier.(ModifierE); ok {
+		f, err := modifierE.ModifyE(ctx, f, dec, res)
+		var modifyErr *ModifyError
+		if err != nil && !errors.As(err, &modifyErr) {
+			err = &ModifyError{Path: ctx.Path, Err: err}
+		}
+		return f, err
+	}
+
+	if modifierV2, ok := modifier.(ModifierV2); ok {
+		return modifierV2.ModifyWithContext(ctx, f, dec, res), nil
+	}
+
+	return modifier.Modify(f, dec, res), nil
+}
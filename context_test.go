@@ -0,0 +1,175 @@
+package goinject
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// warningModifier warns at every function declaration.
+type warningModifier struct{}
+
+func (warningModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (warningModifier) ModifyWithContext(ctx *ModifyContext, f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok {
+			ctx.Warnf(ctx.Pos(fn), "function %s is not instrumented", fn.Name.Name)
+		}
+	}
+
+	return f
+}
+
+func TestModifyContextWarnf(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// want is the position of the warning relative to the directory of the package.
+		want string
+	}{
+		{
+			name: "source",
+			src:  "package main\n\nfunc main() {}\n",
+			want: "main.go:3:1",
+		},
+		{
+			// Generated code points to its own source with line directives, like the compiler does.
+			name: "line directive",
+			src:  "package main\n\n//line parser.y:12\nfunc main() {}\n",
+			want: "parser.y:12",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   tt.src,
+				"importcfg": "",
+			})
+
+			var stderr bytes.Buffer
+			tool, argsFile := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+			if err := runCompile(t, dir, tool, args, warningModifier{}, WithStderr(&stderr)); err != nil {
+				t.Fatalf("the warning failed the build: %s", err)
+			}
+
+			want := filepath.Join(dir, tt.want) + ": warning: function main is not instrumented\n"
+			if stderr.String() != want {
+				t.Errorf("got stderr %q, want %q", stderr.String(), want)
+			}
+			if _, err := os.Stat(argsFile); err != nil {
+				t.Error("compiler was not run after the warning")
+			}
+		})
+	}
+}
+
+// skippingModifier injects a call of a package named after the function into every function,
+// and skips the files without a function to trace.
+type skippingModifier struct{}
+
+func (skippingModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok {
+			fn.Body.List = append(fn.Body.List, &dst.ExprStmt{X: Call("example.com/trace/"+fn.Name.Name, "Trace")})
+		}
+	}
+	return f
+}
+
+func (skippingModifier) Skip(f *dst.File) bool {
+	return !slices.ContainsFunc(f.Decls, func(decl dst.Decl) bool {
+		fn, ok := decl.(*dst.FuncDecl)
+		return ok && strings.HasPrefix(fn.Name.Name, "traced")
+	})
+}
+
+func TestProcessSkipper(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc tracedRun() { helper() }\n\nfunc helper() {}\n",
+		"other.go":  "package main\n\nfunc other() {}\n",
+		"importcfg": "",
+	})
+	t.Cleanup(resetImportcfgAdditions)
+
+	var resolved []string
+	resolver := func(pkgName string) (map[string]string, error) {
+		resolved = append(resolved, pkgName)
+		return map[string]string{pkgName: "/fake/" + filepath.Base(pkgName) + ".a"}, nil
+	}
+
+	tool, argsFile := fakeCompiler(t, 0)
+	mainFile, otherFile := filepath.Join(dir, "main.go"), filepath.Join(dir, "other.go")
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile, otherFile}
+	if err := runCompile(t, dir, tool, args, skippingModifier{}, WithPackageResolver(resolver)); err != nil {
+		t.Fatal(err)
+	}
+
+	compiled := compiledArgs(t, argsFile)
+	if compiled[len(compiled)-2] == mainFile {
+		t.Error("traced file was compiled as is")
+	}
+	if compiled[len(compiled)-1] != otherFile {
+		t.Errorf("skipped file was compiled from %s, want the original %s", compiled[len(compiled)-1], otherFile)
+	}
+	// The imports are patched for the modified file only.
+	slices.Sort(resolved)
+	if want := []string{"example.com/trace/helper", "example.com/trace/tracedRun"}; !slices.Equal(resolved, want) {
+		t.Errorf("resolved %q, want the imports of the traced file %q", resolved, want)
+	}
+}
+
+func TestModifyContextCompileArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		race  bool
+		cover bool
+	}{
+		{name: "plain"},
+		{name: "race", flags: []string{"-race"}, race: true},
+		{name: "cover", flags: []string{"-coveragecfg", "/tmp/cover.cfg"}, cover: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			var got *ModifyContext
+			modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) { got = ctx })
+
+			tool, _ := fakeCompiler(t, 0)
+			args := append([]string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg")}, tt.flags...)
+			args = append(args, "-pack", filepath.Join(dir, "main.go"))
+			if err := runCompile(t, dir, tool, args, modifier); err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got.CompileArgs, args) {
+				t.Errorf("got compile args %q, want %q", got.CompileArgs, args)
+			}
+			if got.Race != tt.race || got.Cover != tt.cover {
+				t.Errorf("got race %t and cover %t, want %t and %t", got.Race, got.Cover, tt.race, tt.cover)
+			}
+		})
+	}
+}
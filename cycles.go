@@ -0,0 +1,146 @@
+package goinject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// checkImportCycles returns an error if any of the imports injected into the file
+// depends on the package being compiled, since the compiler would reject the resulting
+// import cycle with an error pointing to the modified file rather than to the injection.
+func (u *compileUnit) checkImportCycles(build buildContext, path string, fileImports []*ast.ImportSpec) error {
+	// Nothing can import a main package, so injections into it can not create a cycle.
+	if u.pkgPath == "" || u.pkgPath == "main" {
+		return nil
+	}
+
+	original, err := u.parse(path)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for _, spec := range original.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err == nil {
+			existing[importPath] = true
+		}
+	}
+
+	var injected []string
+	for _, spec := range fileImports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || existing[importPath] || importPath == "unsafe" || importPath == "C" {
+			continue
+		}
+		injected = append(injected, importPath)
+	}
+	if len(injected) == 0 {
+		return nil
+	}
+
+	// The lock is held while listing, so concurrently modified files do not list the same packages.
+	u.importGraphMu.Lock()
+	defer u.importGraphMu.Unlock()
+
+	if err := u.loadImportGraph(build, injected); err != nil {
+		return err
+	}
+
+	for _, importPath := range injected {
+		if chain := importChain(u.importGraph, importPath, u.pkgPath); chain != nil {
+			cycle := append([]string{u.pkgPath}, chain...)
+			return fmt.Errorf("injecting import %s into %s creates cycle %s", importPath, u.pkgPath, strings.Join(cycle, " → "))
+		}
+	}
+
+	return nil
+}
+
+// loadImportGraph adds the imports of the packages and of all of their dependencies
+// to the import graph of the unit. It must be called with importGraphMu held.
+//
+// The graph is kept for the whole compile unit, and only the packages missing from it
+// are listed, so the files injecting the same imports share a single `go list` call.
+func (u *compileUnit) loadImportGraph(build buildContext, pkgs []string) error {
+	if u.importGraph == nil {
+		u.importGraph = make(map[string][]string)
+	}
+
+	var missing []string
+	for _, pkg := range pkgs {
+		if _, ok := u.importGraph[pkg]; !ok {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	args := []string{"list", "-json", "-deps"}
+	args = append(args, build.buildFlags()...)
+	args = append(args, "--")
+	args = append(args, missing...)
+
+	cmd := build.goCommand(args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %q: %w", cmd.Args, err)
+	}
+
+	type listItem struct {
+		ImportPath string   // The import path of the package
+		Imports    []string // The import paths used by the package
+	}
+
+	dec := json.NewDecoder(&stdout)
+	for {
+		var item listItem
+		if err := dec.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("parsing `go list` output: %w", err)
+		}
+		u.importGraph[item.ImportPath] = item.Imports
+	}
+
+	return nil
+}
+
+// importChain returns the chain of imports leading from the package from to the package to,
+// including both of them, or nil if from does not depend on to.
+func importChain(graph map[string][]string, from string, to string) []string {
+	// Breadth-first search finds the shortest chain, which makes for the clearest error.
+	parents := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+
+		if pkg == to {
+			var chain []string
+			for ; pkg != ""; pkg = parents[pkg] {
+				chain = append(chain, pkg)
+			}
+			slices.Reverse(chain)
+
+			return chain
+		}
+
+		for _, imported := range graph[pkg] {
+			if _, seen := parents[imported]; !seen {
+				parents[imported] = pkg
+				queue = append(queue, imported)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package goinject
+
+import (
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckImportCycles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		"a/a.go": "package a\n\nfunc A() {}\n",
+		"b/b.go": "package b\n\nimport \"example.com/app/c\"\n\nfunc B() { c.C() }\n",
+		"c/c.go": "package c\n\nimport \"example.com/app/a\"\n\nfunc C() { a.A() }\n",
+		"d/d.go": "package d\n\nfunc D() {}\n",
+	})
+
+	chdir(t, dir)
+
+	build := newBuildContext(nil, nil, "")
+	build.profile = &profile{}
+	unit := &compileUnit{pkgPath: "example.com/app/a", fset: token.NewFileSet()}
+	path := filepath.Join(dir, "a", "a.go")
+
+	tests := []struct {
+		name    string
+		imports []string
+		want    string
+	}{
+		{
+			name:    "cycle",
+			imports: []string{"example.com/app/d", "example.com/app/b"},
+			want:    "creates cycle example.com/app/a → example.com/app/b → example.com/app/c → example.com/app/a",
+		},
+		{
+			name:    "no cycle",
+			imports: []string{"example.com/app/d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var specs []*ast.ImportSpec
+			for _, importPath := range tt.imports {
+				specs = append(specs, &ast.ImportSpec{Path: &ast.BasicLit{Value: strconv.Quote(importPath)}})
+			}
+
+			err := unit.checkImportCycles(build, path, specs)
+			if tt.want == "" && err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// The dependencies of the imports are listed once for the whole unit.
+	if got := build.profile.goCommands.Load(); got != 1 {
+		t.Errorf("ran %d go commands, want 1", got)
+	}
+}
+
+func TestProcessImportCycleCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"a/a.go":    "package a\n\nfunc A() {}\n",
+		"b/b.go":    "package b\n\nimport \"example.com/app/a\"\n\nfunc B() { a.A() }\n",
+		"importcfg": "",
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "example.com/app/a", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "a", "a.go")}
+	err := runCompile(t, filepath.Join(dir, "a"), tool, args, appendCall("A", "example.com/app/b", "B"), WithImportCycleCheck())
+
+	want := "injecting import example.com/app/b into example.com/app/a creates cycle example.com/app/a → example.com/app/b → example.com/app/a"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want one containing %q", err, want)
+	}
+	if _, statErr := os.Stat(argsFile); statErr == nil {
+		t.Error("compiler was run despite the import cycle")
+	}
+}
@@ -0,0 +1,188 @@
+package goinject
+
+import (
+	"go/token"
+	"slices"
+
+	"github.com/dave/dst"
+)
+
+// initMarker returns the comment marking an init function added with the given key.
+func initMarker(key string) string {
+	return "// Injected by goinject: " + key
+}
+
+// EnsureInit adds an init function with the given body to the file,
+// unless an init function with the same key was already added to it.
+// The key is recorded in a comment above the function.
+//
+// It reports whether the function was added. See [ModifyContext.EnsureInit]
+// to add the function to only one file of the package.
+func EnsureInit(f *dst.File, key string, body []dst.Stmt) bool {
+	if hasInit(f, key) {
+		return false
+	}
+
+	decl := &dst.FuncDecl{
+		Name: dst.NewIdent("init"),
+		Type: &dst.FuncType{},
+		Body: &dst.BlockStmt{List: body},
+	}
+	decl.Decs.Before = dst.EmptyLine
+	decl.Decs.Start.Append(initMarker(key))
+
+	f.Decls = append(f.Decls, decl)
+
+	return true
+}
+
+// hasInit reports whether the file has an init function added with the given key.
+func hasInit(f *dst.File, key string) bool {
+	for _, decl := range f.Decls {
+		funcDecl, ok := decl.(*dst.FuncDecl)
+		if !ok || funcDecl.Recv != nil || funcDecl.Name.Name != "init" {
+			continue
+		}
+
+		for _, comment := range funcDecl.Decs.Start.All() {
+			if comment == initMarker(key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// EnsurePackageVar declares a package-level variable with the given type and value in the file,
+// unless the file already declares a package-level identifier with the same name.
+// Either typ or value may be nil.
+//
+// It reports whether the variable was declared. See [ModifyContext.EnsurePackageVar]
+// to declare the variable in only one file of the package.
+func EnsurePackageVar(f *dst.File, name string, typ dst.Expr, value dst.Expr) bool {
+	if declares(f, name) {
+		return false
+	}
+
+	spec := &dst.ValueSpec{
+		Names: []*dst.Ident{dst.NewIdent(name)},
+		Type:  typ,
+	}
+	if value != nil {
+		spec.Values = []dst.Expr{value}
+	}
+
+	decl := &dst.GenDecl{
+		Tok:   token.VAR,
+		Specs: []dst.Spec{spec},
+	}
+	decl.Decs.Before = dst.EmptyLine
+
+	f.Decls = append(f.Decls, decl)
+
+	return true
+}
+
+// declares reports whether the file declares a package-level identifier with the given name.
+func declares(f *dst.File, name string) bool {
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *dst.FuncDecl:
+			if decl.Recv == nil && decl.Name.Name == name {
+				return true
+			}
+		case *dst.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *dst.ValueSpec:
+					for _, ident := range spec.Names {
+						if ident.Name == name {
+							return true
+						}
+					}
+				case *dst.TypeSpec:
+					if spec.Name.Name == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// EnsureInit adds an init function with the given body to the file, like [EnsureInit],
+// unless one with the same key was already added to any file of the package.
+//
+// It coordinates the files modified within a single compilation, so modifiers
+// processing the files one by one may call it for every file of the package.
+// The function counts for the package once the file is written, so a file compiled
+// as is after all, e.g. skipped with [Skipper] or dropped with [ModifyContext.DropFile],
+// does not keep the other files from adding it. Files modified concurrently may all
+// add the function, in which case it is kept in the file written first only.
+func (c *ModifyContext) EnsureInit(f *dst.File, key string, body []dst.Stmt) bool {
+	return c.unit.ensure(c.Path, "init "+key, f, func() bool {
+		return EnsureInit(f, key, body)
+	})
+}
+
+// EnsurePackageVar declares a package-level variable in the file, like [EnsurePackageVar],
+// unless the variable was already declared with it in any file of the package.
+//
+// It coordinates the files modified within a single compilation like [ModifyContext.EnsureInit].
+// Identifiers declared by the original files of the package are not checked,
+// so the name should be obtained with [ModifyContext.Ident] to avoid conflicts with them.
+func (c *ModifyContext) EnsurePackageVar(f *dst.File, name string, typ dst.Expr, value dst.Expr) bool {
+	return c.unit.ensure(c.Path, "var "+name, f, func() bool {
+		return EnsurePackageVar(f, name, typ, value)
+	})
+}
+
+// ensure adds the declaration with the given key to the file of the original file at path
+// with the add function, unless it was already added to a file written for the compile unit
+// or to this very file. The declaration added is pending until the file is written, see [compileUnit.commitEnsured].
+func (u *compileUnit) ensure(path string, key string, f *dst.File, add func() bool) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.pendingEnsured[path][key]; ok || u.ensured[key] {
+		return false
+	}
+
+	if !add() {
+		return false
+	}
+
+	if u.pendingEnsured == nil {
+		u.pendingEnsured = make(map[string]map[string]dst.Decl)
+	}
+	if u.pendingEnsured[path] == nil {
+		u.pendingEnsured[path] = make(map[string]dst.Decl)
+	}
+	u.pendingEnsured[path][key] = f.Decls[len(f.Decls)-1]
+
+	return true
+}
+
+// commitEnsured records the declarations pending for the original file at path as added
+// to the compile unit, right before its modified copy f is written. The declarations
+// another file was written with first are removed from f instead.
+func (u *compileUnit) commitEnsured(path string, f *dst.File) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for key, decl := range u.pendingEnsured[path] {
+		if u.ensured[key] {
+			f.Decls = slices.DeleteFunc(f.Decls, func(d dst.Decl) bool { return d == decl })
+			continue
+		}
+
+		if u.ensured == nil {
+			u.ensured = make(map[string]bool)
+		}
+		u.ensured[key] = true
+	}
+	delete(u.pendingEnsured, path)
+}
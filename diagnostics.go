@@ -0,0 +1,128 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lineMap maps the lines of a modified file to the lines of its original file, see [WithErrorTranslation].
+type lineMap struct {
+	// original is the path to the original file.
+	original string
+	// lines maps the lines where original code starts, like [sourceMap.Lines].
+	lines map[int]int
+}
+
+// translate returns the line of the original file the line of the modified file comes from,
+// and whether the line was injected by the modifier rather than being a line of the original code.
+// Injected lines are attributed to the closest preceding original line.
+func (m lineMap) translate(line int) (int, bool) {
+	if original, ok := m.lines[line]; ok {
+		return original, false
+	}
+
+	closest := 0
+	for generated := range m.lines {
+		if generated < line && generated > closest {
+			closest = generated
+		}
+	}
+	if closest == 0 {
+		return line, true
+	}
+
+	return m.lines[closest], true
+}
+
+// recordLineMap records the line mapping of the modified file at generated.
+// The compiler reports the positions in the file either against the modified file itself
+// or, through the line directive, against the original one, so both paths are recorded.
+func (u *compileUnit) recordLineMap(generated string, original string, lines map[int]int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.lineMaps == nil {
+		u.lineMaps = make(map[string]lineMap)
+	}
+
+	m := lineMap{original: original, lines: lines}
+	u.lineMaps[generated] = m
+	u.lineMaps[original] = m
+}
+
+// translateDiagnostics rewrites the positions in the modified files reported by the compiler,
+// like `/tmp/goinject123/1f2e3d4c5b6a7980/main.go:42:7: undefined: trace`, to the positions
+// in the original files. The column of a line injected by the modifier cannot be translated,
+// so it is dropped, and a diagnostic starting at such a line is marked as coming from injected code.
+func translateDiagnostics(output []byte, lineMaps map[string]lineMap) []byte {
+	if len(lineMaps) == 0 {
+		return output
+	}
+
+	paths := make([]string, 0, len(lineMaps))
+	for path := range lineMaps {
+		paths = append(paths, regexp.QuoteMeta(path))
+	}
+	// Longer paths go first, so a path is not matched by a shorter path it ends with.
+	slices.SortFunc(paths, func(a, b string) int { return len(b) - len(a) })
+	positionRe := regexp.MustCompile(`(` + strings.Join(paths, "|") + `):(\d+)(:\d+)?`)
+
+	lines := bytes.SplitAfter(output, []byte("\n"))
+	for idx, line := range lines {
+		var injected bool
+		line = positionRe.ReplaceAllFunc(line, func(position []byte) []byte {
+			match := positionRe.FindSubmatch(position)
+			m := lineMaps[string(match[1])]
+			generatedLine, err := strconv.Atoi(string(match[2]))
+			if err != nil {
+				return position
+			}
+
+			originalLine, isInjected := m.translate(generatedLine)
+			if isInjected {
+				if bytes.HasPrefix(lines[idx], position) {
+					injected = true
+				}
+				return []byte(fmt.Sprintf("%s:%d", m.original, originalLine))
+			}
+
+			return []byte(fmt.Sprintf("%s:%d%s", m.original, originalLine, match[3]))
+		})
+
+		if injected {
+			body, newline := bytes.CutSuffix(line, []byte("\n"))
+			line = append(body, " (in code injected by the modifier)"...)
+			if newline {
+				line = append(line, '\n')
+			}
+		}
+		lines[idx] = line
+	}
+
+	return bytes.Join(lines, nil)
+}
+
+// runTranslatedCommand is like [runCommand], but translates the positions in the modified files
+// reported by the command to the positions in the original files, see [WithErrorTranslation].
+// The output is buffered, since a position may only be translated as a whole.
+func runTranslatedCommand(build buildContext, tool string, args []string, lineMaps map[string]lineMap) error {
+	var stdout, stderr bytes.Buffer
+	cmd := build.command(tool, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	build.stdout.Write(translateDiagnostics(stdout.Bytes(), lineMaps))
+	build.stderr.Write(translateDiagnostics(stderr.Bytes(), lineMaps))
+
+	if runErr != nil {
+		return fmt.Errorf("running %s: %w", filepath.Base(tool), runErr)
+	}
+
+	return nil
+}
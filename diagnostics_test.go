@@ -0,0 +1,108 @@
+package goinject
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// realCompiler returns the path to the compiler of the installed go toolchain.
+func realCompiler(t *testing.T) string {
+	t.Helper()
+
+	out, err := exec.Command("go", "env", "GOTOOLDIR").Output()
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	return filepath.Join(strings.TrimSpace(string(out)), "compile")
+}
+
+func TestProcessErrorTranslation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// want is the diagnostic of the compiler, relative to the directory of the package.
+		want string
+	}{
+		{
+			// The injected call is attributed to the original line it follows.
+			name: "translated",
+			opts: []Option{WithErrorTranslation()},
+			want: "main.go:4: undefined: trace (in code injected by the modifier)\n",
+		},
+		{
+			// The line directive makes the compiler report the original file, but not the line the call follows.
+			name: "untranslated",
+			want: "main.go:5:2: undefined: trace\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {\n\tprintln()\n}\n",
+				"importcfg": "",
+			})
+
+			// The compiler reports the errors on its standard output.
+			var stdout bytes.Buffer
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-o", filepath.Join(dir, "_pkg_.a"), "-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			modifier := appendCall("main", "", "trace")
+			if err := runCompile(t, dir, realCompiler(t), args, modifier, append(tt.opts, WithStdout(&stdout))...); err == nil {
+				t.Fatal("reference to an undefined identifier compiled")
+			}
+
+			if want := filepath.Join(dir, tt.want); stdout.String() != want {
+				t.Errorf("got diagnostics %q, want %q", stdout.String(), want)
+			}
+		})
+	}
+}
+
+func TestTranslateDiagnostics(t *testing.T) {
+	lineMaps := map[string]lineMap{
+		"/tmp/goinject1/ab/main.go": {original: "/src/app/main.go", lines: map[int]int{1: 1, 3: 3, 6: 4}},
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "original line",
+			output: "/tmp/goinject1/ab/main.go:6:3: x declared and not used\n",
+			want:   "/src/app/main.go:4:3: x declared and not used\n",
+		},
+		{
+			name:   "injected line",
+			output: "/tmp/goinject1/ab/main.go:5:2: undefined: trace\n",
+			want:   "/src/app/main.go:3: undefined: trace (in code injected by the modifier)\n",
+		},
+		{
+			// Only the position the diagnostic starts with marks it as coming from injected code.
+			name:   "injected line referenced",
+			output: "/src/app/other.go:2:1: other declaration of f, see /tmp/goinject1/ab/main.go:4:1\n",
+			want:   "/src/app/other.go:2:1: other declaration of f, see /src/app/main.go:3\n",
+		},
+		{
+			name:   "other file",
+			output: "/src/app/other.go:2:1: syntax error\n",
+			want:   "/src/app/other.go:2:1: syntax error\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(translateDiagnostics([]byte(tt.output), lineMaps)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
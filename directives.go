@@ -0,0 +1,130 @@
+package goinject
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// DirectiveValidation controls how `//go:` directives injected by modifiers are validated.
+type DirectiveValidation int
+
+const (
+	// DirectiveValidationOff does not validate directives. The compiler rejects some
+	// malformed directives with errors pointing to the generated code, and silently ignores others.
+	DirectiveValidationOff DirectiveValidation = iota
+	// DirectiveValidationWarn reports malformed directives as warnings.
+	DirectiveValidationWarn
+	// DirectiveValidationError fails the build on malformed directives.
+	DirectiveValidationError
+)
+
+// directiveArgs is the number of arguments known directives accept.
+// A negative number means at least that many arguments.
+var directiveArgs = map[string]int{
+	"build":              -1,
+	"debug":              -1,
+	"embed":              -1,
+	"generate":           -1,
+	"linkname":           -1,
+	"noescape":           0,
+	"nocheckptr":         0,
+	"noinline":           0,
+	"nointerface":        0,
+	"norace":             0,
+	"nosplit":            0,
+	"notinheap":          0,
+	"nowritebarrier":     0,
+	"nowritebarrierrec":  0,
+	"registerparams":     0,
+	"systemstack":        0,
+	"uintptrescapes":     0,
+	"uintptrkeepalive":   0,
+	"wasmexport":         1,
+	"wasmimport":         2,
+	"yeswritebarrierrec": 0,
+}
+
+// validateDirectives returns an error for every malformed `//go:` directive of the generated code
+// that is not present in the original file, i.e. that was injected by the modifier.
+// Code that does not parse is left for the compiler to report.
+func validateDirectives(original *ast.File, generated []byte) []error {
+	f, err := parser.ParseFile(token.NewFileSet(), "", generated, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+
+	existing := make(map[string]bool)
+	for _, group := range original.Comments {
+		for _, comment := range group.List {
+			existing[comment.Text] = true
+		}
+	}
+
+	importsUnsafe := false
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == "unsafe" {
+			importsUnsafe = true
+		}
+	}
+
+	var errs []error
+	for _, group := range f.Comments {
+		for _, comment := range group.List {
+			if existing[comment.Text] || !strings.HasPrefix(comment.Text, "//go:") {
+				continue
+			}
+
+			if err := validateDirective(comment.Text, importsUnsafe); err != nil {
+				errs = append(errs, fmt.Errorf("injected directive %q: %w", comment.Text, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateDirective checks the directive against the grammar of the known directives.
+func validateDirective(text string, importsUnsafe bool) error {
+	name, args, _ := strings.Cut(strings.TrimPrefix(text, "//go:"), " ")
+	fields := strings.Fields(args)
+
+	// The cgo directives are generated by cgo itself and have a grammar of their own.
+	if strings.HasPrefix(name, "cgo_") {
+		return nil
+	}
+
+	want, known := directiveArgs[name]
+	switch {
+	case !known:
+		return fmt.Errorf("unknown directive %s", name)
+	case want >= 0 && len(fields) != want:
+		return fmt.Errorf("%s expects %d arguments, got %d", name, want, len(fields))
+	case want < 0 && len(fields) == 0:
+		return fmt.Errorf("%s expects arguments", name)
+	}
+
+	switch name {
+	case "build":
+		if _, err := constraint.Parse(text); err != nil {
+			return err
+		}
+	case "linkname":
+		if len(fields) > 2 {
+			return fmt.Errorf("linkname expects at most 2 arguments, got %d", len(fields))
+		}
+		if !importsUnsafe {
+			return fmt.Errorf(`linkname is only allowed in files that import "unsafe"`)
+		}
+	case "debug":
+		if !strings.Contains(fields[0], "=") || len(fields) > 1 {
+			return fmt.Errorf("debug expects a single key=value setting")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package goinject
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+// linknameModifier injects the directive above every function declaration.
+func linknameModifier(directive string) Modifier {
+	return funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		decl.Decs.Start.Append(directive)
+	})
+}
+
+func TestProcessDirectiveValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		directive  string
+		validation DirectiveValidation
+		// want is the message of the malformed directive, empty if it is valid.
+		want    string
+		wantErr bool
+	}{
+		{
+			name:       "missing unsafe import warns",
+			src:        "package main\n\nfunc main() {}\n",
+			directive:  "//go:linkname main runtime.main",
+			validation: DirectiveValidationWarn,
+			want:       `injected directive "//go:linkname main runtime.main": linkname is only allowed in files that import "unsafe"`,
+		},
+		{
+			name:       "missing unsafe import fails",
+			src:        "package main\n\nfunc main() {}\n",
+			directive:  "//go:linkname main runtime.main",
+			validation: DirectiveValidationError,
+			want:       `injected directive "//go:linkname main runtime.main": linkname is only allowed in files that import "unsafe"`,
+			wantErr:    true,
+		},
+		{
+			name:       "too many arguments",
+			src:        "package main\n\nimport _ \"unsafe\"\n\nfunc main() {}\n",
+			directive:  "//go:linkname main runtime.main extra",
+			validation: DirectiveValidationError,
+			want:       "linkname expects at most 2 arguments, got 3",
+			wantErr:    true,
+		},
+		{
+			name:       "missing arguments",
+			src:        "package main\n\nimport _ \"unsafe\"\n\nfunc main() {}\n",
+			directive:  "//go:linkname",
+			validation: DirectiveValidationError,
+			want:       "linkname expects arguments",
+			wantErr:    true,
+		},
+		{
+			name:       "valid",
+			src:        "package main\n\nimport _ \"unsafe\"\n\nfunc main() {}\n",
+			directive:  "//go:linkname main runtime.main",
+			validation: DirectiveValidationError,
+		},
+		{
+			name:       "off",
+			src:        "package main\n\nfunc main() {}\n",
+			directive:  "//go:linkname main runtime.main",
+			validation: DirectiveValidationOff,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   tt.src,
+				"importcfg": "",
+			})
+
+			var stderr bytes.Buffer
+			tool, argsFile := fakeCompiler(t, 0)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			err := runCompile(t, dir, tool, args, linknameModifier(tt.directive), WithDirectiveValidation(tt.validation), WithStderr(&stderr))
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("malformed directive did not fail the build")
+				}
+				if msg := err.Error(); !strings.Contains(msg, mainFile) || !strings.Contains(msg, tt.want) {
+					t.Errorf("got error %q, want %q in %s", msg, tt.want, mainFile)
+				}
+				if _, err := os.Stat(argsFile); err == nil {
+					t.Error("compiler was run with the malformed directive")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want string
+			if tt.want != "" {
+				want = mainFile + ": warning: " + tt.want + "\n"
+			}
+			if stderr.String() != want {
+				t.Errorf("got stderr %q, want %q", stderr.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package goinject
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+// embeddedVars returns the `//go:embed` directives of the file by the names of the variables they apply to.
+// A directive applies to the variable declared right below it, either by a var declaration of its own
+// or by a spec within a parenthesized one.
+func embeddedVars(f *ast.File) map[string][]string {
+	vars := make(map[string][]string)
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.VAR {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+
+			doc := valueSpec.Doc
+			if doc == nil && !genDecl.Lparen.IsValid() {
+				doc = genDecl.Doc
+			}
+
+			directives := embedDirectives(doc)
+			if len(directives) == 0 {
+				continue
+			}
+
+			for _, name := range valueSpec.Names {
+				vars[name.Name] = directives
+			}
+		}
+	}
+
+	return vars
+}
+
+// embedDirectives returns the `//go:embed` directives of the comment group.
+func embedDirectives(doc *ast.CommentGroup) []string {
+	if doc == nil {
+		return nil
+	}
+
+	var directives []string
+	for _, comment := range doc.List {
+		if strings.HasPrefix(comment.Text, "//go:embed ") {
+			directives = append(directives, strings.TrimSpace(comment.Text))
+		}
+	}
+
+	return directives
+}
+
+// checkEmbeds verifies that the `//go:embed` directives of the original file are still attached
+// to the same variables in the generated code. The restorer keeps directives as decorations of
+// the declarations, so a modifier replacing or rearranging the declarations may detach them,
+// which would leave the variables empty or fail the compilation with a misplaced directive.
+func checkEmbeds(original *ast.File, generated []byte) error {
+	want := embeddedVars(original)
+	if len(want) == 0 {
+		return nil
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), "", generated, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		// Code that does not parse is left for the compiler to report.
+		return nil
+	}
+	got := embeddedVars(f)
+
+	for name, directives := range want {
+		if !slices.Equal(got[name], directives) {
+			return fmt.Errorf("%s of var %s is no longer attached to its declaration after modification", strings.Join(directives, ", "), name)
+		}
+	}
+
+	return nil
+}
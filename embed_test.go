@@ -0,0 +1,84 @@
+package goinject
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestProcessFileEmbed(t *testing.T) {
+	src := `package main
+
+import _ "embed"
+
+//go:embed greeting.txt
+var greeting string
+
+func main() { print(greeting) }
+`
+
+	tests := []struct {
+		name     string
+		modifier Modifier
+		// wantErr is a part of the expected error, empty if the file is modified.
+		wantErr string
+	}{
+		{
+			name: "unrelated function",
+			modifier: ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+				f.Decls = append(f.Decls, &dst.FuncDecl{
+					Name: dst.NewIdent("injected"),
+					Type: &dst.FuncType{},
+					Body: &dst.BlockStmt{},
+				})
+				return f
+			}),
+		},
+		{
+			name: "detached directive",
+			modifier: ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+				for _, decl := range f.Decls {
+					if genDecl, ok := decl.(*dst.GenDecl); ok && len(genDecl.Decs.Start) > 0 {
+						genDecl.Decs.Start = nil
+					}
+				}
+				return f
+			}),
+			wantErr: "//go:embed greeting.txt of var greeting is no longer attached to its declaration",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, unit := testUnit(t, map[string]string{"main.go": src})
+			file := modifiedFile(t, config, unit, unit.goFiles[0], tt.modifier)
+			path, _, err := processFile(config, unit, file, tt.modifier)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), "func injected()") {
+				t.Errorf("function was not injected:\n%s", content)
+			}
+
+			// The embedded variable still holds the file.
+			out := runPackage(t, map[string]string{"main.go": string(content), "greeting.txt": "hello"})
+			if out != "hello" {
+				t.Errorf("got output %q, want the embedded file", out)
+			}
+		})
+	}
+}
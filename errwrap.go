@@ -0,0 +1,115 @@
+package goinject
+
+import (
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// ErrorWrap describes the wrapping of the errors returned by a function
+// with the name of the function, like `fmt.Errorf("Load: %w", err)`.
+type ErrorWrap struct {
+	// Args adds the values of the named arguments of the function to the message,
+	// like `fmt.Errorf("Load(%v, %v): %w", path, mode, err)`. The values are taken
+	// when the function returns, so they reflect the changes the function made to them.
+	Args bool
+}
+
+// Inject wraps the error returned by the function at every return site.
+// The function must have the error as its last result.
+//
+// Rather than rewriting each return statement, the wrapping is done by a deferred call
+// operating on the named error result, so multiple returns, bare returns and errors
+// constructed right in the return statement are all handled the same way:
+//
+//	func Load(path string) (__goinject_r0 []byte, __goinject_err error) {
+//		defer func() {
+//			if __goinject_err != nil {
+//				__goinject_err = fmt.Errorf("Load: %w", __goinject_err)
+//			}
+//		}()
+//		...
+//	}
+//
+// Unnamed results are named for that, prefixed as configured with [WithIdentPrefix],
+// and so is a named error result with the blank name. Nil errors are returned as is.
+//
+// The error result is identified with the type information of the package, see [ModifyContext.TypesInfo],
+// so it is recognized regardless of how it is spelled. Inject reports whether the function was instrumented.
+// Functions without a body or without the error result are skipped, and so are the functions
+// added by modifiers, since their type is unknown.
+func (w ErrorWrap) Inject(ctx *ModifyContext, decl *dst.FuncDecl) bool {
+	if decl.Body == nil || decl.Type.Results == nil || len(decl.Type.Results.List) == 0 {
+		return false
+	}
+
+	sig, ok := ctx.TypeOf(decl).(*types.Signature)
+	if !ok || sig.Results().Len() == 0 {
+		return false
+	}
+
+	last := sig.Results().At(sig.Results().Len() - 1)
+	if !types.Identical(last.Type(), types.Universe.Lookup("error").Type()) {
+		return false
+	}
+
+	results := decl.Type.Results.List
+	if len(results[0].Names) == 0 {
+		for idx, field := range results {
+			field.Names = []*dst.Ident{dst.NewIdent(ctx.Ident("r" + strconv.Itoa(idx)))}
+		}
+	}
+
+	errField := results[len(results)-1]
+	errIdent := errField.Names[len(errField.Names)-1]
+	if errIdent.Name == "_" {
+		errIdent.Name = ctx.Ident("err")
+	}
+	errName := errIdent.Name
+
+	format := funcName(decl)
+	var args []dst.Expr
+	if w.Args {
+		var verbs []string
+		for _, field := range decl.Type.Params.List {
+			for _, name := range field.Names {
+				if name.Name == "_" {
+					continue
+				}
+				verbs = append(verbs, "%v")
+				args = append(args, dst.NewIdent(name.Name))
+			}
+		}
+		format += "(" + strings.Join(verbs, ", ") + ")"
+	}
+	args = append([]dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(format + ": %w")}}, args...)
+	args = append(args, dst.NewIdent(errName))
+
+	wrap := &dst.DeferStmt{
+		Call: &dst.CallExpr{
+			Fun: &dst.FuncLit{
+				Type: &dst.FuncType{},
+				Body: &dst.BlockStmt{List: []dst.Stmt{
+					&dst.IfStmt{
+						Cond: &dst.BinaryExpr{X: dst.NewIdent(errName), Op: token.NEQ, Y: dst.NewIdent("nil")},
+						Body: &dst.BlockStmt{List: []dst.Stmt{
+							&dst.AssignStmt{
+								Lhs: []dst.Expr{dst.NewIdent(errName)},
+								Tok: token.ASSIGN,
+								Rhs: []dst.Expr{Call("fmt", "Errorf", args...)},
+							},
+						}},
+					},
+				}},
+			},
+		},
+	}
+	wrap.Decs.After = dst.EmptyLine
+
+	decl.Body.List = append([]dst.Stmt{wrap}, decl.Body.List...)
+
+	return true
+}
@@ -0,0 +1,96 @@
+package goinject
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestErrorWrapInject(t *testing.T) {
+	src := `package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func load(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+	return []byte(path), nil
+}
+
+func named(n int) (count int, err error) {
+	if n < 0 {
+		err = errors.New("negative")
+		return
+	}
+	return n, nil
+}
+
+func inline(n int) error { return fmt.Errorf("inline %d", n) }
+
+func count() int { return 1 }
+
+func main() {
+	_, err := load("")
+	fmt.Println(err)
+	data, err := load("a")
+	fmt.Println(string(data), err)
+	n, err := named(-1)
+	fmt.Println(n, err)
+	fmt.Println(inline(2))
+	fmt.Println(count())
+}
+`
+
+	tests := []struct {
+		name string
+		wrap ErrorWrap
+		want string
+	}{
+		{
+			name: "function name",
+			want: "load: empty path\na <nil>\n0 named: negative\ninline: inline 2\n1\n",
+		},
+		{
+			name: "args",
+			wrap: ErrorWrap{Args: true},
+			want: "load(): empty path\na <nil>\n0 named(-1): negative\ninline(2): inline 2\n1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, unit := testUnit(t, map[string]string{"main.go": src})
+			unit.importcfg = exportImportcfg(t, "errors", "fmt")
+
+			var instrumented []string
+			modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				if tt.wrap.Inject(ctx, decl) {
+					instrumented = append(instrumented, decl.Name.Name)
+				}
+			})
+			file := modifiedFile(t, config, unit, unit.goFiles[0], modifier)
+			path, _, err := processFile(config, unit, file, modifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Functions returning no error are left alone.
+			if want := []string{"load", "named", "inline"}; !slices.Equal(instrumented, want) {
+				t.Errorf("got instrumented functions %q, want %q", instrumented, want)
+			}
+			if got := runProgram(t, lineDirectives.ReplaceAllString(string(content), "")); got != tt.want {
+				t.Errorf("got output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
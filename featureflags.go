@@ -0,0 +1,256 @@
+package goinject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// loadFeatureFlags reads feature flag values from the configured sources
+// and registers them as cache inputs.
+func (c *config) loadFeatureFlags() error {
+	var sources [][]byte
+
+	if c.featureFlagsFile != "" {
+		data, err := os.ReadFile(c.featureFlagsFile)
+		if err != nil {
+			return fmt.Errorf("reading feature flags file: %w", err)
+		}
+		sources = append(sources, data)
+	}
+
+	if c.featureFlagsEnv != "" {
+		if value := os.Getenv(c.featureFlagsEnv); value != "" {
+			sources = append(sources, []byte(value))
+		}
+	}
+
+	for _, source := range sources {
+		dec := json.NewDecoder(bytes.NewReader(source))
+		dec.UseNumber()
+
+		var flags map[string]any
+		if err := dec.Decode(&flags); err != nil {
+			return fmt.Errorf("parsing feature flags: %w", err)
+		}
+
+		if c.featureFlags == nil {
+			c.featureFlags = make(map[string]any, len(flags))
+		}
+		for name, value := range flags {
+			c.featureFlags[name] = value
+		}
+
+		c.cacheInputs = append(c.cacheInputs, source)
+	}
+
+	return nil
+}
+
+// stampFeatureFlags replaces the initializers of the package-level variables
+// listed in flags with the configured values.
+// Variables without an initializer are stamped only if they are declared alone,
+// like `var EnableCache bool`, so that no other variable is left without a value.
+func stampFeatureFlags(f *dst.File, pkgPath string, flags map[string]any) error {
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok || genDecl.Tok != token.VAR {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			valueSpec, ok := spec.(*dst.ValueSpec)
+			if !ok {
+				continue
+			}
+
+			for idx, name := range valueSpec.Names {
+				flagName := pkgPath + "." + name.Name
+				value, ok := flags[flagName]
+				if !ok {
+					continue
+				}
+
+				literal, err := flagLiteral(value)
+				if err != nil {
+					return fmt.Errorf("feature flag %s: %w", flagName, err)
+				}
+
+				switch {
+				case len(valueSpec.Values) == len(valueSpec.Names):
+					valueSpec.Values[idx] = literal
+				case len(valueSpec.Values) == 0 && len(valueSpec.Names) == 1:
+					valueSpec.Values = []dst.Expr{literal}
+				default:
+					return fmt.Errorf("feature flag %s: variable must have its own initializer", flagName)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// stampFlagFiles bakes the configured feature flag values into the project files compiled
+// without going through [processFile]: the ones never handed to the modifier, and the ones
+// the modifier left as they are. The values must not depend on whether a file is modified,
+// so stamped copies of the files declaring the flags are compiled instead of them.
+// It returns the paths to the copies by the paths of the original files.
+func stampFlagFiles(config *config, unit *compileUnit, files []string, modified map[string]string) (map[string]string, error) {
+	stamped := make(map[string]string)
+	for _, path := range files {
+		if newPath, ok := modified[path]; ok && newPath != path || unit.isDropped(path) {
+			continue
+		}
+
+		astFile, err := unit.parse(path)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		// The files importing "C" are compiled by the go command only after cgo translated them.
+		if importsC(astFile) {
+			continue
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		content, ok, err := stampFeatureFlagsSource(unit.fset, astFile, src, unit.pkgPath, config.featureFlags)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if config.lineDirectives != LineDirectivesOff {
+			content = append([]byte(lineDirective(path, 1, 1)), content...)
+		}
+
+		newPath := unit.tmpPath(path)
+		if config.inMemory {
+			unit.hold(newPath, content)
+		} else if err := unit.output(newPath, content); err != nil {
+			return nil, fmt.Errorf("writing stamped file: %w", err)
+		}
+		config.logger.Printf("Feature flags stamped into file: %s", path)
+
+		stamped[path] = newPath
+	}
+
+	return stamped, nil
+}
+
+// stampFeatureFlagsSource is [stampFeatureFlags] for the source of a file compiled unmodified.
+// The initializers are replaced in the text, which keeps the lines of the rest of the file,
+// so the compiler reports the positions of the original file. It reports whether any flag was stamped.
+func stampFeatureFlagsSource(fset *token.FileSet, f *ast.File, src []byte, pkgPath string, flags map[string]any) ([]byte, bool, error) {
+	type edit struct {
+		start, end int
+		text       string
+	}
+
+	tokFile := fset.File(f.Pos())
+	var edits []edit
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.VAR {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			valueSpec, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+
+			for idx, name := range valueSpec.Names {
+				flagName := pkgPath + "." + name.Name
+				value, ok := flags[flagName]
+				if !ok {
+					continue
+				}
+
+				literal, err := flagLiteralText(value)
+				if err != nil {
+					return nil, false, fmt.Errorf("feature flag %s: %w", flagName, err)
+				}
+
+				switch {
+				case len(valueSpec.Values) == len(valueSpec.Names):
+					start, end := tokFile.Offset(valueSpec.Values[idx].Pos()), tokFile.Offset(valueSpec.Values[idx].End())
+					// The line breaks of the initializer are kept in a comment in front of the literal,
+					// where they end no statement, so the lines after it keep their numbers.
+					if lines := bytes.Count(src[start:end], []byte("\n")); lines > 0 {
+						literal = "/*" + strings.Repeat("\n", lines) + "*/" + literal
+					}
+					edits = append(edits, edit{start: start, end: end, text: literal})
+				case len(valueSpec.Values) == 0 && len(valueSpec.Names) == 1:
+					end := tokFile.Offset(valueSpec.End())
+					edits = append(edits, edit{start: end, end: end, text: " = " + literal})
+				default:
+					return nil, false, fmt.Errorf("feature flag %s: variable must have its own initializer", flagName)
+				}
+			}
+		}
+	}
+
+	if len(edits) == 0 {
+		return src, false, nil
+	}
+
+	// The edits are collected in the order of the source, so they are applied
+	// last to first to keep the offsets of the ones before valid.
+	stamped := slices.Clone(src)
+	for idx := len(edits) - 1; idx >= 0; idx-- {
+		e := edits[idx]
+		stamped = slices.Concat(stamped[:e.start], []byte(e.text), stamped[e.end:])
+	}
+
+	return stamped, true, nil
+}
+
+// flagLiteral converts a decoded JSON value into a Go literal.
+func flagLiteral(value any) (dst.Expr, error) {
+	text, err := flagLiteralText(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch value.(type) {
+	case bool:
+		return dst.NewIdent(text), nil
+	case string:
+		return &dst.BasicLit{Kind: token.STRING, Value: text}, nil
+	default:
+		if strings.ContainsAny(text, ".eE") {
+			return &dst.BasicLit{Kind: token.FLOAT, Value: text}, nil
+		}
+		return &dst.BasicLit{Kind: token.INT, Value: text}, nil
+	}
+}
+
+// flagLiteralText returns the source of the Go literal of a decoded JSON value.
+func flagLiteralText(value any) (string, error) {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return strconv.Quote(v), nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v of type %T", value, value)
+	}
+}
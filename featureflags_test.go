@@ -0,0 +1,69 @@
+package goinject
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessFeatureFlags(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.22\n",
+		"main.go":    "package main\n\nvar EnableCache = false\n\nfunc main() {}\n",
+		"limits.go":  "package main\n\nvar (\n\tMaxConns int\n\tRegion   = \"local\"\n)\n",
+		"flags.json": `{"main.EnableCache": true, "main.MaxConns": 8, "main.Region": "eu"}`,
+		"importcfg":  "",
+	})
+	flagsFile := filepath.Join(dir, "flags.json")
+	t.Setenv("FLAGS", `{"main.Region": "us"}`)
+
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]string
+	}{
+		{
+			name: "file",
+			opts: []Option{WithFeatureFlagsFile(flagsFile)},
+			want: map[string]string{"main.go": "var EnableCache = true", "limits.go": `Region = "eu"`},
+		},
+		{
+			name: "env over file",
+			opts: []Option{WithFeatureFlagsFile(flagsFile), WithFeatureFlagsEnv("FLAGS")},
+			want: map[string]string{"main.go": "var EnableCache = true", "limits.go": `Region = "us"`},
+		},
+		{
+			// The flags do not depend on whether the modifier gets the file.
+			name: "unmodified file",
+			opts: []Option{WithFeatureFlagsFile(flagsFile), WithFileFilter(func(path string) bool { return false })},
+			want: map[string]string{"main.go": "var EnableCache = true", "limits.go": "MaxConns int = 8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := compiledSources(t, dir, []string{"main.go", "limits.go"}, identity, tt.opts...)
+			for name, want := range tt.want {
+				// The alignment of the declarations depends on the values, so it is not compared.
+				if !strings.Contains(strings.Join(strings.Fields(sources[name]), " "), want) {
+					t.Errorf("compiled %s does not contain %q:\n%s", name, want, sources[name])
+				}
+			}
+		})
+	}
+}
+
+func TestVersionProbeFeatureFlags(t *testing.T) {
+	dir := t.TempDir()
+	flagsFile := filepath.Join(dir, "flags.json")
+	tool, _ := fakeCompiler(t, 0)
+
+	// The packages must be rebuilt with the new defaults when the config changes.
+	writeFiles(t, dir, map[string]string{"flags.json": `{"main.EnableCache": true}`})
+	enabled := probeVersion(t, dir, tool, WithFeatureFlagsFile(flagsFile))
+	writeFiles(t, dir, map[string]string{"flags.json": `{"main.EnableCache": false}`})
+	if disabled := probeVersion(t, dir, tool, WithFeatureFlagsFile(flagsFile)); disabled == enabled {
+		t.Errorf("version is the same after the feature flags changed: %s", enabled)
+	}
+}
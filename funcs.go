@@ -0,0 +1,92 @@
+package goinject
+
+import (
+	"go/token"
+	"regexp"
+	"slices"
+
+	"github.com/dave/dst"
+)
+
+// FuncFilter selects the function declarations visited by [ForEachFunc].
+// The zero FuncFilter matches all of them, and every field set narrows the match.
+type FuncFilter struct {
+	// Exported matches only the exported functions and methods.
+	Exported bool
+	// Unexported matches only the unexported functions and methods.
+	Unexported bool
+	// Methods matches only the methods.
+	Methods bool
+	// Functions matches only the functions, that is the declarations without a receiver.
+	Functions bool
+	// Receiver matches only the methods of the type with the name, regardless of whether
+	// the receiver is a pointer and of the type parameters of a generic type, e.g. `List`
+	// matches `func (l *List[T]) Push(v T)`.
+	Receiver string
+	// Name matches only the functions whose name, as it is referred to in Go code,
+	// matches the expression, e.g. `Handle` or `Server.Handle` for methods.
+	Name *regexp.Regexp
+	// WithBody matches only the functions with a body, skipping the ones implemented
+	// in assembly or linked with //go:linkname, which modifiers cannot instrument.
+	WithBody bool
+}
+
+// Match reports whether the filter matches the function declaration.
+func (ff FuncFilter) Match(decl *dst.FuncDecl) bool {
+	exported := token.IsExported(decl.Name.Name)
+	if ff.Exported && !ff.Unexported && !exported || ff.Unexported && !ff.Exported && exported {
+		return false
+	}
+
+	method := decl.Recv != nil && len(decl.Recv.List) > 0
+	if ff.Methods && !ff.Functions && !method || ff.Functions && !ff.Methods && method {
+		return false
+	}
+
+	if ff.Receiver != "" && (!method || receiverTypeName(decl) != ff.Receiver) {
+		return false
+	}
+
+	if ff.Name != nil && !ff.Name.MatchString(funcName(decl)) {
+		return false
+	}
+
+	return !ff.WithBody || decl.Body != nil
+}
+
+// ForEachFunc calls fn with every top-level function declaration of the file matching the filter,
+// in the order of the file. The declarations fn adds to the file are not visited, so it may
+// add methods or helper functions without visiting them in turn.
+func ForEachFunc(f *dst.File, filter FuncFilter, fn func(*dst.FuncDecl)) {
+	for _, decl := range slices.Clone(f.Decls) {
+		funcDecl, ok := decl.(*dst.FuncDecl)
+		if ok && filter.Match(funcDecl) {
+			fn(funcDecl)
+		}
+	}
+}
+
+// receiverTypeName returns the name of the type of the method receiver,
+// without the pointer and the type parameters, or "" for functions.
+func receiverTypeName(decl *dst.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+
+	typ := decl.Recv.List[0].Type
+	if star, ok := typ.(*dst.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *dst.IndexExpr:
+		typ = t.X
+	case *dst.IndexListExpr:
+		typ = t.X
+	}
+
+	if ident, ok := typ.(*dst.Ident); ok {
+		return ident.Name
+	}
+
+	return ""
+}
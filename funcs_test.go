@@ -0,0 +1,86 @@
+package goinject
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestForEachFunc(t *testing.T) {
+	src := `package lib
+
+type List[T any] struct{ items []T }
+
+func (l *List[T]) Push(v T) { l.items = append(l.items, v) }
+
+func (l List[T]) len() int { return len(l.items) }
+
+type Pair[K comparable, V any] struct{}
+
+func (p Pair[K, V]) Key() (k K) { return }
+
+type Server struct{}
+
+func (s *Server) Handle() {}
+
+func Map[T, U any](xs []T, f func(T) U) []U { return nil }
+
+func helper() {}
+
+// Implemented in assembly.
+func Add(a, b int) int
+`
+
+	tests := []struct {
+		name   string
+		filter FuncFilter
+		want   []string
+	}{
+		{name: "all", want: []string{"List.Push", "List.len", "Pair.Key", "Server.Handle", "Map", "helper", "Add"}},
+		{name: "exported", filter: FuncFilter{Exported: true}, want: []string{"List.Push", "Pair.Key", "Server.Handle", "Map", "Add"}},
+		{name: "unexported", filter: FuncFilter{Unexported: true}, want: []string{"List.len", "helper"}},
+		{name: "methods", filter: FuncFilter{Methods: true}, want: []string{"List.Push", "List.len", "Pair.Key", "Server.Handle"}},
+		{name: "functions", filter: FuncFilter{Functions: true}, want: []string{"Map", "helper", "Add"}},
+		{name: "generic receiver", filter: FuncFilter{Receiver: "List"}, want: []string{"List.Push", "List.len"}},
+		{name: "generic receiver with type parameters", filter: FuncFilter{Receiver: "Pair"}, want: []string{"Pair.Key"}},
+		{name: "name of method", filter: FuncFilter{Name: regexp.MustCompile(`^Server\.`)}, want: []string{"Server.Handle"}},
+		{name: "name", filter: FuncFilter{Name: regexp.MustCompile(`^(Map|Add)$`)}, want: []string{"Map", "Add"}},
+		{name: "with body", filter: FuncFilter{Functions: true, Exported: true, WithBody: true}, want: []string{"Map"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := decorator.Parse(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			ForEachFunc(f, tt.filter, func(decl *dst.FuncDecl) {
+				got = append(got, funcName(decl))
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("visited %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForEachFuncAddedDecls(t *testing.T) {
+	f, err := decorator.Parse("package lib\n\nfunc a() {}\n\nfunc b() {}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	ForEachFunc(f, FuncFilter{}, func(decl *dst.FuncDecl) {
+		got = append(got, decl.Name.Name)
+		f.Decls = append(f.Decls, &dst.FuncDecl{Name: dst.NewIdent(decl.Name.Name + "Helper"), Type: &dst.FuncType{}, Body: &dst.BlockStmt{}})
+	})
+	if !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("visited %q, want the functions of the file only", got)
+	}
+}
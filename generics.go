@@ -0,0 +1,96 @@
+package goinject
+
+import (
+	"fmt"
+
+	"github.com/dave/dst"
+)
+
+// FindTypeSpec returns the specification of the type with the given name declared in the file,
+// or nil if the file declares no such type.
+func FindTypeSpec(f *dst.File, name string) *dst.TypeSpec {
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			typeSpec, ok := spec.(*dst.TypeSpec)
+			if ok && typeSpec.Name.Name == name {
+				return typeSpec
+			}
+		}
+	}
+
+	return nil
+}
+
+// Receiver returns the receiver list for a method of the type declared by spec.
+// Type parameters of generic types are repeated in the receiver under their declared names,
+// e.g. `(l *List[K, V])` for `type List[K comparable, V any]`, so the method body
+// may refer to them just like the type declaration does.
+func Receiver(spec *dst.TypeSpec, name string, pointer bool) *dst.FieldList {
+	var typ dst.Expr = dst.NewIdent(spec.Name.Name)
+
+	var params []dst.Expr
+	if spec.TypeParams != nil {
+		for _, field := range spec.TypeParams.List {
+			for _, paramName := range field.Names {
+				params = append(params, dst.NewIdent(paramName.Name))
+			}
+		}
+	}
+
+	switch len(params) {
+	case 0:
+	case 1:
+		typ = &dst.IndexExpr{X: typ, Index: params[0]}
+	default:
+		typ = &dst.IndexListExpr{X: typ, Indices: params}
+	}
+
+	if pointer {
+		typ = &dst.StarExpr{X: typ}
+	}
+
+	field := &dst.Field{Type: typ}
+	if name != "" {
+		field.Names = []*dst.Ident{dst.NewIdent(name)}
+	}
+
+	return &dst.FieldList{List: []*dst.Field{field}}
+}
+
+// AddMethod declares the method on the type with the given name, which must be declared in the file.
+// The receiver of the method is replaced with the one built by [Receiver].
+func AddMethod(f *dst.File, typeName string, recvName string, pointer bool, method *dst.FuncDecl) error {
+	spec := FindTypeSpec(f, typeName)
+	if spec == nil {
+		return fmt.Errorf("type %s is not declared in the file", typeName)
+	}
+
+	method.Recv = Receiver(spec, recvName, pointer)
+	if method.Decs.Before == dst.None {
+		method.Decs.Before = dst.EmptyLine
+	}
+	f.Decls = append(f.Decls, method)
+
+	return nil
+}
+
+// AddField appends the field to the struct type declared by spec. Type parameters
+// of a generic struct can be referenced in the type of the field by their names.
+func AddField(spec *dst.TypeSpec, field *dst.Field) error {
+	structType, ok := spec.Type.(*dst.StructType)
+	if !ok {
+		return fmt.Errorf("type %s is not a struct", spec.Name.Name)
+	}
+
+	if structType.Fields == nil {
+		structType.Fields = &dst.FieldList{}
+	}
+	structType.Fields.List = append(structType.Fields.List, field)
+
+	return nil
+}
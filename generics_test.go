@@ -0,0 +1,79 @@
+package goinject
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestAddMethodToGenericType(t *testing.T) {
+	src := `package main
+
+type List[K comparable, V any] struct {
+	items map[K]V
+}
+
+type Set[T comparable] map[T]struct{}
+
+func main() {}
+`
+	modifier := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		list := FindTypeSpec(f, "List")
+		// The type parameters are referenced by their names in the field and in the method.
+		if err := AddField(list, &dst.Field{Names: []*dst.Ident{dst.NewIdent("last")}, Type: dst.NewIdent("K")}); err != nil {
+			t.Fatal(err)
+		}
+		err := AddMethod(f, "List", "l", true, &dst.FuncDecl{
+			Name: dst.NewIdent("Last"),
+			Type: &dst.FuncType{Results: &dst.FieldList{List: []*dst.Field{{Type: dst.NewIdent("V")}}}},
+			Body: &dst.BlockStmt{List: []dst.Stmt{&dst.ReturnStmt{Results: []dst.Expr{
+				&dst.IndexExpr{X: &dst.SelectorExpr{X: dst.NewIdent("l"), Sel: dst.NewIdent("items")}, Index: &dst.SelectorExpr{X: dst.NewIdent("l"), Sel: dst.NewIdent("last")}},
+			}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = AddMethod(f, "Set", "s", false, &dst.FuncDecl{
+			Name: dst.NewIdent("Len"),
+			Type: &dst.FuncType{Results: &dst.FieldList{List: []*dst.Field{{Type: dst.NewIdent("int")}}}},
+			Body: &dst.BlockStmt{List: []dst.Stmt{&dst.ReturnStmt{Results: []dst.Expr{
+				&dst.CallExpr{Fun: dst.NewIdent("len"), Args: []dst.Expr{dst.NewIdent("s")}},
+			}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := AddMethod(f, "Missing", "m", false, &dst.FuncDecl{Name: dst.NewIdent("M"), Type: &dst.FuncType{}, Body: &dst.BlockStmt{}}); err == nil {
+			t.Error("added a method to an undeclared type")
+		}
+		if err := AddField(FindTypeSpec(f, "Set"), &dst.Field{Type: dst.NewIdent("int")}); err == nil {
+			t.Error("added a field to a map type")
+		}
+
+		return f
+	})
+	got := modifiedSource(t, src, modifier)
+
+	for _, want := range []string{"last  K", "func (l *List[K, V]) Last() V {", "func (s Set[T]) Len() int {"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("modified source does not contain %q:\n%s", want, got)
+		}
+	}
+
+	// The modified source compiles, so the receivers declare the type parameters correctly.
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", got, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&types.Config{}).Check("main", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("modified source does not type-check: %s\n%s", err, got)
+	}
+}
@@ -0,0 +1,91 @@
+package goinject
+
+import (
+	"go/token"
+
+	"github.com/dave/dst"
+)
+
+// glsIdent returns the name of the variable holding the goroutine-scoped value
+// inside the instrumented function.
+func glsIdent(ctx *ModifyContext) string {
+	return ctx.Ident("gls")
+}
+
+// GoroutineLocal describes user-provided accessors of a goroutine-scoped value.
+// Go has no native goroutine local storage, so tracing frameworks usually
+// keep such values in their own registry and expose a getter and a setter for them.
+type GoroutineLocal struct {
+	// Path is the import path of the package providing the accessors.
+	Path string
+	// Get is the name of the function returning the value of the current goroutine,
+	// e.g. `func Get() any`.
+	Get string
+	// Set is the name of the function storing the value for the current goroutine,
+	// e.g. `func Set(v any)`.
+	Set string
+}
+
+// Inject retrieves the goroutine-scoped value at the entry of the function
+// and propagates it into every goroutine the function spawns:
+//
+//	func Handle() {
+//		__goinject_gls := gls.Get()
+//		go func() {
+//			gls.Set(__goinject_gls)
+//			...
+//		}()
+//	}
+//
+// Only go statements calling a function literal are instrumented. Wrapping any
+// other call, like `go f(x)`, would move the evaluation of its arguments into the
+// new goroutine and change the behavior of the program, so such statements are left as is.
+//
+// The name of the variable is prefixed as configured with [WithIdentPrefix].
+//
+// Inject reports whether the function was instrumented. Functions without a body are skipped.
+func (g GoroutineLocal) Inject(ctx *ModifyContext, decl *dst.FuncDecl) bool {
+	if decl.Body == nil {
+		return false
+	}
+
+	dst.Inspect(decl.Body, func(node dst.Node) bool {
+		goStmt, ok := node.(*dst.GoStmt)
+		if !ok {
+			return true
+		}
+
+		funcLit, ok := goStmt.Call.Fun.(*dst.FuncLit)
+		if !ok {
+			return true
+		}
+
+		set := &dst.ExprStmt{
+			X: &dst.CallExpr{
+				Fun:  &dst.Ident{Path: g.Path, Name: g.Set},
+				Args: []dst.Expr{dst.NewIdent(glsIdent(ctx))},
+			},
+		}
+		funcLit.Body.List = append([]dst.Stmt{set}, funcLit.Body.List...)
+
+		return true
+	})
+
+	get := &dst.AssignStmt{
+		Lhs: []dst.Expr{dst.NewIdent(glsIdent(ctx))},
+		Tok: token.DEFINE,
+		Rhs: []dst.Expr{&dst.CallExpr{Fun: &dst.Ident{Path: g.Path, Name: g.Get}}},
+	}
+
+	// The value is referenced with a blank assignment, so the function
+	// still compiles when it spawns no goroutines.
+	use := &dst.AssignStmt{
+		Lhs: []dst.Expr{dst.NewIdent("_")},
+		Tok: token.ASSIGN,
+		Rhs: []dst.Expr{dst.NewIdent(glsIdent(ctx))},
+	}
+
+	decl.Body.List = append([]dst.Stmt{get, use}, decl.Body.List...)
+
+	return true
+}
@@ -0,0 +1,54 @@
+package goinject
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestGoroutineLocalInject(t *testing.T) {
+	src := `package main
+
+func main() {
+	x := 1
+	go func() {
+		println(x)
+	}()
+	go println(x)
+}
+
+func decl()
+`
+	gls := GoroutineLocal{Path: "example.com/gls", Get: "Get", Set: "Set"}
+	var instrumented []string
+	got := modifiedSource(t, src, funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		if gls.Inject(ctx, decl) {
+			instrumented = append(instrumented, decl.Name.Name)
+		}
+	}))
+
+	want := `package main
+
+import "example.com/gls"
+
+func main() {
+	__goinject_gls := gls.Get()
+	_ = __goinject_gls
+	x := 1
+	go func() {
+		gls.Set(__goinject_gls)
+		println(x)
+	}()
+	go println(x)
+}
+
+func decl()
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if strings.Join(instrumented, ",") != "main" {
+		t.Errorf("got instrumented functions %q, want only main", instrumented)
+	}
+}
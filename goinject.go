@@ -1,21 +1,35 @@
 package goinject
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/format"
 	"go/parser"
+	"go/token"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime/debug"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
+	dstresolver "github.com/dave/dst/decorator/resolver"
 	"github.com/dave/dst/decorator/resolver/goast"
+	"github.com/dave/dst/decorator/resolver/gotypes"
 	"github.com/dave/dst/decorator/resolver/guess"
 	"golang.org/x/tools/go/packages"
 )
@@ -25,6 +39,9 @@ const argsOffset = 2
 
 const goinject = "goinject"
 
+// errPluginUnsupported is returned by loadModifierPlugin where Go plugins are not supported.
+var errPluginUnsupported = errors.New("modifier plugins are not supported on this platform")
+
 type Modifier interface {
 	Modify(*dst.File, *decorator.Decorator, *decorator.Restorer) *dst.File
 }
@@ -58,13 +75,147 @@ type Modifier interface {
 //  6. Resolve all missing imports that were added as part of the modification;
 //  7. Substitutes the path to the original files with the path to modified files and pass them to the compiler command;
 //  8. Runs the original command with an already substituted files to be compiled.
+//
+// Process panics if the preprocessing fails, and exits with a usage message
+// if the preprocessor is run directly rather than by the go command, see [ErrNotToolexec].
+// If a [ModifierE] fails, Process exits with its error reported against the file.
+// If the command itself fails, Process exits with the exit code of the command,
+// since the command already reported its diagnostics. Use [ProcessE] to handle the errors instead.
 func Process(modifier Modifier, opts ...Option) {
+	defer handleSignals()()
+
+	err := ProcessE(modifier, opts...)
+	if err == nil {
+		return
+	}
+
+	// Propagate the exit code of the failed tool, so cmd/go reports
+	// the failure the same way it does without -toolexec.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(max(exitErr.ExitCode(), 1))
+	}
+
+	// Running the preprocessor by hand is a usage error rather than a failure of the preprocessing.
+	if errors.Is(err, ErrNotToolexec) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// The error of a modifier is reported against the file like a diagnostic of the compiler,
+	// so the stack trace of a panic would only bury it.
+	var modifyErr *ModifyError
+	if errors.As(err, &modifyErr) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	panic(err)
+}
+
+// ProcessE does the same work as [Process], but returns an error instead of panicking or exiting,
+// so it can be embedded in larger toolchains. The temporary files are cleaned up before it returns.
+// Unlike Process, it does not handle the interrupt and termination signals, which are left to the caller.
+// A failure of the executed command is returned as an error wrapping its [exec.ExitError],
+// and a panic of the modifier as an error wrapping a [PanicError].
+func ProcessE(modifier Modifier, opts ...Option) error {
+	return ProcessContext(context.Background(), modifier, opts...)
+}
+
+// ProcessContext is like [ProcessE], but aborts the run when the context is done,
+// killing the subprocesses it started, including the tool itself, and not waiting
+// for the modifier to return. The returned error then wraps the error of the context
+// and names the stage of the run that was in flight. See also [WithTimeout].
+func ProcessContext(ctx context.Context, modifier Modifier, opts ...Option) (err error) {
+	if err := checkToolexec(os.Args); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resetImportcfgAdditions()
+
 	config := &config{
-		logger: noopLogger{},
+		logger:      noopLogger{},
+		identPrefix: DefaultIdentPrefix,
 	}
 	for _, opt := range opts {
 		opt(config)
 	}
+	config.build = newBuildContext(config.buildTags, config.buildEnv, config.goBinary)
+	if err := config.build.preferGoBinary(); err != nil {
+		return err
+	}
+	if config.stdout != nil {
+		config.build.stdout = config.stdout
+	}
+	if config.stderr != nil {
+		config.build.stderr = config.stderr
+	}
+
+	var prof *profile
+	if config.profileWriter != nil {
+		prof = &profile{}
+		config.build.profile = prof
+	}
+
+	if config.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
+	config.build.ctx = ctx
+
+	stage := "starting"
+	enterStage := func(name string) {
+		stage = name
+		prof.enter(name)
+	}
+	defer func() {
+		if err == nil || ctx.Err() == nil {
+			return
+		}
+		if err == ctx.Err() {
+			err = fmt.Errorf("%w while %s", err, stage)
+		} else {
+			err = fmt.Errorf("%w while %s: %w", ctx.Err(), stage, err)
+		}
+	}()
+	if config.resolver == nil {
+		config.resolver = func(pkgName string) (map[string]string, error) {
+			return resolvePkg(config.build, pkgName)
+		}
+	}
+	if config.resolveRetries > 0 {
+		resolver := config.resolver
+		config.resolver = func(pkgName string) (map[string]string, error) {
+			return retry(config, "resolving "+pkgName, func() (map[string]string, error) {
+				return resolver(pkgName)
+			})
+		}
+	}
+
+	// The prefix and the alloc measurement change the generated code, so they must be a part of the cache key.
+	config.cacheInputs = append(config.cacheInputs, []byte("identPrefix="+config.identPrefix))
+	config.cacheInputs = append(config.cacheInputs, []byte("allocMeasurement="+strconv.FormatBool(config.allocMeasurement)))
+
+	if err := config.loadFeatureFlags(); err != nil {
+		return err
+	}
+
+	if err := config.loadConfigFile(); err != nil {
+		return err
+	}
+
+	// The modifier of the plugin changes the generated code without changing the preprocessor.
+	if config.modifierPlugin != "" {
+		content, err := os.ReadFile(config.modifierPlugin)
+		if err != nil {
+			return fmt.Errorf("reading modifier plugin: %w", err)
+		}
+		sum := sha256.Sum256(content)
+		config.cacheInputs = append(config.cacheInputs, sum[:])
+	}
 
 	// os.Args[toolOffset] is the name of the current command called go toolchain: asm/compile/link.
 	// os.Args[argsOffset:] is command arguments.
@@ -82,187 +233,898 @@ func Process(modifier Modifier, opts ...Option) {
 	// Thus, compilation with -toolexec will have its own separate cache, which does not overlap with
 	// compilation without -toolexec.
 	if len(args) == 1 && args[0] == "-V=full" {
-		if err := alterToolVersion(tool, args); err != nil {
-			panic(err)
+		enterStage("probing the version of " + filepath.Base(tool))
+
+		// The changed files decide which files are modified, while their contents alone
+		// do not tell a file changed from the one reverted to the ref, so the set
+		// must be a part of the cache key too. Every file is modified if the set
+		// can not be retrieved, which is told apart from no file having changed.
+		if config.changedSince != "" {
+			wd, err := getwd(config.build)
+			if err != nil {
+				return err
+			}
+			changed, err := changedFiles(wd, config.changedSince)
+			if err != nil {
+				config.logger.Printf("Failed retrieving changed files, modifying all files: %s", err)
+				config.cacheInputs = append(config.cacheInputs, []byte("changed=unavailable"))
+			} else {
+				config.cacheInputs = append(config.cacheInputs, changedFilesKey(changed))
+			}
 		}
 
-		return
+		return alterToolVersion(config.build, tool, args, config.cacheInputs)
 	}
 
 	toolName := filepath.Base(tool)
-	if toolName != "compile" {
-		runCommand(tool, args)
-		return
+	enterStage("running " + toolName)
+	if toolName == "link" {
+		return runLinker(config.build, tool, args)
 	}
 
-	// fmt.Println(os.Args)
+	if toolName != "compile" {
+		config.logger.Printf("Passing through %s: %s", toolName, strings.Join(sourceFiles(args), " "))
+		if toolName == "asm" && config.asmHook != nil {
+			config.asmHook(tool, slices.Clone(args))
+		}
+
+		return runCommand(config.build, tool, args)
+	}
 
 	// Extract paths/file names from the command arguments.
-	// The files are listed as the last arguments after the -pack flag
 	//
 	// Go toolchain calls the `go tool compile` command and lists all files
-	// designated for compilation at the very end of the argument list.
+	// designated for compilation at the very end of the argument list, after the -pack flag.
+	// Other build drivers may order the arguments differently, so the files are told apart
+	// from the flags and their values, like -asmhdr or -symabis, wherever they are.
 	//
-	// Returns the index after which to specify modified .go files as a second value.
-	filesToCompile, goFilesIndex, err := extractFilesFromPack(args)
-	if err != nil {
-		panic(err)
+	// Returns the indexes of the files in args as a second value.
+	goFiles, goFileIndexes := goSourceArgs(args)
+
+	hasStdFlag := slices.Contains(args, "-std")
+
+	// Most of the compile units are std library packages, which are passed through
+	// right away, before spawning `go env` to locate the project.
+	if (hasStdFlag && !config.processStdlib) || len(goFiles) == 0 {
+		return runCommand(config.build, tool, args)
+	}
+
+	// The packages left out are compiled as they are, apart from the feature flags,
+	// which are baked into the project files regardless of the modification.
+	pkgConfig := config.packageConfig(flagValue(args, "-p"))
+	var skipReason string
+	switch {
+	case config.archConstraint != nil && !config.archConstraint(config.build.arch()):
+		skipReason = "Skipping package for unsupported architecture " + config.build.arch()
+	case !config.packageSelected(flagValue(args, "-p")):
+		skipReason = "Skipping package not selected for modification"
+	case pkgConfig.Enabled != nil && !*pkgConfig.Enabled:
+		skipReason = "Skipping package disabled by the config file"
+	case flagValue(args, "-importcfg") == "":
+		// The packages imported by the modifications are added to importcfg,
+		// so without it the files can only be compiled as they are.
+		skipReason = "Warning: no importcfg, compiling package unmodified"
+	}
+	if skipReason != "" {
+		config.logger.Printf("%s: %s", skipReason, flagValue(args, "-p"))
+		if len(config.featureFlags) == 0 {
+			return runCommand(config.build, tool, args)
+		}
 	}
 
-	wd, err := getwd()
+	enterStage("locating the project")
+	wd, err := getwd(config.build)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	config.build.detectVendor(wd)
 
 	// Create a new set of arguments for `go tool compile`.
 	// The main task is to replace the paths to the files we
-	// want to compile (specified as last arguments) with our modified
-	// files from the temporary directory.
-	copiedArgs := make([]string, len(os.Args))
-	copy(copiedArgs, os.Args)
-	newArgs := copiedArgs[:goFilesIndex]
-
-	hasStdFlag := slices.Contains(args, "-std")
+	// want to compile with our modified files from the temporary directory,
+	// while everything else is preserved verbatim in its original position.
+	newArgs := slices.Clone(os.Args)
+
+	// The files are grouped by package, since non-standard build drivers may pass files
+	// of several logical packages in a single compile. We skip the groups with non-project
+	// files to avoid patching them, and the whole unit if there is nothing left to modify.
+	// Std library packages only get here if the user asked to modify them,
+	// and so do the dependencies selected with WithDependencyModules.
+	var projectFiles []string
+	if hasStdFlag {
+		config.logger.Printf("Warning: modifying std library package %s", flagValue(args, "-p"))
+		projectFiles = goFiles
+	} else if config.dependencySelected(flagValue(args, "-p")) {
+		config.logger.Printf("Modifying dependency package %s", flagValue(args, "-p"))
+		projectFiles = slices.DeleteFunc(slices.Clone(goFiles), func(file string) bool { return !isGoFile(file) })
+	} else {
+		roots, err := projectRoots(config, wd)
+		if err != nil {
+			return err
+		}
+		projectFiles = relevantFiles(goFiles, roots)
+	}
 
-	// Go through each file and modify it if it is a project file.
-	for _, filePathToCompile := range filesToCompile {
-		isGoFile := filepath.Ext(filePathToCompile) == ".go"
-		projectFile := strings.HasPrefix(filePathToCompile, wd)
+	if len(projectFiles) == 0 {
+		return runCommand(config.build, tool, args)
+	}
 
-		// We skip non .go files, std library files, and non-project files to avoid patching them.
-		if !isGoFile || hasStdFlag || !projectFile {
-			runCommand(tool, args)
-			return
+	// Collect the files changed in the working tree if the user
+	// only wants to modify what they are currently editing.
+	var changed map[string]bool
+	if config.changedSince != "" {
+		changed, err = changedFiles(wd, config.changedSince)
+		if err != nil {
+			config.logger.Printf("Failed retrieving changed files, modifying all files: %s", err)
 		}
+	}
 
-		// Create a temporary directory to where we will write the modified files.
-		// In the future, these files will be substituted for the original ones
-		// when the final compilation command is called.
-		tmpDir, _ := os.MkdirTemp("", goinject)
+	// Create a temporary directory to where we will write the modified files.
+	// In the future, these files will be substituted for the original ones
+	// when the final compilation command is called.
+	var tmpDir string
+	if config.reproducible {
+		tmpDir, err = reproducibleTmpDir(config.build, wd, flagValue(args, "-p"), goFiles)
+	} else {
+		tmpDir, err = os.MkdirTemp("", goinject)
+	}
+	if err != nil {
+		return fmt.Errorf("creating tmp dir: %w", err)
+	}
+	config.logger.Printf("Created tmp dir: %s", tmpDir)
+
+	// Retained files let users inspect the generated code the compiler
+	// complained about, since the /*line*/ directive points errors to the original files.
+	if config.reproducible {
+		// The directory is shared by the builds of the same package, which may run
+		// concurrently, so it is locked rather than removed, and the next build overwrites it.
+		unlock, err := lockDir(tmpDir)
+		if err != nil {
+			return fmt.Errorf("locking tmp dir: %w", err)
+		}
+		defer unlock()
+	} else if config.keepTempFiles {
+		defer config.logger.Printf("Modified files retained in tmp dir: %s", tmpDir)
+	} else {
+		// The deferred removal also runs when the modifier panics,
+		// before the panic crashes the process.
 		defer os.RemoveAll(tmpDir)
-		config.logger.Printf("Created tmp dir: %s", tmpDir)
+		defer trackTmpDir(tmpDir)()
+	}
+
+	unit := &compileUnit{
+		pkgPath:   flagValue(args, "-p"),
+		goVersion: flagValue(args, "-lang"),
+		importcfg: flagValue(args, "-importcfg"),
+		goarch:    config.build.goarch,
+		args:      args,
+		settings:  pkgConfig.Settings,
+		goFiles:   goFiles,
+		tmpDir:    tmpDir,
+		fset:      token.NewFileSet(),
+
+		identPrefix:      config.identPrefix,
+		allocMeasurement: config.allocMeasurement,
+	}
+
+	if prof != nil {
+		defer func() {
+			if profileErr := prof.write(config.profileWriter, unit.pkgPath); profileErr != nil {
+				err = errors.Join(err, fmt.Errorf("writing profile: %w", profileErr))
+			}
+		}()
+	}
+
+	if config.reportPath != "" {
+		defer func() {
+			if reportErr := writeReport(config.reportPath, unit, time.Since(start), err); reportErr != nil {
+				err = errors.Join(err, reportErr)
+			}
+		}()
+	}
+
+	// Go through each project file and select it for modification.
+	candidates := projectFiles
+	if skipReason != "" {
+		candidates = nil
+	}
+	var paths []string
+	for _, filePathToCompile := range candidates {
+		if !config.testFilesMatch(filePathToCompile) {
+			config.logger.Printf("Skipping file according to test files mode: %s", filePathToCompile)
+			continue
+		}
+
+		if changed != nil && !changed[filePathToCompile] {
+			config.logger.Printf("Skipping unchanged file: %s", filePathToCompile)
+			continue
+		}
 
-		// Retrieve the path of the modified file we want to compile,
-		// including it's imports.
-		// Read more about imports in [processFile]
-		newFilePathToCompile, fileImports, err := processFile(tmpDir, filePathToCompile, modifier)
+		if config.pathFilter != nil && !config.pathFilter(filePathToCompile) {
+			config.logger.Printf("Skipping file rejected by the path filter: %s", filePathToCompile)
+			continue
+		}
+
+		// The file is parsed into the unit, so the checks cost
+		// no extra read when the file is modified afterwards.
+		f, err := unit.parse(filePathToCompile)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("parsing %s: %w", filePathToCompile, err)
+		}
+
+		// cmd/go passes the files translated by cgo to the compiler instead of the ones importing "C",
+		// but other build drivers may not. Their preamble and pseudo-package references must not
+		// go through the decorator, which is unaware of them.
+		if importsC(f) {
+			config.logger.Printf("Skipping cgo file: %s", filePathToCompile)
+			continue
 		}
-		config.logger.Printf("Code modifications completed for file: %s", filePathToCompile)
 
-		// Retrieve the path to the importcfg file.
-		// This file is required for `go tool compile` as `-importcfg <path>` flag
-		// to resolve all imports of the compiled file. Our task is to add to this file
-		// all missing imports that were added during our modifications.
-		// Otherwise a compilation will fail with `could not import: <package> (open : no such file or directory)`
-		importCfg, err := importcfgPath(os.Args)
+		if config.skipGenerated && ast.IsGenerated(f) {
+			config.logger.Printf("Skipping generated file: %s", filePathToCompile)
+			continue
+		}
+
+		if skipsFile(f) {
+			config.logger.Printf("Skipping file marked with //goinject:skip: %s", filePathToCompile)
+			continue
+		}
+
+		paths = append(paths, filePathToCompile)
+	}
+
+	if config.modifierPlugin != "" && len(paths) > 0 {
+		pluginModifier, err := loadModifierPlugin(config.modifierPlugin)
+		if errors.Is(err, errPluginUnsupported) {
+			config.logger.Printf("Warning: %s, using the built-in modifier", err)
+		} else if err != nil {
+			return err
+		} else {
+			modifier = pluginModifier
+		}
+	}
+
+	// The support packages of the injected code are added to importcfg up front,
+	// so a package that can not be resolved fails the build before any file is modified.
+	if len(config.runtimeImports) > 0 && len(paths) > 0 {
+		var specs []*ast.ImportSpec
+		for _, imp := range config.runtimeImports {
+			specs = append(specs, &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(imp.path)}})
+		}
+		if err := addMissingPkgs(config, unit.pkgPath, unit.importcfg, specs); err != nil {
+			return fmt.Errorf("adding runtime imports: %w", err)
+		}
+	}
+
+	enterStage("modifying files")
+	var stopCPUProfile func() error
+	if config.cpuProfileDir != "" {
+		stopCPUProfile, err = startCPUProfile(config.cpuProfileDir, unit)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		defer func() {
+			if stopErr := stopCPUProfile(); stopErr != nil {
+				err = errors.Join(err, fmt.Errorf("writing CPU profile: %w", stopErr))
+			}
+		}()
+	}
+
+	var modified map[string]string
+	var errs []error
+	err = interruptible(ctx, func() {
+		if isPackageModifier(modifier) {
+			// Package modifiers see the files of a single package at a time.
+			modified = make(map[string]string)
+			for _, group := range groupByDir(paths) {
+				groupModified, groupErrs := modifyPackage(config, unit, group, modifier)
+				maps.Copy(modified, groupModified)
+				errs = append(errs, groupErrs...)
+				if len(errs) > 0 && !config.collectErrors || ctx.Err() != nil {
+					break
+				}
+			}
+		} else {
+			modified, errs = modifyFiles(config, unit, paths, modifier)
 		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
 
-		// Add all missing packages to importcfg file.
-		err = addMissingPkgs(importCfg, fileImports)
+	// The profile covers the modification only, rather than the compiler run too.
+	if stopCPUProfile != nil {
+		if err := stopCPUProfile(); err != nil {
+			return fmt.Errorf("writing CPU profile: %w", err)
+		}
+	}
+
+	// Feature flags are baked into every project file declaring them, modified or not.
+	if len(config.featureFlags) > 0 {
+		stamped, err := stampFlagFiles(config, unit, projectFiles, modified)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if modified == nil {
+			modified = make(map[string]string)
+		}
+		maps.Copy(modified, stamped)
+	}
+
+	for idx, filePathToCompile := range goFiles {
+		if newFilePathToCompile, ok := modified[filePathToCompile]; ok {
+			newArgs[goFileIndexes[idx]+argsOffset] = newFilePathToCompile
+		}
+	}
+
+	// The files dropped by the modifiers are removed from the arguments last to first,
+	// so the indexes of the ones before them stay valid.
+	for idx := len(goFiles) - 1; idx >= 0; idx-- {
+		if unit.isDropped(goFiles[idx]) {
+			config.logger.Printf("Dropping file as requested by the modifier: %s", goFiles[idx])
+			newArgs = slices.Delete(newArgs, goFileIndexes[idx]+argsOffset, goFileIndexes[idx]+argsOffset+1)
+		}
+	}
+
+	// The files added by the modifiers are compiled along with the original ones.
+	addedFiles, err := writeSyntheticFiles(config, unit)
+	if err != nil {
+		return err
+	}
+	newArgs = append(newArgs, addedFiles...)
+
+	if err := unit.flush(); err != nil {
+		return fmt.Errorf("writing modified files: %w", err)
+	}
+
+	// The package left out is compiled with the feature flags baked in only.
+	if skipReason != "" {
+		return runCommand(config.build, newArgs[toolOffset], newArgs[argsOffset:])
+	}
+
+	if config.importcfgRewriter != nil {
+		if err := rewriteImportcfg(unit.importcfg, config.importcfgRewriter); err != nil {
+			return fmt.Errorf("rewriting importcfg: %w", err)
 		}
-		config.logger.Printf("Missing packages added to importcfg file: %s", importCfg)
+	}
 
-		newArgs = append(newArgs, newFilePathToCompile)
+	if config.beforeCompile != nil {
+		if err := config.beforeCompile(slices.Clone(newArgs[toolOffset:])); err != nil {
+			return fmt.Errorf("before compile hook: %w", err)
+		}
 	}
 
 	// Run the the original `go tool compile` command with new arguments
 	// to propagate our changes to the compiler.
-	runCommand(newArgs[toolOffset], newArgs[argsOffset:])
+	enterStage("compiling")
+	if config.translateErrors {
+		err = runTranslatedCommand(config.build, newArgs[toolOffset], newArgs[argsOffset:], unit.lineMaps)
+	} else {
+		err = runCommand(config.build, newArgs[toolOffset], newArgs[argsOffset:])
+	}
+	if config.afterCompile != nil {
+		config.afterCompile(slices.Clone(newArgs[toolOffset:]), err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeLinkDeps(config.build, flagValue(args, "-buildid")); err != nil {
+		return err
+	}
 	config.logger.Printf("Package compiled")
+
+	return nil
+}
+
+// compileUnit describes a single invocation of the compiler, which compiles one package.
+type compileUnit struct {
+	// pkgPath is the import path of the package being compiled, as passed with the -p flag.
+	pkgPath string
+	// goVersion is the language version of the package, as passed with the -lang flag.
+	goVersion string
+	// importcfg is the path to the importcfg file of the compile unit.
+	importcfg string
+	// goarch is the architecture the package is compiled for.
+	goarch string
+	// args are the arguments the compiler was called with.
+	args []string
+	// settings are the settings of the package from the config file, see [WithConfigFile].
+	settings map[string]any
+	// goFiles are the original Go files of the package.
+	goFiles []string
+	// tmpDir is the directory to where the modified files are written.
+	tmpDir string
+	// identPrefix is the prefix of the injected identifiers, see [WithIdentPrefix].
+	identPrefix string
+	// allocMeasurement enables [AllocMeasure.Inject], see [WithAllocMeasurement].
+	allocMeasurement bool
+
+	// fset is shared by all the files of the unit, so their positions
+	// and type information can be related to each other.
+	fset   *token.FileSet
+	mu     sync.Mutex
+	parsed map[string]*ast.File
+
+	// resolvers are the packages resolvers by the directories of the packages.
+	resolvers map[string]guess.RestorerResolver
+	// reportFiles are the modified files recorded for [WithReport].
+	reportFiles []reportFile
+	// ensured holds the declarations added once per package, see [ModifyContext.EnsureInit],
+	// and pendingEnsured the ones added to the files not written yet, by the paths of the files.
+	ensured        map[string]bool
+	pendingEnsured map[string]map[string]dst.Decl
+	// held are the contents of the modified files by their paths, written right before
+	// the compiler is called, see [WithInMemory].
+	held map[string][]byte
+	// lineMaps are the line mappings of the modified files by the paths the compiler
+	// may report their positions against, see [WithErrorTranslation].
+	lineMaps map[string]lineMap
+	// synthetic are the files added to the package by the modifiers, see [ModifyContext.AddFile].
+	synthetic []syntheticFile
+	// dropped are the original files removed from the package by the modifiers, see [ModifyContext.DropFile].
+	dropped map[string]bool
+
+	siblingsOnce sync.Once
+	siblings     map[string]*dst.File
+	siblingsErr  error
+
+	// typed are the results of type-checking the packages of the unit by their names, see [compileUnit.typeCheck].
+	typesMu sync.Mutex
+	typed   map[string]*typedPackage
+
+	// importGraph maps the packages listed to check the injected imports for cycles to their imports,
+	// see [compileUnit.loadImportGraph].
+	importGraphMu sync.Mutex
+	importGraph   map[string][]string
+}
+
+// tmpPath returns the path within the tmp dir to where the modified copy of the file is written.
+// Copies are namespaced by a hash of the full original path, so files sharing a basename
+// never overwrite each other, while the basename itself is kept for compiler diagnostics.
+func (u *compileUnit) tmpPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+
+	return filepath.Join(u.tmpDir, hex.EncodeToString(sum[:8]), filepath.Base(path))
+}
+
+// hold keeps the content of the modified file to be written by flush.
+func (u *compileUnit) hold(path string, content []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.held == nil {
+		u.held = make(map[string][]byte)
+	}
+	u.held[path] = content
+}
+
+// flush writes the held modified files to disk.
+func (u *compileUnit) flush() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for path, content := range u.held {
+		if err := u.output(path, content); err != nil {
+			return err
+		}
+	}
+	u.held = nil
+
+	return nil
+}
+
+// interruptible calls fn, but returns the error of the context if it is done before fn returns.
+// fn is then left running, so it must not be relied upon to finish. A panic of fn is returned
+// as a [PanicError].
+func interruptible(ctx context.Context, fn func()) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		fn()
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// modifyFile modifies a single file of the compile unit and patches the importcfg file
+// with the packages the modifications require. It returns the path to the modified file.
+func modifyFile(config *config, unit *compileUnit, path string, modifier Modifier) (string, error) {
+	file, err := decorateFile(config, unit, path)
+	if err != nil {
+		return "", err
+	}
+
+	if !config.fileFilterMatch(file) {
+		return path, nil
+	}
+
+	if err := unit.checkIdentPrefix(path); err != nil {
+		return "", err
+	}
+
+	// Make the necessary changes to the AST file
+	err = config.recovering(file.Context, func() error {
+		restoreSkipped := protectSkippedFuncs(file.File)
+		f, err := modify(modifier, file.Context, file.File, file.Decorator, file.Restorer)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			return errNilFile(modifier)
+		}
+		restoreSkipped(f)
+		file.File = f
+		return nil
+	})
+	if errors.Is(err, errRecovered) {
+		return path, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if unit.isDropped(path) {
+		return path, nil
+	}
+
+	return completeFile(config, unit, file, modifier)
+}
+
+// errRecovered reports that the modifier failed and the original file is compiled instead, see [WithRecover].
+var errRecovered = errors.New("modifier failure recovered")
+
+// recovering calls fn applying the modifier to the file. With [WithRecover], a panic of the modifier
+// or an error of fn is reported as a warning against the file and errRecovered is returned.
+func (c *config) recovering(ctx *ModifyContext, fn func() error) (err error) {
+	if !c.recover {
+		return fn()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("modifier panicked: %v", r)
+		}
+		if err != nil {
+			ctx.Warnf(token.NoPos, "modifier failed, compiling as is: %s", err)
+			err = errRecovered
+		}
+	}()
+
+	return fn()
 }
 
-// extractFilesFromPack extracts all the go files from args.
-// Files are specified after a -pack flag.
-func extractFilesFromPack(args []string) ([]string, int, error) {
-	packIndex := -1
-	for i, arg := range args {
-		if arg == "-pack" {
-			packIndex = i
+// completeFile writes the modified file and patches the importcfg file
+// with the packages the modifications require. It returns the path to the modified file.
+func completeFile(config *config, unit *compileUnit, file *PackageFile, modifier Modifier) (string, error) {
+	path := file.Context.Path
+
+	// The modifier may return after the run was aborted, when the tmp dir is being removed.
+	if err := config.build.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// The modifier may decide to leave the file alone after looking at it,
+	// in which case the original file is compiled and its imports need no patching.
+	if skipper, ok := modifier.(Skipper); ok && skipper.Skip(file.File) {
+		config.logger.Printf("Skipping file as requested by the modifier: %s", path)
+		return path, nil
+	}
+
+	// Retrieve the path of the modified file we want to compile,
+	// including it's imports.
+	// Read more about imports in [processFile]
+	newFilePath, fileImports, err := processFile(config, unit, file, modifier)
+	if err != nil {
+		return "", err
+	}
+	unit.recordFile(reportFile{
+		Original:     path,
+		Modified:     newFilePath,
+		AddedImports: addedImports(importPaths(file.astFile.Imports), importPaths(fileImports)),
+	})
+	config.logger.Printf("Code modifications completed for file: %s", path)
+
+	if config.importCycleCheck {
+		err = unit.checkImportCycles(config.build, path, fileImports)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The importcfg file is required for `go tool compile` as `-importcfg <path>` flag
+	// to resolve all imports of the compiled file. Our task is to add to this file
+	// all missing imports that were added during our modifications.
+	// Otherwise a compilation will fail with `could not import: <package> (open : no such file or directory)`
+	err = addMissingPkgs(config, unit.pkgPath, unit.importcfg, fileImports)
+	if err != nil {
+		return "", err
+	}
+	config.logger.Printf("Missing packages added to importcfg file: %s", unit.importcfg)
+
+	return newFilePath, nil
+}
+
+// modifyFiles modifies the files, one by one or concurrently as configured with [WithParallelism],
+// and returns the paths to the modified files by the paths of the original ones.
+func modifyFiles(config *config, unit *compileUnit, paths []string, modifier Modifier) (map[string]string, []error) {
+	// The results are collected by the positions of the files, so they do not depend
+	// on the order the files are processed in with [WithParallelism].
+	newPaths := make([]string, len(paths))
+	errs := make([]error, len(paths))
+
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, max(config.parallelism, 1))
+
+	for idx, path := range paths {
+		workers <- struct{}{}
+
+		// Either stop at the first failure, or keep going to give
+		// a complete picture of what is broken in a single build.
+		if failed.Load() && !config.collectErrors || config.build.ctx.Err() != nil {
+			<-workers
 			break
 		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			defer func() {
+				if r := recover(); r != nil {
+					errs[idx] = fmt.Errorf("modifying %s: %w", path, &PanicError{Value: r, Stack: debug.Stack()})
+					failed.Store(true)
+				}
+			}()
+
+			newPath, err := modifyFile(config, unit, path, modifier)
+			var modifyErr *ModifyError
+			if errors.As(err, &modifyErr) {
+				// The error already names the file.
+				errs[idx] = err
+				failed.Store(true)
+				return
+			}
+			if err != nil {
+				errs[idx] = fmt.Errorf("modifying %s: %w", path, err)
+				failed.Store(true)
+				return
+			}
+			newPaths[idx] = newPath
+		}()
+	}
+	wg.Wait()
+
+	modified := make(map[string]string)
+	var joined []error
+	for idx, path := range paths {
+		if errs[idx] != nil {
+			joined = append(joined, errs[idx])
+			if !config.collectErrors {
+				break
+			}
+			continue
+		}
+		modified[path] = newPaths[idx]
 	}
 
-	if packIndex == -1 {
-		return nil, 0, fmt.Errorf("-pack flag is not found")
+	return modified, joined
+}
+
+// relevantFiles returns the .go files of the compile unit that belong to the project.
+//
+// The files are grouped by the directory they reside in, i.e. by package, and a group
+// is relevant only if all of its files are inside of the project roots. Non .go files are never
+// returned, but they do not make a group irrelevant either: a package can legitimately ship
+// assembly or header files alongside its Go code, and those must not prevent
+// the Go files from being modified.
+func relevantFiles(files []string, roots []string) []string {
+	var relevant []string
+	for _, group := range groupByDir(slices.DeleteFunc(slices.Clone(files), func(file string) bool {
+		return !isGoFile(file)
+	})) {
+		if !slices.ContainsFunc(group, func(file string) bool { return !isWithin(file, roots) }) {
+			relevant = append(relevant, group...)
+		}
+	}
+
+	return relevant
+}
+
+// groupByDir groups the files by the directory they reside in, preserving their order.
+func groupByDir(files []string) [][]string {
+	var groups [][]string
+	index := make(map[string]int)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		idx, ok := index[dir]
+		if !ok {
+			idx = len(groups)
+			index[dir] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], file)
+	}
+
+	return groups
+}
+
+// sourceFiles returns the source files among the arguments of a tool, for logging.
+func sourceFiles(args []string) []string {
+	var files []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		switch filepath.Ext(arg) {
+		case ".go", ".s", ".S", ".c", ".h":
+			files = append(files, arg)
+		}
+	}
+
+	return files
+}
+
+// testFilesMatch reports whether the file must be modified according to the test files mode.
+func (c *config) testFilesMatch(path string) bool {
+	isTest := strings.HasSuffix(path, "_test.go")
+
+	switch c.testFiles {
+	case skipTestFiles:
+		return !isTest
+	case onlyTestFiles:
+		return isTest
+	default:
+		return true
 	}
+}
 
-	var goFiles []string
-	for i := packIndex + 1; i < len(args); i++ {
-		goFiles = append(goFiles, args[i])
+// fileFilterMatch reports whether the file is accepted by the filter given to [WithFileFilter].
+func (c *config) fileFilterMatch(file *PackageFile) bool {
+	if c.fileFilter == nil || c.fileFilter(file.Context.Path, file.File) {
+		return true
 	}
 
-	goFilesIndex := packIndex + argsOffset + 1
+	c.logger.Printf("Skipping file rejected by the file filter: %s", file.Context.Path)
+	return false
+}
+
+// isGoFile reports whether the file is a Go source file.
+func isGoFile(path string) bool {
+	return filepath.Ext(path) == ".go"
+}
 
-	return goFiles, goFilesIndex, nil
+// compileValueFlags are the flags of the compiler taking a value as a separate argument.
+var compileValueFlags = []string{
+	"-D", "-I", "-asmhdr", "-bench", "-blockprofile", "-buildid", "-c", "-coveragecfg",
+	"-cpuprofile", "-d", "-embedcfg", "-env", "-goversion", "-importcfg", "-installsuffix",
+	"-json", "-lang", "-linkobj", "-memprofile", "-memprofilerate", "-mutexprofile", "-o",
+	"-p", "-pgoprofile", "-spectre", "-symabis", "-traceprofile", "-trimpath",
+}
+
+// goSourceArgs returns the Go files among the arguments of the compiler, along with
+// their indexes in args. The flags are skipped wherever they are, along with their values,
+// so a value like the one of `-asmhdr go_asm.h` is never taken for a file to compile.
+func goSourceArgs(args []string) ([]string, []int) {
+	var files []string
+	var indexes []int
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if strings.HasPrefix(arg, "-") {
+			if slices.Contains(compileValueFlags, arg) {
+				idx++
+			}
+			continue
+		}
+
+		if isGoFile(arg) {
+			files = append(files, arg)
+			indexes = append(indexes, idx)
+		}
+	}
+
+	return files, indexes
 }
 
 // addMissingPkgs will go through all passed imports and if the importcfg file
 // does not yet contain this package, it will add its declaration as a new line in importcfg.
-func addMissingPkgs(importCfgPath string, fileImports []*dst.ImportSpec) error {
+//
+// A package may already be listed under a different path, like a vendored package listed
+// under its vendor path, which the compiler knows from the importmap lines. If the resolved
+// archive of an import is listed under another path, the existing entry is preferred:
+// the import is mapped to it rather than listed twice with the same archive.
+func addMissingPkgs(config *config, pkgPath string, importCfgPath string, fileImports []*ast.ImportSpec) error {
+	listed, importMap, err := readImportcfg(importCfgPath)
+	if err != nil {
+		return err
+	}
+
+	// The archives of the listed packages, for finding the packages listed under other paths.
+	listedArchives := make(map[string]string, len(listed))
+	for name, archive := range listed {
+		listedArchives[archive] = name
+	}
+
+	var entries []ImportcfgEntry
+	mappings := make(map[string]string)
 	for _, fileImport := range fileImports {
-		pkgName := strings.ReplaceAll(fileImport.Path.Value, `"`, "")
-		pkgFound := isPkgInImportCfg(importCfgPath, pkgName)
+		// The import may be aliased or a dot import, the package is identified by its path alone.
+		pkgName, err := strconv.Unquote(fileImport.Path.Value)
+		if err != nil {
+			return fmt.Errorf("malformed import path %s: %w", fileImport.Path.Value, err)
+		}
 
-		if pkgFound {
+		// Neither "unsafe" nor the cgo pseudo-package "C" has an archive.
+		if pkgName == "unsafe" || pkgName == "C" {
 			continue
 		}
 
-		if pkgName == "unsafe" {
+		// The compiler rejects a package importing itself, with an error pointing to the modified file.
+		if pkgName == pkgPath {
+			return fmt.Errorf("injected import %s is the package being compiled, which can not import itself", pkgName)
+		}
+
+		if _, ok := listed[pkgName]; ok {
 			continue
 		}
 
-		packages, err := ResolvePkg(pkgName)
+		if mapped, ok := importMap[pkgName]; ok {
+			if _, ok := listed[mapped]; ok {
+				continue
+			}
+		}
+
+		packages, err := config.resolver(pkgName)
 		if err != nil {
 			return fmt.Errorf("failed resolving packages: %w", err)
 		}
 
-		pkgPath, pkgFound := packages[pkgName]
+		archive, pkgFound := packages[pkgName]
 		if !pkgFound {
 			return fmt.Errorf("package '%s' not found after resolving", pkgName)
 		}
 
-		err = addMissingPkgToImportcfg(importCfgPath, pkgName, pkgPath)
-		if err != nil {
-			return fmt.Errorf("failed adding pkg '%s' to importcfg: %w", pkgName, err)
+		if existing, ok := listedArchives[archive]; ok && existing != pkgName {
+			config.logger.Printf("Warning: package %s is already listed in importcfg as %s, mapping the import to it", pkgName, existing)
+			mappings[pkgName] = existing
+			continue
 		}
-	}
-
-	return nil
-}
 
-// addMissingPkgToImportcfg writes a given package to importcfg file, so that
-// compiler can resolce them during compile/link process.
-func addMissingPkgToImportcfg(importcfgPath string, pkgName string, pkgPath string) error {
-	file, err := os.OpenFile(importcfgPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("error opening file: %w", err)
+		entries = append(entries, ImportcfgEntry{Name: pkgName, Path: archive})
+		recordLinkDeps(packages)
 	}
-	defer file.Close()
 
-	content := fmt.Sprintf("packagefile %s=%s\n", pkgName, pkgPath)
-	if _, err := file.WriteString(content); err != nil {
-		return fmt.Errorf("error appending content to file: %w", err)
+	// All the entries are added at once, so the compiler of a package that is
+	// built concurrently with the same importcfg never sees a partial file.
+	if err := addImportcfgEntries(importCfgPath, entries, mappings); err != nil {
+		return fmt.Errorf("failed adding packages to importcfg: %w", err)
 	}
 
 	return nil
 }
 
-// processFile performs all necessary manipulations on a file, including
-// parsing its AST, making changes to that AST, and writing the modified AST as
-// a new file to a temporary directory.
-// processFile returns the path to the modified file, as well as all its relevant imports,
-// which we will need when patching importcfg file.
-func processFile(tmpDir string, path string, modifier Modifier) (string, []*dst.ImportSpec, error) {
+// decorateFile parses the file at path and decorates it, preparing it to be modified.
+func decorateFile(config *config, unit *compileUnit, path string) (*PackageFile, error) {
 	// Obtain a packages resolver to automatically manage trivial and non-trivial imports.
-	resolver, err := packagesResolver()
+	resolver, err := unit.packagesResolver(config, filepath.Dir(path))
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	// NewRestorerWithImports is needed to add imports to the file that
@@ -271,93 +1133,297 @@ func processFile(tmpDir string, path string, modifier Modifier) (string, []*dst.
 	// but we added code that uses this package, then
 	// NewRestorerWithImports will add "fmt" to the imports list.
 	restorer := decorator.NewRestorerWithImports(path, resolver)
-	decorator := decorator.NewDecoratorWithImports(restorer.Fset, path, goast.WithResolver(resolver))
 
-	f, err := dstFile(path, decorator)
+	astFile, err := unit.parse(path)
 	if err != nil {
-		return "", nil, err
+		return nil, err
+	}
+
+	// Identifiers of dot-imported packages can only be told apart from
+	// the local ones with the type information of the package.
+	var identResolver dstresolver.DecoratorResolver = goast.WithResolver(resolver)
+	if hasDotImport(astFile) {
+		_, info, err := unit.typeCheck(path)
+		if err != nil {
+			return nil, fmt.Errorf("resolving dot imports: %w", err)
+		}
+		identResolver = gotypes.New(info.Uses)
+	}
+
+	decorator := decorator.NewDecoratorWithImports(unit.fset, path, identResolver)
+
+	f, err := decorator.DecorateFile(astFile)
+	if err != nil {
+		return nil, err
 	}
 
 	if f == nil {
-		return "", nil, fmt.Errorf("received nil dst.File for: %s", path)
+		return nil, fmt.Errorf("received nil dst.File for: %s", path)
 	}
 
-	// Make the necessary changes to the AST file
-	f = modifier.Modify(f, decorator, restorer)
+	ctx := &ModifyContext{
+		Path:        path,
+		PackagePath: unit.pkgPath,
+		ModuleRoot:  moduleRoot(filepath.Dir(path)),
+		GoVersion:   unit.goVersion,
+		CompileArgs: slices.Clone(unit.args),
+		Race:        slices.Contains(unit.args, "-race"),
+		Cover:       flagValue(unit.args, "-coveragecfg") != "",
+		Settings:    unit.settings,
+		BuildTags:   slices.Clone(config.build.tags),
+		Test:        unit.hasTestFiles(),
+		TmpDir:      unit.tmpDir,
+		dec:         decorator,
+		unit:        unit,
+		stderr:      config.build.stderr,
+
+		identResolver: identResolver,
+	}
+
+	// The compiler receives the language version with the -lang flag,
+	// but other build drivers may not pass it.
+	if ctx.GoVersion == "" && ctx.ModuleRoot != "" {
+		ctx.GoVersion = moduleGoVersion(ctx.ModuleRoot)
+	}
+
+	return &PackageFile{
+		Context:   ctx,
+		File:      f,
+		Decorator: decorator,
+		Restorer:  restorer,
+		astFile:   astFile,
+		resolver:  resolver,
+	}, nil
+}
+
+// processFile performs all necessary manipulations on a modified file, including
+// restoring its AST and writing it as a new file to a temporary directory.
+// processFile returns the path to the modified file, as well as all its relevant imports,
+// which we will need when patching importcfg file.
+func processFile(config *config, unit *compileUnit, file *PackageFile, modifier Modifier) (string, []*ast.ImportSpec, error) {
+	path, f, astFile, ctx := file.Context.Path, file.File, file.astFile, file.Context
+	decorator, restorer, resolver := file.Decorator, file.Restorer, file.resolver
+
+	var err error
+
+	resolveImportRefs(f, decorator, resolver)
+
+	// The declarations added once per package are settled only now that the file is written for sure.
+	unit.commitEnsured(path, f)
+
+	// Bake the configured feature flag values into the package-level variables.
+	if len(config.featureFlags) > 0 {
+		err = stampFeatureFlags(f, unit.pkgPath, config.featureFlags)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if config.lineDirectives == LineDirectivesAccurate {
+		addLineDirectives(f, decorator, path)
+	}
+
+	var code bytes.Buffer
+	err = restorer.Fprint(&code, f)
+	if err != nil {
+		// The restorer formats the code, which fails on invalid syntax with an error
+		// pointing to the generated code. Printing it as is allows to report it against the original file.
+		if config.validateOutput {
+			if raw, rawErr := printRestored(path, resolver, f); rawErr == nil {
+				if err := validateOutput(path, raw); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+		return "", nil, err
+	}
+
+	// Formatting is applied before the line directives are attached to the statements,
+	// since gofmt would move them back onto their own lines.
+	if config.format {
+		formatted, err := format.Source(code.Bytes())
+		if err != nil {
+			config.logger.Printf("Warning: failed formatting modified file %s, keeping it unformatted: %s", path, err)
+		} else {
+			code = *bytes.NewBuffer(formatted)
+		}
+	}
+
+	if config.lineDirectives == LineDirectivesAccurate {
+		code = *bytes.NewBuffer(attachLineDirectives(code.Bytes(), path))
+	}
 
 	var out bytes.Buffer
 
 	// Add /*line */ directive so stack unwinding and caller frames will point to
 	// original source code instead of preprocessed one (especially since we remove the modified code after compilation.)
-	_, err = out.WriteString(fmt.Sprintf("/*line %s:1:1*/\n", path))
-	if err != nil {
-		return "", nil, fmt.Errorf("appending line directive: %w", err)
+	// The directive applies to the character right after it, so it must not be followed
+	// by a newline, otherwise every position would be shifted by one line.
+	if config.lineDirectives != LineDirectivesOff {
+		_, err = out.WriteString(lineDirective(path, 1, 1))
+		if err != nil {
+			return "", nil, fmt.Errorf("appending line directive: %w", err)
+		}
 	}
+	out.Write(code.Bytes())
 
-	err = restorer.Fprint(&out, f)
-	if err != nil {
+	if config.validateOutput {
+		err = validateOutput(path, out.Bytes())
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := checkEmbeds(astFile, out.Bytes()); err != nil {
 		return "", nil, err
 	}
 
-	// Write our modified file to the temporary directory we created at the beginning.
-	newFileName := tmpDir + string(os.PathSeparator) + filepath.Base(path)
-	output(newFileName, &out)
+	if config.directiveValidation != DirectiveValidationOff {
+		errs := validateDirectives(astFile, out.Bytes())
+		for _, err := range errs {
+			if config.directiveValidation == DirectiveValidationWarn {
+				ctx.Warnf(token.NoPos, "%s", err)
+			}
+		}
+		if config.directiveValidation == DirectiveValidationError && len(errs) > 0 {
+			return "", nil, errors.Join(errs...)
+		}
+	}
+
+	// Write our modified file to the temporary directory we created at the beginning,
+	// or hold it until the compiler is called with [WithInMemory].
+	newFileName := unit.tmpPath(path)
+	if config.inMemory {
+		unit.hold(newFileName, out.Bytes())
+	} else {
+		err = unit.output(newFileName, out.Bytes())
+		if err != nil {
+			return "", nil, fmt.Errorf("writing modified file: %w", err)
+		}
+	}
+
+	// Line directives in front of every original node already make the compiler
+	// report the positions of the original code exactly, so there is nothing to translate.
+	translateErrors := config.translateErrors && config.lineDirectives != LineDirectivesAccurate
+	config.build.profile.recordFile(out.Len())
+
+	if config.sourceMapDir != "" || translateErrors {
+		lines, err := sourceMapLines(f, decorator, restorer, out.Bytes())
+		if err != nil {
+			return "", nil, fmt.Errorf("mapping generated lines: %w", err)
+		}
+
+		if translateErrors {
+			unit.recordLineMap(newFileName, path, lines)
+		}
+
+		if config.sourceMapDir != "" {
+			sm := sourceMap{Original: path, Generated: newFileName, Lines: lines}
+			if err := writeSourceMap(config.sourceMapDir, unit, sm); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	// The modifier may report the imports it adds by itself, which spares the reread below.
+	if declarer, ok := modifier.(ImportDeclarer); ok {
+		return newFileName, declaredImports(astFile, declarer), nil
+	}
 
-	// Read modified file to retrieve relevant imports.
+	// Parse modified code to retrieve relevant imports.
 	// Since apparently it is impossible to see changed imports in
 	// the already decorated file. I could be wrong.
-	// But explicit rereading definitely works.
-	f, err = dstFile(newFileName, decorator)
+	// But explicit reparsing definitely works.
+	// Only the import declarations are parsed, so aliased and dot imports
+	// are captured without having to resolve the identifiers using them.
+	imports, err := fileImports(newFileName, out.Bytes())
 	if err != nil {
 		return "", nil, err
 	}
 
-	return newFileName, f.Imports, nil
+	return newFileName, imports, nil
 }
 
-// dstFile parses the .go file at the specified path and returns an
-// AST node, which we will further modify.
-func dstFile(path string, dec *decorator.Decorator) (*dst.File, error) {
-	astFile, err := parser.ParseFile(dec.Fset, path, nil, parser.ParseComments|parser.SkipObjectResolution)
+// fileImports parses the import declarations of the .go file at the specified path,
+// or of its content if src is not nil, see [parser.ParseFile].
+func fileImports(path string, src any) ([]*ast.ImportSpec, error) {
+	astFile, err := parser.ParseFile(token.NewFileSet(), path, src, parser.ImportsOnly)
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := dec.DecorateFile(astFile)
-	if err != nil {
-		return nil, err
-	}
-
-	return f, err
+	return astFile.Imports, nil
 }
 
 // packagesResolver composes a [guess.RestorerResolver], that can be used in [NewDecoratorWithImports] and
 // [NewRestorerWithImports] to automatically manage imports on file AST modifications.
-func packagesResolver() (guess.RestorerResolver, error) {
-	packagesMap, err := loadPackages()
+//
+// The resolver knows the names of the packages imported by the package in dir, which is loaded once per
+// compile unit. The names of other packages, e.g. the ones only imported by the injected code,
+// are guessed from their import paths.
+func (u *compileUnit) packagesResolver(config *config, dir string) (guess.RestorerResolver, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if resolver, ok := u.resolvers[dir]; ok {
+		return resolver, nil
+	}
+
+	// A resolver given with WithResolver replaces the loaded one entirely.
+	if config.restorerResolver != nil {
+		return config.restorerResolver, nil
+	}
+
+	packagesMap, err := retry(config, "loading packages in "+dir, func() (map[string]string, error) {
+		return loadPackages(config.build, dir)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed composing packages resolver: %w", err)
 	}
+	for _, imp := range config.runtimeImports {
+		packagesMap[imp.path] = imp.name
+	}
+	maps.Copy(packagesMap, config.resolverMap)
 
 	resolver := guess.WithMap(packagesMap)
 
+	if u.resolvers == nil {
+		u.resolvers = make(map[string]guess.RestorerResolver)
+	}
+	u.resolvers[dir] = resolver
+
 	return resolver, nil
 }
 
-// loadPackages loads all the packages from the path dir to
-// resolve non-trivial imports later on.
-func loadPackages() (map[string]string, error) {
+// loadPackages loads the package in dir, along with its test variants, to resolve
+// the names of the packages it imports, and its own name.
+//
+// Only the package being compiled is loaded rather than the whole project,
+// since the names of the packages the original files import are all the decorator needs.
+func loadPackages(build buildContext, dir string) (map[string]string, error) {
+	build.profile.countPackagesLoad()
 	loadedPackages, err := packages.Load(&packages.Config{
-		// Dir:  filepath.Dir(path),
-		Mode: packages.NeedName | packages.NeedImports | packages.NeedFiles},
-		"./...",
+		Context:    build.ctx,
+		Dir:        dir,
+		Mode:       packages.NeedName | packages.NeedImports,
+		Tests:      true,
+		BuildFlags: build.buildFlags(),
+		Env:        build.env()},
+		".",
 	)
 	if err != nil {
+		// Outside of a module, the go command in module mode can not load the package by its directory.
+		// Such a package can only import the std library, whose names are guessed from the paths.
+		if moduleRoot(dir) == "" {
+			return make(map[string]string), nil
+		}
 		return nil, fmt.Errorf("failed loading packages: %w", err)
 	}
 
 	pkgs := make(map[string]string)
 	for _, loadedPkg := range loadedPackages {
+		pkgs[loadedPkg.PkgPath] = loadedPkg.Name
+
 		for _, imp := range loadedPkg.Imports {
 			pkgs[imp.PkgPath] = imp.Name
 		}
@@ -372,13 +1438,22 @@ func loadPackages() (map[string]string, error) {
 // the actual path to the compiled package by its name. Then, we can use this path
 // as a value when adding missing package to importcfg in form of `packagefile {pkgName}={path}`
 func ResolvePkg(pkgName string) (map[string]string, error) {
-	args := []string{"list", "-json", "-deps", "-export", "--", pkgName}
+	return resolvePkg(buildContext{}, pkgName)
+}
 
-	cmd := exec.Command("go", args...)
-	var stdout bytes.Buffer
+// resolvePkg is [ResolvePkg] running `go list` within the given build context,
+// so that the resolved archives match the ones the compiler uses.
+func resolvePkg(build buildContext, pkgName string) (map[string]string, error) {
+	args := []string{"list", "-json", "-deps", "-export"}
+	args = append(args, build.buildFlags()...)
+	args = append(args, "--", pkgName)
+
+	cmd := build.goCommand(args...)
+	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("running %q: %w", cmd.Args, err)
+		return nil, fmt.Errorf("running %q: %w\n%s", cmd.Args, err, bytes.TrimSpace(stderr.Bytes()))
 	}
 
 	type listItem struct {
@@ -415,103 +1490,80 @@ func ResolvePkg(pkgName string) (map[string]string, error) {
 	return output, nil
 }
 
-// importcfgPath will try to extract the path to the importcfg file from the passed arguments.
-// importcfg is usually specified in the following format in the arguments:
-//
-//	-importcfg $WORK/b0XX/importcfg
-//
-// Where:
-//   - $WORK: temporary directory that the go toolchain itself creates;
-//   - b0XX: temporary directory for a certain compiler step
-//
-// Unfortunately, we have no way of knowing the exact path to $WORK, but apparently we don't need to:
-// https://github.com/golang/go/issues/45864
-// It is enough just to manipulate the path with $WORK specified at the beginning.
-func importcfgPath(args []string) (string, error) {
-	for idx := range args {
-		if args[idx] != "-importcfg" {
-			continue
+// flagValue returns the value of the given compiler flag passed in either the `-flag value`
+// or the `-flag=value` form, or an empty string if the flag is not present.
+func flagValue(args []string, flag string) string {
+	for idx, arg := range args {
+		if arg == flag && idx+1 < len(args) {
+			return args[idx+1]
 		}
 
-		return args[idx+1], nil
+		if value, found := strings.CutPrefix(arg, flag+"="); found {
+			return value
+		}
 	}
 
-	return "", fmt.Errorf("failed retrieving importcfg")
+	return ""
 }
 
-// isPkgInImportCfg checks whether provided package name already present
-// in the given importcfg file.
-// isPkgInImportCfg will open an importcfg file by [importcfgPath] path and
-// then scan it's content for the pattern `packagefile {pkgName}=`.
-func isPkgInImportCfg(importcfgPath string, pkgName string) bool {
-	file, err := os.Open(importcfgPath)
-	if err != nil {
-		return false
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	// The typical content of an importcfg file might be as follows:
-	//
-	// # import config
-	// packagefile fmt=/var/folders/jt/j7pkdpss14s693b7hgk_d2z00000gs/T/go-build2972637916/b002/_pkg_.a
-	// So our task is to check if there is a line with the packagefile of our package in the file.
-	pattern := fmt.Sprintf("packagefile %s=", pkgName)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, pattern) {
-			return true
+// output writes the content to the file by the given [fullName] path in the tmp dir.
+func (u *compileUnit) output(fullName string, content []byte) error {
+	// The files are written to the directories within the tmp dir, which are created as needed.
+	// The tmp dir itself is not, so a modifier returning after the run was aborted
+	// fails to write rather than bringing the removed tmp dir back.
+	if dirPath := filepath.Dir(fullName); dirPath != u.tmpDir {
+		if err := os.Mkdir(dirPath, os.ModePerm); err != nil && !os.IsExist(err) {
+			return err
 		}
 	}
 
-	return false
+	return os.WriteFile(fullName, content, os.ModePerm)
 }
 
-// output writes the content of [out] to the file by the given [fullName] path.
-func output(fullName string, out io.Reader) {
-	txt, _ := io.ReadAll(out)
-
-	if _, err := os.Stat(fullName); os.IsNotExist(err) {
-		dirPath := filepath.Dir(fullName)
-
-		err := os.MkdirAll(dirPath, os.ModePerm)
-		if err != nil {
-			panic(err)
-		}
+// runCommand executes the provided go toolchain command (with modifier args or not).
+// The returned error wraps the [exec.ExitError] of the failed command.
+func runCommand(build buildContext, tool string, args []string) error {
+	cmd := build.command(tool, args...)
+	cmd.Stdout = build.stdout
+	cmd.Stderr = build.stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", filepath.Base(tool), err)
 	}
 
-	err := os.WriteFile(fullName, txt, os.ModePerm)
+	return nil
+}
+
+// getwd returns the root directory of the project being built.
+// In workspace mode it is the directory of the go.work file, so that files
+// of every workspace module are treated as project files.
+// Otherwise it is the directory of the current module's go.mod file, or the working
+// directory outside of a module.
+func getwd(build buildContext) (string, error) {
+	goWork, err := goWork(build)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-}
 
-// runCommand executes the provided go toolchain command (with modifier args or not).
-func runCommand(tool string, args []string) {
-	cmd := exec.Command(tool, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	if goWork != "" {
+		return filepath.Dir(goWork), nil
 	}
-}
 
-func getwd() (string, error) {
-	cmd := exec.Command("go", "env", "GOMOD")
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("runnning %q: %w", cmd.Args, err)
+	goMod, err := goEnv(build, "GOMOD")
+	if err != nil {
+		return "", err
 	}
 
-	if goMod := strings.TrimSpace(stdout.String()); goMod != "" && goMod != os.DevNull {
+	if goMod != "" && goMod != os.DevNull {
 		return filepath.Dir(goMod), nil
 	}
 
-	wd, _ := os.Getwd()
+	// Outside of a module, e.g. in GOPATH mode, the directory the build runs in is the best guess
+	// of the project root. The packages it contains are modified, while the ones imported from
+	// elsewhere in GOPATH are not, even if they are a part of the same project.
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("getting working directory: %w", err)
+	}
 
-	return "", fmt.Errorf("in %q: %s", wd, "`go env GOMOD` returned a blank string")
+	return wd, nil
 }
@@ -0,0 +1,1260 @@
+package goinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// writeFiles writes the files given by their paths relative to dir.
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeCompiler writes a compiler standing in for `go tool compile`, which records
+// the arguments it is called with to the file returned as the second value,
+// and fails if exitCode is not zero. Asked for its version with -V=full, it prints one.
+func fakeCompiler(t *testing.T, exitCode int) (tool string, argsFile string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake compiler is a shell script")
+	}
+
+	dir := t.TempDir()
+	tool = filepath.Join(dir, "compile")
+	argsFile = filepath.Join(dir, "args")
+	script := "#!/bin/sh\nif [ \"$1\" = -V=full ]; then echo 'compile version go1.22.0'; exit 0; fi\nprintf '%s\\n' \"$@\" > '" + argsFile + "'\nexit " + string(rune('0'+exitCode)) + "\n"
+	if err := os.WriteFile(tool, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return tool, argsFile
+}
+
+// runCompile runs [ProcessE] in dir as if the go command called it with -toolexec
+// to compile a package with the given compiler and arguments. Like the go command,
+// the tests pass the files to compile by their absolute paths.
+func runCompile(t *testing.T, dir string, tool string, args []string, modifier Modifier, opts ...Option) error {
+	t.Helper()
+
+	return runCompileContext(t, context.Background(), dir, tool, args, modifier, opts...)
+}
+
+// chdir changes the working directory to dir for the rest of the test, like cmd/go
+// does for the tools of a package. The flags of the environment, like -mod,
+// may not apply to the modules of the test, so they are cleared for the go commands.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	t.Setenv("GOFLAGS", "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// runCompileContext is like [runCompile], but runs [ProcessContext] with the context.
+func runCompileContext(t *testing.T, ctx context.Context, dir string, tool string, args []string, modifier Modifier, opts ...Option) error {
+	t.Helper()
+
+	chdir(t, dir)
+
+	osArgs := os.Args
+	os.Args = append([]string{osArgs[0], tool}, args...)
+	t.Cleanup(func() { os.Args = osArgs })
+
+	return ProcessContext(ctx, modifier, opts...)
+}
+
+// compiledArgs returns the arguments the fake compiler was called with.
+func compiledArgs(t *testing.T, argsFile string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("compiler was not run: %s", err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// captureStdout returns what f prints to the standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+
+	f()
+	w.Close()
+
+	return <-done
+}
+
+// probeVersion runs [ProcessE] in dir as if the go command asked the compiler for its version
+// with -V=full, which it does to compute the cache key of a package, and returns the printed version.
+func probeVersion(t *testing.T, dir string, tool string, opts ...Option) string {
+	t.Helper()
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runCompile(t, dir, tool, []string{"-V=full"}, identity, opts...)
+	})
+	if err != nil {
+		t.Fatalf("probing the version in %s: %s", dir, err)
+	}
+	if !strings.Contains(out, "buildID=") {
+		t.Fatalf("probe printed %q, want a build ID", out)
+	}
+
+	return out
+}
+
+// compiledSources runs the compile of the files of dir with the modifier, and returns the sources
+// the compiler got by the base names of the files, without the line directives.
+func compiledSources(t *testing.T, dir string, files []string, modifier Modifier, opts ...Option) map[string]string {
+	t.Helper()
+
+	sources := make(map[string]string)
+	capture := WithBeforeCompile(func(args []string) error {
+		for _, arg := range args {
+			if isGoFile(arg) {
+				content, err := os.ReadFile(arg)
+				if err != nil {
+					return err
+				}
+				sources[filepath.Base(arg)] = lineDirectives.ReplaceAllString(string(content), "")
+			}
+		}
+		return nil
+	})
+
+	tool, _ := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack"}
+	for _, file := range files {
+		args = append(args, filepath.Join(dir, file))
+	}
+	if err := runCompile(t, dir, tool, args, modifier, append(opts, capture)...); err != nil {
+		t.Fatal(err)
+	}
+
+	return sources
+}
+
+// funcModifier applies the function to every function declaration of the file with its context.
+type funcModifier func(ctx *ModifyContext, decl *dst.FuncDecl)
+
+func (m funcModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (m funcModifier) ModifyWithContext(ctx *ModifyContext, f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok {
+			m(ctx, fn)
+		}
+	}
+
+	return f
+}
+
+// appendCall returns a modifier appending a call of the function of the package
+// to the body of the function with the given name.
+func appendCall(funcName string, pkgPath string, name string) Modifier {
+	return ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == funcName && fn.Body != nil {
+				fn.Body.List = append(fn.Body.List, &dst.ExprStmt{X: &dst.CallExpr{Fun: &dst.Ident{Path: pkgPath, Name: name}}})
+			}
+		}
+		return f
+	})
+}
+
+func TestProcessWorkspaceSiblingImport(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.work":       "go 1.22\n\nuse (\n\t./a\n\t./b\n)\n",
+		"b/go.mod":      "module example.com/b\n\ngo 1.22\n",
+		"b/lib/lib.go":  "package blib\n\nfunc Hello() {}\n",
+		"a/go.mod":      "module example.com/a\n\ngo 1.22\n",
+		"a/main.go":     "package main\n\nimport \"example.com/b/lib\"\n\nfunc main() { blib.Hello() }\n",
+		"a/other.go":    "package main\n\nfunc other() {}\n",
+		"cfg/importcfg": "packagefile example.com/b/lib=/nonexistent/lib.a\n",
+	})
+
+	tool, _ := fakeCompiler(t, 0)
+	var modified string
+	capture := WithBeforeCompile(func(args []string) error {
+		for _, arg := range args {
+			if filepath.Base(arg) == "other.go" {
+				content, err := os.ReadFile(arg)
+				modified = string(content)
+				return err
+			}
+		}
+		return nil
+	})
+
+	dir := filepath.Join(root, "a")
+	args := []string{"-p", "main", "-importcfg", filepath.Join(root, "cfg", "importcfg"), "-pack", filepath.Join(dir, "main.go"), filepath.Join(dir, "other.go")}
+	err := runCompile(t, dir, tool, args, appendCall("other", "example.com/b/lib", "Hello"), capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The name of the package differs from the last element of its path,
+	// so it is only known from loading the package across the workspace.
+	if !strings.Contains(modified, `"example.com/b/lib"`) || !strings.Contains(modified, "blib.Hello()") {
+		t.Errorf("modified file does not call the sibling module by its package name:\n%s", modified)
+	}
+}
+
+// identity is a modifier leaving the files as they are, which still makes them be
+// written to the tmp dir and compiled from there.
+var identity = ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+})
+
+// leftoverTmpDirs returns the names of the tmp dirs of the preprocessor in dir.
+func leftoverTmpDirs(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), goinject) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names
+}
+
+func TestProcessRemovesTmpDirOnCompileFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	var modifiedDir string
+	capture := WithBeforeCompile(func(args []string) error {
+		modifiedDir = filepath.Dir(args[len(args)-1])
+		return nil
+	})
+
+	tool, _ := fakeCompiler(t, 1)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+	err := runCompile(t, dir, tool, args, identity, capture)
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("got error %v, want the exit error of the compiler", err)
+	}
+	if !strings.HasPrefix(modifiedDir, tmp) {
+		t.Fatalf("modified file was compiled from %s, want it in the tmp dir %s", modifiedDir, tmp)
+	}
+
+	if left := leftoverTmpDirs(t, tmp); len(left) > 0 {
+		t.Errorf("tmp dirs %q were left behind after the compiler failed", left)
+	}
+}
+
+func TestProcessEUnresolvableImport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+	err := runCompile(t, dir, tool, args, appendCall("main", "example.invalid/missing", "Call"))
+	if err == nil {
+		t.Fatal("ProcessE succeeded, want an error for the unresolvable import")
+	}
+	if !strings.Contains(err.Error(), "example.invalid/missing") {
+		t.Errorf("error %q does not name the unresolvable import", err)
+	}
+	if _, statErr := os.Stat(argsFile); statErr == nil {
+		t.Error("compiler was run despite the unresolvable import")
+	}
+}
+
+// panickingPackageModifier panics on the files of the package.
+type panickingPackageModifier struct {
+	Modifier
+}
+
+func (panickingPackageModifier) ModifyPackage(files []*PackageFile) {
+	panic("modifier failed")
+}
+
+func TestProcessEModifierPanic(t *testing.T) {
+	panicking := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		panic("modifier failed")
+	})
+
+	tests := []struct {
+		name     string
+		modifier Modifier
+		opts     []Option
+	}{
+		{name: "file", modifier: panicking},
+		{name: "parallel", modifier: panicking, opts: []Option{WithParallelism(2)}},
+		{name: "package", modifier: panickingPackageModifier{Modifier: identity}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"other.go":  "package main\n\nfunc other() {}\n",
+				"importcfg": "",
+			})
+
+			tool, argsFile := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go"), filepath.Join(dir, "other.go")}
+			err := runCompile(t, dir, tool, args, tt.modifier, tt.opts...)
+
+			var panicErr *PanicError
+			if !errors.As(err, &panicErr) || panicErr.Value != "modifier failed" {
+				t.Fatalf("got error %v, want the panic of the modifier", err)
+			}
+			if !strings.Contains(string(panicErr.Stack), "goinject_test.go") {
+				t.Errorf("stack does not lead to the modifier:\n%s", panicErr.Stack)
+			}
+			if _, statErr := os.Stat(argsFile); statErr == nil {
+				t.Error("compiler was run despite the panic")
+			}
+		})
+	}
+}
+
+func TestProcessErrorModes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"a.go":      "package main\n\nfunc main() {}\n",
+		"b.go":      "package main\n\nfunc b() {}\n",
+		"importcfg": "",
+	})
+	aFile, bFile := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+
+	tests := []struct {
+		name string
+		opt  Option
+		want []string
+	}{
+		{name: "fail fast", opt: WithFailFast(), want: []string{aFile}},
+		{name: "collect errors", opt: WithCollectErrors(), want: []string{aFile, bFile}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, _ := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", aFile, bFile}
+			err := runCompile(t, dir, tool, args, failingModifier{err: errors.New("unsupported")}, tt.opt)
+			if err == nil {
+				t.Fatal("ProcessE succeeded, want the errors of the modifier")
+			}
+
+			var got []string
+			for _, line := range strings.Split(err.Error(), "\n") {
+				if path, ok := strings.CutSuffix(line, ": unsupported"); ok {
+					got = append(got, path)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got errors for %q, want for %q:\n%s", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestGoSourceArgs(t *testing.T) {
+	args := []string{
+		"-o", "/work/b001/_pkg_.a", "-trimpath", "/work/b001=>", "-p", "example.com/app",
+		"-lang=go1.22", "-complete", "-buildid", "abc/def", "-goversion", "go1.22.0",
+		"-asmhdr", "/work/b001/go_asm.h", "-importcfg", "/work/b001/importcfg", "-pack",
+		"/src/app/a.go", "/src/app/b.go", "/src/app/c_test.go",
+	}
+
+	files, indexes := goSourceArgs(args)
+
+	wantFiles := []string{"/src/app/a.go", "/src/app/b.go", "/src/app/c_test.go"}
+	wantIndexes := []int{17, 18, 19}
+	if !slices.Equal(files, wantFiles) || !slices.Equal(indexes, wantIndexes) {
+		t.Errorf("got files %q at %v, want %q at %v", files, indexes, wantFiles, wantIndexes)
+	}
+	for idx, file := range files {
+		if args[indexes[idx]] != file {
+			t.Errorf("index %d of %s points at %s", indexes[idx], file, args[indexes[idx]])
+		}
+	}
+}
+
+func TestProcessFlagValues(t *testing.T) {
+	tests := []struct {
+		name string
+		// args are the arguments of the compiler, with main.go and the header relative to the package.
+		args []string
+	}{
+		{
+			name: "asmhdr before the files",
+			args: []string{"-p", "main", "-asmhdr", "go_asm.h", "-symabis", "symabis", "-pack", "main.go"},
+		},
+		{
+			name: "asmhdr after the files",
+			args: []string{"-p", "main", "-pack", "main.go", "-asmhdr", "go_asm.h"},
+		},
+		{
+			name: "files between flags",
+			args: []string{"-symabis", "symabis", "main.go", "-asmhdr", "go_asm.h", "-p", "main"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"go_asm.h":  "",
+				"symabis":   "",
+				"importcfg": "",
+			})
+
+			args := []string{"-importcfg", filepath.Join(dir, "importcfg")}
+			for _, arg := range tt.args {
+				if !strings.HasPrefix(arg, "-") && arg != "main" {
+					arg = filepath.Join(dir, arg)
+				}
+				args = append(args, arg)
+			}
+
+			tool, argsFile := fakeCompiler(t, 0)
+			if err := runCompile(t, dir, tool, args, appendCall("main", "", "println")); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the Go file is replaced, everything else is passed in its original position.
+			compiled := compiledArgs(t, argsFile)
+			if len(compiled) != len(args) {
+				t.Fatalf("got compiler arguments %q, want the ones of %q", compiled, args)
+			}
+			for idx, arg := range args {
+				if filepath.Base(arg) == "main.go" {
+					if compiled[idx] == arg || filepath.Base(compiled[idx]) != "main.go" {
+						t.Errorf("got %s in place of %s, want a modified copy", compiled[idx], arg)
+					}
+					continue
+				}
+				if compiled[idx] != arg {
+					t.Errorf("got %s in place of %s, want it unchanged", compiled[idx], arg)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessKeepsFileOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n", "importcfg": ""}
+	var args []string
+	var want []string
+	for idx := range 20 {
+		name := fmt.Sprintf("file%02d.go", idx)
+		files[name] = fmt.Sprintf("package main\n\nfunc f%d() {}\n", idx)
+		args = append(args, filepath.Join(dir, name))
+		want = append(want, name)
+	}
+	writeFiles(t, dir, files)
+	args = append([]string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack"}, args...)
+
+	// The files started first finish last, so they are not done in the order of the arguments.
+	var started atomic.Int32
+	slow := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		time.Sleep(time.Duration(20-started.Add(1)) * time.Millisecond)
+		return f
+	})
+
+	for range 5 {
+		tool, argsFile := fakeCompiler(t, 0)
+		if err := runCompile(t, dir, tool, args, slow, WithParallelism(8)); err != nil {
+			t.Fatal(err)
+		}
+		started.Store(0)
+
+		var got []string
+		for _, arg := range compiledArgs(t, argsFile) {
+			if isGoFile(arg) {
+				got = append(got, filepath.Base(arg))
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("compiler got files in order %q, want %q", got, want)
+		}
+	}
+}
+
+func TestProcessFileFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/app\n\ngo 1.22\n",
+		"main.go":     "package main\n\nfunc main() {}\n",
+		"generate.go": "package main\n\n//go:generate echo\n\nfunc generate() {}\n",
+		"importcfg":   "",
+	})
+	mainFile, generateFile := filepath.Join(dir, "main.go"), filepath.Join(dir, "generate.go")
+
+	tests := []struct {
+		name   string
+		filter Option
+	}{
+		{
+			name:   "path",
+			filter: WithFileFilter(func(path string) bool { return path == generateFile }),
+		},
+		{
+			name: "file",
+			filter: WithFileFilter(func(path string, f *dst.File) bool {
+				return slices.ContainsFunc(f.Decls, func(decl dst.Decl) bool {
+					return slices.Contains(decl.Decorations().Start.All(), "//go:generate echo")
+				})
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, argsFile := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile, generateFile}
+			if err := runCompile(t, dir, tool, args, identity, tt.filter); err != nil {
+				t.Fatal(err)
+			}
+
+			compiled := compiledArgs(t, argsFile)
+			files := compiled[len(compiled)-2:]
+			if files[0] != mainFile {
+				t.Errorf("filtered out file was compiled from %s, want the original %s", files[0], mainFile)
+			}
+			if files[1] == generateFile || filepath.Base(files[1]) != "generate.go" {
+				t.Errorf("accepted file was compiled from %s, want a modified copy", files[1])
+			}
+		})
+	}
+}
+
+func TestProcessWithAssemblyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/app\n\ngo 1.22\n",
+		"main.go":     "package main\n\nfunc add(a, b int) int\n\nfunc main() { add(1, 2) }\n",
+		"add_amd64.s": "TEXT ·add(SB),$0-24\n\tRET\n",
+		"add_amd64.h": "#define SIZE 24\n",
+		"importcfg":   "",
+	})
+	mainFile, asmFile, headerFile := filepath.Join(dir, "main.go"), filepath.Join(dir, "add_amd64.s"), filepath.Join(dir, "add_amd64.h")
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile, asmFile, headerFile}
+	if err := runCompile(t, dir, tool, args, identity); err != nil {
+		t.Fatal(err)
+	}
+
+	compiled := compiledArgs(t, argsFile)
+	files := compiled[len(compiled)-3:]
+	if files[0] == mainFile || filepath.Base(files[0]) != "main.go" {
+		t.Errorf("Go file of the package with assembly was compiled from %s, want a modified copy", files[0])
+	}
+	if files[1] != asmFile || files[2] != headerFile {
+		t.Errorf("got non-Go files %q, want them passed through as %q", files[1:], []string{asmFile, headerFile})
+	}
+}
+
+func TestProcessPreservesNonGoArgs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"a.go":      "package main\n\nfunc main() {}\n",
+		"b.go":      "package main\n\nfunc b() {}\n",
+		"importcfg": "",
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{
+		"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack",
+		filepath.Join(dir, "a.go"), filepath.Join(dir, "rsrc.syso"), filepath.Join(dir, "b.go"), filepath.Join(dir, "trailing.o"),
+	}
+	if err := runCompile(t, dir, tool, args, identity); err != nil {
+		t.Fatal(err)
+	}
+
+	compiled := compiledArgs(t, argsFile)
+	if len(compiled) != len(args) {
+		t.Fatalf("got args %q, want as many as %q", compiled, args)
+	}
+	for idx, arg := range args {
+		if isGoFile(arg) {
+			if compiled[idx] == arg || filepath.Base(compiled[idx]) != filepath.Base(arg) {
+				t.Errorf("Go file %s was compiled from %s, want a modified copy in its place", arg, compiled[idx])
+			}
+		} else if compiled[idx] != arg {
+			t.Errorf("got arg %s at %d, want %s", compiled[idx], idx, arg)
+		}
+	}
+}
+
+func TestProcessSameBaseNames(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"a/util.go": "package main\n\nfunc a() {}\n",
+		"b/util.go": "package main\n\nfunc b() {}\n",
+		"importcfg": "",
+	})
+	aFile, bFile := filepath.Join(dir, "a", "util.go"), filepath.Join(dir, "b", "util.go")
+
+	// The modified files are read before the tmp dir is removed.
+	sources := make(map[string]string)
+	capture := WithBeforeCompile(func(args []string) error {
+		for _, arg := range args {
+			if isGoFile(arg) {
+				content, err := os.ReadFile(arg)
+				if err != nil {
+					return err
+				}
+				sources[arg] = string(content)
+			}
+		}
+		return nil
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", aFile, bFile}
+	if err := runCompile(t, dir, tool, args, identity, capture); err != nil {
+		t.Fatal(err)
+	}
+
+	compiled := compiledArgs(t, argsFile)
+	files := compiled[len(compiled)-2:]
+	if files[0] == files[1] {
+		t.Fatalf("both files were compiled from %s", files[0])
+	}
+	for idx, want := range []string{"func a()", "func b()"} {
+		if !strings.Contains(sources[files[idx]], want) {
+			t.Errorf("modified copy %s does not contain %q:\n%s", files[idx], want, sources[files[idx]])
+		}
+	}
+}
+
+func TestProcessTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/app\n\ngo 1.22\n",
+		"app.go":      "package app\n\nfunc App() {}\n",
+		"app_test.go": "package app\n\nfunc helper() {}\n",
+		"ext_test.go": "package app_test\n\nfunc extHelper() {}\n",
+		"importcfg":   "",
+	})
+
+	// go test compiles the package with its test files, and the external test package on its own.
+	units := []struct {
+		pkgPath string
+		files   []string
+	}{
+		{pkgPath: "example.com/app", files: []string{"app.go", "app_test.go"}},
+		{pkgPath: "example.com/app_test", files: []string{"ext_test.go"}},
+	}
+
+	tests := []struct {
+		name string
+		opt  Option
+		// modified are the files expected to be compiled from modified copies.
+		modified []string
+	}{
+		{name: "skip", opt: WithSkipTestFiles(), modified: []string{"app.go"}},
+		{name: "only", opt: WithOnlyTestFiles(), modified: []string{"app_test.go", "ext_test.go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modified []string
+			for _, unit := range units {
+				tool, argsFile := fakeCompiler(t, 0)
+				args := []string{"-p", unit.pkgPath, "-importcfg", filepath.Join(dir, "importcfg"), "-pack"}
+				for _, file := range unit.files {
+					args = append(args, filepath.Join(dir, file))
+				}
+				if err := runCompile(t, dir, tool, args, identity, tt.opt); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, arg := range compiledArgs(t, argsFile) {
+					if isGoFile(arg) && filepath.Dir(arg) != dir {
+						modified = append(modified, filepath.Base(arg))
+					}
+				}
+			}
+
+			if !slices.Equal(modified, tt.modified) {
+				t.Errorf("got modified files %q, want %q", modified, tt.modified)
+			}
+		})
+	}
+}
+
+// countingGo returns a go command counting its runs in the returned file before running the real one.
+func countingGo(t *testing.T) (goBinary string, runsFile string) {
+	t.Helper()
+
+	realGo, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	dir := t.TempDir()
+	goBinary = filepath.Join(dir, "go")
+	runsFile = filepath.Join(dir, "runs")
+	script := "#!/bin/sh\necho \"$1\" >> '" + runsFile + "'\nexec '" + realGo + "' \"$@\"\n"
+	if err := os.WriteFile(goBinary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return goBinary, runsFile
+}
+
+func TestProcessPassThroughWithoutGoCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+	importcfg := filepath.Join(dir, "importcfg")
+
+	tests := []struct {
+		name string
+		args []string
+		// wantGo reports whether the unit is expected to need the go command to locate the project.
+		wantGo bool
+	}{
+		{name: "std", args: []string{"-p", "fmt", "-std", "-importcfg", importcfg, "-pack", filepath.Join(dir, "main.go")}},
+		{name: "non-Go files", args: []string{"-p", "main", "-importcfg", importcfg, "-pack", filepath.Join(dir, "add_amd64.s")}},
+		{name: "project", args: []string{"-p", "main", "-importcfg", importcfg, "-pack", filepath.Join(dir, "main.go")}, wantGo: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goBinary, runsFile := countingGo(t)
+			tool, argsFile := fakeCompiler(t, 0)
+			if err := runCompile(t, dir, tool, tt.args, identity, WithGoBinary(goBinary)); err != nil {
+				t.Fatal(err)
+			}
+
+			runs, _ := os.ReadFile(runsFile)
+			if ran := len(runs) > 0; ran != tt.wantGo {
+				t.Errorf("go command ran %t with %q, want %t", ran, strings.Fields(string(runs)), tt.wantGo)
+			}
+			if _, err := os.Stat(argsFile); err != nil {
+				t.Error("compiler was not run")
+			}
+		})
+	}
+}
+
+func TestRelevantFiles(t *testing.T) {
+	roots := []string{"/src/app"}
+
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "assembly",
+			files: []string{"/src/app/main.go", "/src/app/add_amd64.s"},
+			want:  []string{"/src/app/main.go"},
+		},
+		{
+			name:  "outside of project",
+			files: []string{"/cache/lib/lib.go", "/cache/lib/lib_amd64.s"},
+			want:  nil,
+		},
+		{
+			// The files are selected by the directories of their packages.
+			name:  "several packages",
+			files: []string{"/src/app/lib/a.go", "/src/app/main.go", "/cache/lib/b.go", "/cache/lib/c.go"},
+			want:  []string{"/src/app/lib/a.go", "/src/app/main.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relevantFiles(tt.files, roots); !slices.Equal(got, tt.want) {
+				t.Errorf("got relevant files %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessWithoutImportcfg(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-pack", filepath.Join(dir, "main.go")}
+	if err := runCompile(t, dir, tool, args, appendCall("main", "fmt", "Println")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without importcfg the imports of the modified files could not be resolved,
+	// so the original files are compiled instead.
+	if got := compiledArgs(t, argsFile); !slices.Equal(got, args) {
+		t.Errorf("compiler got args %q, want the original ones %q", got, args)
+	}
+}
+
+func TestProcessContextAbortsBlockedRun(t *testing.T) {
+	tests := []struct {
+		name string
+		// block returns the modifier and the options of the run blocking until release is closed.
+		block func(release chan struct{}) (Modifier, []Option)
+	}{
+		{
+			name: "modifier",
+			block: func(release chan struct{}) (Modifier, []Option) {
+				return ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+					<-release
+					return f
+				}), nil
+			},
+		},
+		{
+			name: "resolver",
+			block: func(release chan struct{}) (Modifier, []Option) {
+				return appendCall("main", "example.com/lib", "Call"), []Option{WithPackageResolver(func(pkgName string) (map[string]string, error) {
+					<-release
+					return map[string]string{pkgName: "/cache/lib.a"}, nil
+				})}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			release := make(chan struct{})
+			modifier, opts := tt.block(release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			tool, argsFile := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+			err := runCompileContext(t, ctx, dir, tool, args, modifier, opts...)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got error %v, want the deadline of the context", err)
+			}
+
+			// The blocked run finishes after ProcessContext returned, and must not write
+			// to the tmp dir removed by then.
+			close(release)
+			time.Sleep(100 * time.Millisecond)
+
+			if left := leftoverTmpDirs(t, tmp); len(left) > 0 {
+				t.Errorf("tmp dirs %q were left behind by the aborted run", left)
+			}
+			if _, err := os.Stat(argsFile); err == nil {
+				t.Error("compiler was run despite the abort")
+			}
+		})
+	}
+}
+
+// rawStatement returns a modifier appending the statement, given as raw code, to every function body.
+func rawStatement(code string) Modifier {
+	return funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		decl.Body.List = append(decl.Body.List, &dst.ExprStmt{X: dst.NewIdent(code)})
+	})
+}
+
+func TestProcessFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		opts      []Option
+		// want is the modified file, with the leading line directive added after formatting.
+		want string
+		// warning is the start of the message logged for the file, empty if none is.
+		warning string
+	}{
+		{
+			name:      "unformatted",
+			statement: `println( "injected" )`,
+			want:      "/*line main.go:1:1*/package main\n\nfunc main() {\n\tprintln()\n\tprintln( \"injected\" )\n}\n",
+		},
+		{
+			name:      "formatted",
+			statement: `println( "injected" )`,
+			opts:      []Option{WithFormat()},
+			want:      "/*line main.go:1:1*/package main\n\nfunc main() {\n\tprintln()\n\tprintln(\"injected\")\n}\n",
+		},
+		{
+			name:      "invalid code is kept",
+			statement: `println( "injected"`,
+			opts:      []Option{WithFormat()},
+			want:      "/*line main.go:1:1*/package main\n\nfunc main() {\n\tprintln()\n\tprintln( \"injected\"\n}\n",
+			warning:   "Warning: failed formatting modified file ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {\n\tprintln()\n}\n",
+				"importcfg": "",
+			})
+
+			var logs strings.Builder
+			opts := append(tt.opts, WithLogger(log.New(&logs, "", 0)), WithBeforeCompile(func(args []string) error {
+				content, err := os.ReadFile(args[len(args)-1])
+				if err != nil {
+					return err
+				}
+				got := strings.ReplaceAll(string(content), filepath.Join(dir, "main.go"), "main.go")
+				if got != tt.want {
+					t.Errorf("got modified file:\n%s\nwant:\n%s", got, tt.want)
+				}
+				return nil
+			}))
+
+			tool, _ := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+			if err := runCompile(t, dir, tool, args, rawStatement(tt.statement), opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			warned := strings.Contains(logs.String(), "Warning:")
+			if !strings.Contains(logs.String(), tt.warning) || warned != (tt.warning != "") {
+				t.Errorf("got logs %q, want a warning %q", logs.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestProcessSkipGenerated(t *testing.T) {
+	const generated = "// Code generated by mockgen. DO NOT EDIT.\n\npackage main\n\nfunc mock() {}\n"
+
+	tests := []struct {
+		name string
+		src  string
+		opts []Option
+		// skipped reports whether the original file is expected to be compiled.
+		skipped bool
+	}{
+		{name: "generated", src: generated, opts: []Option{WithSkipGenerated()}, skipped: true},
+		{name: "not skipped", src: generated},
+		{
+			// The marker only counts before the package clause.
+			name: "marker after package clause",
+			src:  "package main\n\n// Code generated by mockgen. DO NOT EDIT.\n\nfunc mock() {}\n",
+			opts: []Option{WithSkipGenerated()},
+		},
+		{name: "handwritten", src: "package main\n\nfunc mock() {}\n", opts: []Option{WithSkipGenerated()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"mock.go":   tt.src,
+				"importcfg": "",
+			})
+
+			tool, argsFile := fakeCompiler(t, 0)
+			mainFile, mockFile := filepath.Join(dir, "main.go"), filepath.Join(dir, "mock.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile, mockFile}
+			if err := runCompile(t, dir, tool, args, identity, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			compiled := compiledArgs(t, argsFile)
+			if compiled[len(compiled)-2] == mainFile {
+				t.Error("handwritten main.go was compiled as is")
+			}
+			if skipped := compiled[len(compiled)-1] == mockFile; skipped != tt.skipped {
+				t.Errorf("mock.go compiled as is: %t, want %t", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestProcessCompileHooks(t *testing.T) {
+	errHook := errors.New("hook failed")
+
+	tests := []struct {
+		name     string
+		exitCode int
+		// beforeErr is the error returned by the before hook.
+		beforeErr error
+		// compiled reports whether the compiler and the after hook are expected to run.
+		compiled bool
+		wantErr  bool
+	}{
+		{name: "success", compiled: true},
+		{name: "compile failure", exitCode: 1, compiled: true, wantErr: true},
+		{name: "aborted", beforeErr: errHook, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			var before, after []string
+			var afterErr error
+			afterCalled := false
+			hooks := []Option{
+				WithBeforeCompile(func(args []string) error {
+					before = args
+					return tt.beforeErr
+				}),
+				WithAfterCompile(func(args []string, err error) {
+					after, afterErr, afterCalled = args, err, true
+				}),
+			}
+
+			tool, argsFile := fakeCompiler(t, tt.exitCode)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			err := runCompile(t, dir, tool, args, identity, hooks...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tt.wantErr)
+			}
+			if tt.beforeErr != nil && !errors.Is(err, tt.beforeErr) {
+				t.Errorf("got error %v, want the error of the hook", err)
+			}
+
+			// The hooks get the tool followed by its arguments, with the modified file substituted.
+			if len(before) != len(args)+1 || before[0] != tool || before[len(before)-1] == mainFile || filepath.Base(before[len(before)-1]) != "main.go" {
+				t.Errorf("before hook got %q, want the compiler command line with the modified file", before)
+			}
+
+			if afterCalled != tt.compiled {
+				t.Fatalf("after hook called: %t, want %t", afterCalled, tt.compiled)
+			}
+			if _, statErr := os.Stat(argsFile); (statErr == nil) != tt.compiled {
+				t.Errorf("compiler run: %t, want %t", statErr == nil, tt.compiled)
+			}
+			if !tt.compiled {
+				return
+			}
+			if !slices.Equal(after, before) {
+				t.Errorf("after hook got %q, want %q", after, before)
+			}
+			if (afterErr != nil) != (tt.exitCode != 0) {
+				t.Errorf("after hook got error %v for compiler exit code %d", afterErr, tt.exitCode)
+			}
+		})
+	}
+}
+
+// runProgram runs the main package with the source, and returns its combined output.
+func runProgram(t *testing.T, src string) string {
+	t.Helper()
+
+	return runPackage(t, map[string]string{"main.go": src})
+}
+
+// runPackage runs the main package with the files, and returns its combined output.
+func runPackage(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+	if testing.Short() {
+		t.Skip("building the program in short mode")
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"})
+	writeFiles(t, dir, files)
+
+	cmd := exec.Command(goBinary, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("running the program: %s\n%s\n%s", err, out, files["main.go"])
+	}
+
+	return string(out)
+}
+
+func TestProcessStdlib(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"importcfg": "",
+	})
+
+	// The errors package of the std library is compiled by cmd/go from GOROOT with -std.
+	stdFiles, err := filepath.Glob(filepath.Join(runtime.GOROOT(), "src", "errors", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdFiles = slices.DeleteFunc(stdFiles, func(file string) bool { return strings.HasSuffix(file, "_test.go") })
+	if len(stdFiles) == 0 {
+		t.Skip("the sources of the std library are not available")
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		// modified reports whether the files of the std library are expected to be seen by the modifier.
+		modified bool
+	}{
+		{name: "default"},
+		{name: "process stdlib", opts: []Option{WithProcessStdlib()}, modified: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen atomic.Int32
+			modifier := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+				seen.Add(1)
+				return f
+			})
+
+			var logs strings.Builder
+			tool, _ := fakeCompiler(t, 0)
+			args := append([]string{"-p", "errors", "-std", "-importcfg", filepath.Join(dir, "importcfg"), "-pack"}, stdFiles...)
+			if err := runCompile(t, dir, tool, args, modifier, append(tt.opts, WithLogger(log.New(&logs, "", 0)))...); err != nil {
+				t.Fatal(err)
+			}
+
+			if modified := seen.Load() > 0; modified != tt.modified {
+				t.Errorf("modifier saw %d std library files, want modified: %t", seen.Load(), tt.modified)
+			}
+			if warned := strings.Contains(logs.String(), "Warning: modifying std library package errors"); warned != tt.modified {
+				t.Errorf("got logs %q, want the modification of the std library reported: %t", logs.String(), tt.modified)
+			}
+		})
+	}
+}
+
+func TestProcessPackagePath(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		// The name of the package differs from the last element of its path.
+		"lib/lib.go":     "package blib\n\nfunc Hello() {}\n",
+		"other/other.go": "package other\n\nfunc Other() {}\n",
+		"cmd/server.go":  "package server\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n\n\t\"example.com/app/lib\"\n\t\"example.com/app/other\"\n)\n\nfunc Serve() {\n\tfmt.Println(strings.ToUpper(\"\"))\n\tblib.Hello()\n\tother.Other()\n}\n",
+		"importcfg":      "",
+	})
+
+	var pkgPaths []string
+	modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		pkgPaths = append(pkgPaths, ctx.PackagePath)
+		decl.Body.List = append(decl.Body.List, &dst.ExprStmt{X: Call("example.com/app/lib", "Hello")})
+	})
+
+	var modified string
+	capture := WithBeforeCompile(func(args []string) error {
+		content, err := os.ReadFile(args[len(args)-1])
+		modified = string(content)
+		return err
+	})
+
+	tool, _ := fakeCompiler(t, 0)
+	args := []string{"-p", "example.com/app/cmd", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "cmd", "server.go")}
+	if err := runCompile(t, dir, tool, args, modifier, capture); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(pkgPaths, []string{"example.com/app/cmd"}) {
+		t.Errorf("got package paths %q, want the compile target", pkgPaths)
+	}
+	// The name of the imported package is resolved, rather than guessed from its path.
+	if !strings.Contains(modified, "\tother.Other()\n\tblib.Hello()\n}") || strings.Contains(modified, `blib "example.com/app/lib"`) {
+		t.Errorf("injected call does not use the name of the imported package:\n%s", modified)
+	}
+}
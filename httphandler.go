@@ -0,0 +1,55 @@
+package goinject
+
+import (
+	"go/types"
+
+	"github.com/dave/dst"
+)
+
+// IsHTTPHandler reports whether the node is a function that can serve HTTP requests,
+// i.e. whether its type is [net/http.HandlerFunc] or a function with the
+// `func(http.ResponseWriter, *http.Request)` signature.
+//
+// The node may be a function or method declaration, a function literal, or any
+// expression denoting a function, such as a method value `s.handle` or a conversion
+// `http.HandlerFunc(...)`. The check relies on the type information of the package,
+// see [ModifyContext.TypesInfo], so handlers are recognized regardless of how
+// the net/http package or its types are named in the file.
+func IsHTTPHandler(ctx *ModifyContext, node dst.Node) bool {
+	return isHTTPHandlerType(ctx.TypeOf(node))
+}
+
+func isHTTPHandlerType(typ types.Type) bool {
+	if typ == nil {
+		return false
+	}
+
+	if isNetHTTPType(typ, "HandlerFunc") {
+		return true
+	}
+
+	sig, ok := typ.Underlying().(*types.Signature)
+	if !ok || sig.Variadic() || sig.Results().Len() != 0 || sig.Params().Len() != 2 {
+		return false
+	}
+
+	if !isNetHTTPType(sig.Params().At(0).Type(), "ResponseWriter") {
+		return false
+	}
+
+	ptr, ok := sig.Params().At(1).Type().(*types.Pointer)
+
+	return ok && isNetHTTPType(ptr.Elem(), "Request")
+}
+
+// isNetHTTPType reports whether typ is the named type of the net/http package.
+func isNetHTTPType(typ types.Type, name string) bool {
+	named, ok := types.Unalias(typ).(*types.Named)
+	if !ok {
+		return false
+	}
+
+	obj := named.Obj()
+
+	return obj.Pkg() != nil && obj.Pkg().Path() == "net/http" && obj.Name() == name
+}
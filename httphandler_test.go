@@ -0,0 +1,76 @@
+package goinject
+
+import (
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestIsHTTPHandler(t *testing.T) {
+	src := `package main
+
+import (
+	"net/http"
+	web "net/http"
+)
+
+type server struct{}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {}
+
+func handler(w web.ResponseWriter, r *web.Request) {}
+
+func byValue(w http.ResponseWriter, r http.Request) {}
+
+func untyped(w any, r *http.Request) {}
+
+func withResult(w http.ResponseWriter, r *http.Request) error { return nil }
+
+func main() {
+	s := &server{}
+	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	_ = s.handle
+}
+`
+	config, unit := testUnit(t, map[string]string{"main.go": src})
+	unit.importcfg = exportImportcfg(t, "net/http")
+
+	got := make(map[string]bool)
+	modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		got[decl.Name.Name] = IsHTTPHandler(ctx, decl)
+
+		dst.Inspect(decl.Body, func(node dst.Node) bool {
+			switch node := node.(type) {
+			case *dst.SelectorExpr:
+				if node.Sel.Name == "handle" {
+					got["method value"] = IsHTTPHandler(ctx, node)
+				}
+			case *dst.CallExpr:
+				// The decorator resolves the qualified identifiers to the ones with the path of the package.
+				if ident, ok := node.Fun.(*dst.Ident); ok && ident.Path == "net/http" && ident.Name == "HandlerFunc" {
+					got["conversion"] = IsHTTPHandler(ctx, node)
+					got["closure"] = IsHTTPHandler(ctx, node.Args[0])
+				}
+			}
+			return true
+		})
+	})
+	modifiedFile(t, config, unit, unit.goFiles[0], modifier)
+
+	want := map[string]bool{
+		"handle":       true,
+		"handler":      true,
+		"byValue":      false,
+		"untyped":      false,
+		"withResult":   false,
+		"main":         false,
+		"method value": true,
+		"conversion":   true,
+		"closure":      true,
+	}
+	for name, wantHandler := range want {
+		if isHandler, ok := got[name]; !ok || isHandler != wantHandler {
+			t.Errorf("%s: got handler %t (checked %t), want %t", name, isHandler, ok, wantHandler)
+		}
+	}
+}
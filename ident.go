@@ -0,0 +1,48 @@
+package goinject
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// DefaultIdentPrefix is the prefix of identifiers injected by the helpers of this package,
+// unless another one is configured with [WithIdentPrefix].
+const DefaultIdentPrefix = "__goinject_"
+
+// checkIdentPrefix returns an error if any identifier of the file handed to the modifier
+// already starts with the prefix, since injected identifiers could then shadow or redeclare it.
+// The files compiled as is are not checked, as nothing is injected into them, and neither are
+// the files stamped with [MarkModified], which an earlier preprocessor already injected into.
+func (u *compileUnit) checkIdentPrefix(path string) error {
+	prefix := u.identPrefix
+	if prefix == "" {
+		return nil
+	}
+
+	f, err := u.parse(path)
+	if err != nil {
+		return err
+	}
+
+	if hasModifiedMarker(f) {
+		return nil
+	}
+
+	var collision *ast.Ident
+	ast.Inspect(f, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if ok && collision == nil && strings.HasPrefix(ident.Name, prefix) {
+			collision = ident
+		}
+
+		return collision == nil
+	})
+
+	if collision != nil {
+		return fmt.Errorf("%s: identifier %s uses the prefix %q reserved for injected identifiers, configure another one with WithIdentPrefix",
+			u.fset.Position(collision.Pos()), collision.Name, prefix)
+	}
+
+	return nil
+}
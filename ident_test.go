@@ -0,0 +1,52 @@
+package goinject
+
+import (
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckIdentPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name: "no prefix",
+			src:  "package main\n\nfunc main() { value := 1; _ = value }\n",
+		},
+		{
+			name:    "prefix",
+			src:     "package main\n\nfunc main() { __goinject_value := 1; _ = __goinject_value }\n",
+			wantErr: "main.go:3:15: identifier __goinject_value uses the prefix",
+		},
+		{
+			// The output of an earlier preprocessor carries the identifiers it injected.
+			name: "marked modified",
+			src:  "//goinject:modified tracing\n\npackage main\n\nfunc main() { __goinject_value := 1; _ = __goinject_value }\n",
+		},
+		{
+			name:    "marker after package clause",
+			src:     "package main\n\n//goinject:modified tracing\nfunc main() { __goinject_value := 1; _ = __goinject_value }\n",
+			wantErr: "identifier __goinject_value uses the prefix",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.go": tt.src})
+			unit := &compileUnit{identPrefix: DefaultIdentPrefix, fset: token.NewFileSet()}
+
+			err := unit.checkIdentPrefix(filepath.Join(dir, "main.go"))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
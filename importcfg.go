@@ -0,0 +1,242 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ImportcfgEntry is a `packagefile` line of an importcfg file,
+// mapping the import path of a package to its compiled archive.
+type ImportcfgEntry struct {
+	// Name is the import path of the package.
+	Name string
+	// Path is the path to the compiled archive of the package.
+	Path string
+}
+
+var (
+	importcfgAdditionsMu sync.Mutex
+	importcfgAdditions   []ImportcfgEntry
+	// linkDeps are the archives of the packages added to importcfg and of their dependencies
+	// by the import paths, which the linker needs along with the compiled package, see [writeLinkDeps].
+	linkDeps map[string]string
+)
+
+// LastImportcfgAdditions returns the entries added to the importcfg file by the last call
+// of [ProcessE], in the order they were added. These are the packages imported by the
+// modified code that the package did not import before, and their dependencies added for linking.
+//
+// It answers whether a package injected by the modifier was resolved, and to which archive,
+// without inspecting the importcfg file of the build.
+func LastImportcfgAdditions() []ImportcfgEntry {
+	importcfgAdditionsMu.Lock()
+	defer importcfgAdditionsMu.Unlock()
+
+	return slices.Clone(importcfgAdditions)
+}
+
+// recordImportcfgAddition records the entry for [LastImportcfgAdditions].
+func recordImportcfgAddition(entry ImportcfgEntry) {
+	importcfgAdditionsMu.Lock()
+	defer importcfgAdditionsMu.Unlock()
+
+	importcfgAdditions = append(importcfgAdditions, entry)
+}
+
+// recordLinkDeps records the archives of an added package and its dependencies for [writeLinkDeps].
+func recordLinkDeps(pkgs map[string]string) {
+	importcfgAdditionsMu.Lock()
+	defer importcfgAdditionsMu.Unlock()
+
+	if linkDeps == nil {
+		linkDeps = make(map[string]string)
+	}
+	maps.Copy(linkDeps, pkgs)
+}
+
+// collectedLinkDeps returns the archives recorded with [recordLinkDeps].
+func collectedLinkDeps() map[string]string {
+	importcfgAdditionsMu.Lock()
+	defer importcfgAdditionsMu.Unlock()
+
+	return maps.Clone(linkDeps)
+}
+
+// resetImportcfgAdditions forgets the entries recorded by the previous call of [ProcessE].
+func resetImportcfgAdditions() {
+	importcfgAdditionsMu.Lock()
+	defer importcfgAdditionsMu.Unlock()
+
+	importcfgAdditions = nil
+	linkDeps = nil
+}
+
+// importcfgMu serializes the patching of importcfg files within the process.
+var importcfgMu sync.Mutex
+
+// addImportcfgEntries adds the entries missing from the importcfg file at path,
+// along with the importmap lines of the mappings of import paths to the paths of listed packages.
+//
+// The file is patched atomically: it is read as a whole, the entries it does not list yet
+// are appended to its content, and the result replaces the file at once. The patching is
+// serialized within the process and, where supported, with a lock on the directory of the file,
+// so concurrent patches of the same file can neither tear lines nor add an entry twice.
+func addImportcfgEntries(path string, entries []ImportcfgEntry, mappings map[string]string) error {
+	importcfgMu.Lock()
+	defer importcfgMu.Unlock()
+
+	unlock, err := lockDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("locking importcfg: %w", err)
+	}
+	defer unlock()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading importcfg: %w", err)
+	}
+
+	listed, importMap := parseImportcfg(content)
+
+	var added []ImportcfgEntry
+	var patch bytes.Buffer
+	patch.Write(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		patch.WriteByte('\n')
+	}
+	for _, entry := range entries {
+		if _, ok := listed[entry.Name]; ok {
+			continue
+		}
+		listed[entry.Name] = entry.Path
+
+		fmt.Fprintf(&patch, "packagefile %s=%s\n", entry.Name, entry.Path)
+		added = append(added, entry)
+	}
+
+	// The mappings are written in a stable order, so the patched importcfg does not vary between builds.
+	names := make([]string, 0, len(mappings))
+	for name := range mappings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var mapped bool
+	for _, name := range names {
+		if _, ok := importMap[name]; ok {
+			continue
+		}
+		if _, ok := listed[name]; ok {
+			continue
+		}
+		importMap[name] = mappings[name]
+
+		fmt.Fprintf(&patch, "importmap %s=%s\n", name, mappings[name])
+		mapped = true
+	}
+
+	if len(added) == 0 && !mapped {
+		return nil
+	}
+
+	if err := replaceFile(path, patch.Bytes()); err != nil {
+		return fmt.Errorf("writing importcfg: %w", err)
+	}
+
+	for _, entry := range added {
+		recordImportcfgAddition(entry)
+	}
+
+	return nil
+}
+
+// replaceFile replaces the file at path with the content at once, by renaming
+// a temporary file written next to it, so readers never see a partial file.
+func replaceFile(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// rewriteImportcfg replaces the content of the importcfg file with the lines returned by rewrite,
+// see [WithImportcfgRewriter]. Like the additions, the rewrite is serialized and atomic.
+func rewriteImportcfg(path string, rewrite func(lines []string) []string) error {
+	importcfgMu.Lock()
+	defer importcfgMu.Unlock()
+
+	unlock, err := lockDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("locking importcfg: %w", err)
+	}
+	defer unlock()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading importcfg: %w", err)
+	}
+
+	var lines []string
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	var rewritten bytes.Buffer
+	for _, line := range rewrite(lines) {
+		rewritten.WriteString(line)
+		rewritten.WriteByte('\n')
+	}
+
+	if bytes.Equal(rewritten.Bytes(), content) {
+		return nil
+	}
+
+	if err := replaceFile(path, rewritten.Bytes()); err != nil {
+		return fmt.Errorf("writing importcfg: %w", err)
+	}
+
+	return nil
+}
+
+// parseImportcfg parses the packagefile and importmap directives of the importcfg content.
+//
+// The directives are keyed by the exact import paths, so a package is never taken
+// for another one whose path contains it, like io for io/ioutil.
+func parseImportcfg(content []byte) (packageFiles map[string]string, importMap map[string]string) {
+	packageFiles = make(map[string]string)
+	importMap = make(map[string]string)
+
+	for _, line := range strings.Split(string(content), "\n") {
+		verb, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		key, value, found := strings.Cut(args, "=")
+		if !found {
+			continue
+		}
+
+		switch verb {
+		case "packagefile":
+			packageFiles[key] = value
+		case "importmap":
+			importMap[key] = value
+		}
+	}
+
+	return packageFiles, importMap
+}
@@ -0,0 +1,362 @@
+package goinject
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestAddImportcfgEntriesConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "importcfg")
+	if err := os.WriteFile(path, []byte("packagefile fmt=/cache/fmt.a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every patch shares half of its packages with the next one, and fmt with all of them.
+	const patches = 16
+	var wg sync.WaitGroup
+	errs := make([]error, patches)
+	for idx := range patches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entries := []ImportcfgEntry{{Name: "fmt", Path: "/cache/fmt.a"}}
+			for pkg := idx; pkg < idx+4; pkg++ {
+				entries = append(entries, ImportcfgEntry{Name: fmt.Sprintf("example.com/pkg%d", pkg), Path: fmt.Sprintf("/cache/pkg%d.a", pkg)})
+			}
+			errs[idx] = addImportcfgEntries(path, entries, nil)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		name, archive, ok := strings.Cut(strings.TrimPrefix(line, "packagefile "), "=")
+		if !ok || !strings.HasPrefix(line, "packagefile ") || !strings.HasSuffix(archive, ".a") {
+			t.Errorf("torn line %q", line)
+			continue
+		}
+		if seen[name] {
+			t.Errorf("duplicate entry for %s", name)
+		}
+		seen[name] = true
+	}
+
+	if want := 1 + patches + 3; len(seen) != want {
+		t.Errorf("got %d packages, want %d:\n%s", len(seen), want, content)
+	}
+}
+
+func TestAddMissingPkgsExactPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		listed   string
+		imports  []string
+		resolved []string
+	}{
+		{name: "io with io/ioutil listed", listed: "packagefile io/ioutil=/cache/ioutil.a\n", imports: []string{"io"}, resolved: []string{"io"}},
+		{name: "io/ioutil with io listed", listed: "packagefile io=/cache/io.a\n", imports: []string{"io/ioutil"}, resolved: []string{"io/ioutil"}},
+		{name: "listed", listed: "packagefile io=/cache/io.a\npackagefile io/ioutil=/cache/ioutil.a\n", imports: []string{"io", "io/ioutil"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(resetImportcfgAdditions)
+
+			path := filepath.Join(t.TempDir(), "importcfg")
+			if err := os.WriteFile(path, []byte(tt.listed), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var resolved []string
+			config := &config{logger: noopLogger{}, resolver: func(pkgName string) (map[string]string, error) {
+				resolved = append(resolved, pkgName)
+				return map[string]string{pkgName: "/cache/" + filepath.Base(pkgName) + ".a"}, nil
+			}}
+
+			var imports []*ast.ImportSpec
+			for _, pkg := range tt.imports {
+				imports = append(imports, &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(pkg)}})
+			}
+			if err := addMissingPkgs(config, "main", path, imports); err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(resolved, tt.resolved) {
+				t.Errorf("resolved %q, want %q", resolved, tt.resolved)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed, _ := parseImportcfg(content)
+			if len(listed) != 2 || listed["io"] != "/cache/io.a" || listed["io/ioutil"] != "/cache/ioutil.a" {
+				t.Errorf("want io and io/ioutil listed once each:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestParseImportcfg(t *testing.T) {
+	content := "# import config\n" +
+		"packagefile github.com/a/bb=/cache/bb.a\n" +
+		"packagefile vendor/github.com/a/b=/cache/b.a\n" +
+		"importmap github.com/a/b=vendor/github.com/a/b\n" +
+		"packagefile github.com/a/b/c=/cache/c.a\n"
+
+	packageFiles, importMap := parseImportcfg([]byte(content))
+
+	wantFiles := map[string]string{
+		"github.com/a/bb":       "/cache/bb.a",
+		"vendor/github.com/a/b": "/cache/b.a",
+		"github.com/a/b/c":      "/cache/c.a",
+	}
+	wantMap := map[string]string{"github.com/a/b": "vendor/github.com/a/b"}
+	if !maps.Equal(packageFiles, wantFiles) {
+		t.Errorf("got package files %v, want %v", packageFiles, wantFiles)
+	}
+	if !maps.Equal(importMap, wantMap) {
+		t.Errorf("got import map %v, want %v", importMap, wantMap)
+	}
+}
+
+func TestAddImportcfgEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entries  []ImportcfgEntry
+		mappings map[string]string
+		want     string
+	}{
+		{
+			name:    "longer path listed",
+			content: "packagefile github.com/a/bb=/cache/bb.a\n",
+			entries: []ImportcfgEntry{{Name: "github.com/a/b", Path: "/cache/b.a"}},
+			want:    "packagefile github.com/a/bb=/cache/bb.a\npackagefile github.com/a/b=/cache/b.a\n",
+		},
+		{
+			name:    "shorter path listed",
+			content: "packagefile github.com/a/b=/cache/b.a\n",
+			entries: []ImportcfgEntry{{Name: "github.com/a/bb", Path: "/cache/bb.a"}},
+			want:    "packagefile github.com/a/b=/cache/b.a\npackagefile github.com/a/bb=/cache/bb.a\n",
+		},
+		{
+			name:    "same path listed",
+			content: "packagefile github.com/a/b=/cache/b.a\n",
+			entries: []ImportcfgEntry{{Name: "github.com/a/b", Path: "/other/b.a"}},
+			want:    "packagefile github.com/a/b=/cache/b.a\n",
+		},
+		{
+			name:    "no trailing newline",
+			content: "packagefile github.com/a/b=/cache/b.a",
+			entries: []ImportcfgEntry{{Name: "github.com/a/bb", Path: "/cache/bb.a"}},
+			want:    "packagefile github.com/a/b=/cache/b.a\npackagefile github.com/a/bb=/cache/bb.a\n",
+		},
+		{
+			name:     "mapped path listed",
+			content:  "packagefile vendor/github.com/a/b=/cache/b.a\nimportmap github.com/a/b=vendor/github.com/a/b\n",
+			mappings: map[string]string{"github.com/a/b": "vendor/github.com/a/b"},
+			want:     "packagefile vendor/github.com/a/b=/cache/b.a\nimportmap github.com/a/b=vendor/github.com/a/b\n",
+		},
+		{
+			name:     "mapping of longer path",
+			content:  "packagefile vendor/github.com/a/b=/cache/b.a\nimportmap github.com/a/b=vendor/github.com/a/b\n",
+			mappings: map[string]string{"github.com/a/bb": "vendor/github.com/a/b"},
+			want: "packagefile vendor/github.com/a/b=/cache/b.a\nimportmap github.com/a/b=vendor/github.com/a/b\n" +
+				"importmap github.com/a/bb=vendor/github.com/a/b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(resetImportcfgAdditions)
+
+			path := filepath.Join(t.TempDir(), "importcfg")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := addImportcfgEntries(path, tt.entries, tt.mappings); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("got importcfg:\n%s\nwant:\n%s", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessPackageResolver(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "packagefile fmt=/cache/fmt.a\n",
+	})
+	// The dependencies of the package are recorded for the link for the rest of the process.
+	t.Cleanup(resetImportcfgAdditions)
+
+	var resolved []string
+	resolver := func(pkgName string) (map[string]string, error) {
+		resolved = append(resolved, pkgName)
+		// The fake archives are only known to the resolver, without the go toolchain.
+		return map[string]string{"example.com/fake": "/fake/fake.a", "example.com/fake/dep": "/fake/dep.a"}, nil
+	}
+
+	tool, _ := fakeCompiler(t, 0)
+	importcfg := filepath.Join(dir, "importcfg")
+	args := []string{"-p", "main", "-importcfg", importcfg, "-pack", filepath.Join(dir, "main.go")}
+	if err := runCompile(t, dir, tool, args, appendCall("main", "example.com/fake", "Call"), WithPackageResolver(resolver)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(resolved, []string{"example.com/fake"}) {
+		t.Errorf("resolver was called for %q, want the injected package only", resolved)
+	}
+	content, err := os.ReadFile(importcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The compiler only reads the export data of the imported package, and the linker gets the dependencies.
+	if want := "packagefile fmt=/cache/fmt.a\npackagefile example.com/fake=/fake/fake.a\n"; string(content) != want {
+		t.Errorf("got importcfg:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestLastImportcfgAdditions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n",
+		"importcfg": "packagefile fmt=/cache/fmt.a\n",
+	})
+	t.Cleanup(resetImportcfgAdditions)
+
+	resolver := func(pkgName string) (map[string]string, error) {
+		return map[string]string{pkgName: "/fake/" + filepath.Base(pkgName) + ".a", "example.com/dep": "/fake/dep.a"}, nil
+	}
+	// The listed fmt is not added, while both of the injected packages are.
+	modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		for _, pkg := range []string{"fmt", "example.com/trace", "example.com/metrics"} {
+			decl.Body.List = append(decl.Body.List, &dst.ExprStmt{X: &dst.CallExpr{Fun: &dst.Ident{Path: pkg, Name: "Call"}}})
+		}
+	})
+
+	tool, _ := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+	if err := runCompile(t, dir, tool, args, modifier, WithPackageResolver(resolver)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := LastImportcfgAdditions()
+	slices.SortFunc(got, func(a, b ImportcfgEntry) int { return strings.Compare(a.Name, b.Name) })
+	want := []ImportcfgEntry{
+		{Name: "example.com/metrics", Path: "/fake/metrics.a"},
+		{Name: "example.com/trace", Path: "/fake/trace.a"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got additions %v, want %v", got, want)
+	}
+}
+
+func TestProcessImportcfgRewriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite func(lines []string) []string
+		want    string
+	}{
+		{
+			name: "redirect",
+			rewrite: func(lines []string) []string {
+				for idx, line := range lines {
+					lines[idx] = strings.Replace(line, "=/fake/fake.a", "=/subst/fake.a", 1)
+				}
+				return lines
+			},
+			want: "packagefile fmt=/cache/fmt.a\npackagefile example.com/fake=/subst/fake.a\n",
+		},
+		{
+			name: "remove",
+			rewrite: func(lines []string) []string {
+				return slices.DeleteFunc(lines, func(line string) bool { return strings.HasPrefix(line, "packagefile fmt=") })
+			},
+			want: "packagefile example.com/fake=/fake/fake.a\n",
+		},
+		{
+			name: "importmap",
+			rewrite: func(lines []string) []string {
+				return append(lines, "importmap example.com/old=example.com/fake")
+			},
+			want: "packagefile fmt=/cache/fmt.a\npackagefile example.com/fake=/fake/fake.a\nimportmap example.com/old=example.com/fake\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(resetImportcfgAdditions)
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "packagefile fmt=/cache/fmt.a\n",
+			})
+			resolver := func(pkgName string) (map[string]string, error) {
+				return map[string]string{"example.com/fake": "/fake/fake.a"}, nil
+			}
+
+			// The rewriter gets the packages added for the modifications too.
+			var got []string
+			rewriter := func(lines []string) []string {
+				got = slices.Clone(lines)
+				return tt.rewrite(lines)
+			}
+
+			tool, _ := fakeCompiler(t, 0)
+			importcfg := filepath.Join(dir, "importcfg")
+			args := []string{"-p", "main", "-importcfg", importcfg, "-pack", filepath.Join(dir, "main.go")}
+			modifier := appendCall("main", "example.com/fake", "Call")
+			if err := runCompile(t, dir, tool, args, modifier, WithPackageResolver(resolver), WithImportcfgRewriter(rewriter)); err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []string{"packagefile fmt=/cache/fmt.a", "packagefile example.com/fake=/fake/fake.a"}; !slices.Equal(got, want) {
+				t.Errorf("rewriter got lines %q, want %q", got, want)
+			}
+			content, err := os.ReadFile(importcfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("got importcfg:\n%s\nwant:\n%s", content, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package goinject
+
+import (
+	"go/ast"
+	"go/token"
+	"slices"
+	"strconv"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+	"github.com/dave/dst/dstutil"
+)
+
+// hasDotImport reports whether the file has a dot import.
+func hasDotImport(f *ast.File) bool {
+	for _, spec := range f.Imports {
+		if spec.Name != nil && spec.Name.Name == "." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveImportRefs rewrites references to imported packages added by the modifier
+// in the `name.Sel` form into identifiers carrying the import path, the form the decorator
+// produces for the original code.
+//
+// The restorer keeps only those imports that are referenced by such identifiers,
+// so without the rewrite an import spec added along with the `name.Sel` code
+// using it, e.g. `import f "fmt"` and `f.Println()`, would be dropped from the file.
+// Identifiers imported with a dot import can not be told apart from local ones,
+// so modifiers must refer to them as [dst.Ident] with the Path set.
+func resolveImportRefs(f *dst.File, dec *decorator.Decorator, res resolver.RestorerResolver) {
+	names := make(map[string]string)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path == "C" {
+			continue
+		}
+
+		name := ""
+		if spec.Name != nil {
+			name = spec.Name.Name
+		} else if resolved, err := res.ResolvePackage(path); err == nil {
+			name = resolved
+		}
+
+		if name == "" || name == "_" || name == "." {
+			continue
+		}
+		names[name] = path
+	}
+
+	if len(names) == 0 {
+		return
+	}
+
+	dstutil.Apply(f, func(cursor *dstutil.Cursor) bool {
+		sel, ok := cursor.Node().(*dst.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		// Selectors of the original code that are left after decoration
+		// refer to local variables shadowing the package name.
+		if _, original := dec.Ast.Nodes[sel]; original {
+			return true
+		}
+
+		x, ok := sel.X.(*dst.Ident)
+		if !ok || x.Path != "" {
+			return true
+		}
+
+		path, ok := names[x.Name]
+		if !ok {
+			return true
+		}
+
+		ident := &dst.Ident{Path: path, Name: sel.Sel.Name}
+		ident.Decs.NodeDecs = sel.Decs.NodeDecs
+		cursor.Replace(ident)
+
+		return true
+	}, nil)
+}
+
+// ImportDeclarer is an optional interface of a [Modifier] reporting the imports its modifications
+// require. It spares rereading every modified file to find out which packages must be added
+// to importcfg. The imports must cover every package the injected code refers to, including
+// the ones referred to by the helpers of this package, otherwise the compilation fails.
+//
+// The imports are assumed to be required by every file the modifier is applied to.
+// Listing a package that a file does not actually import only costs its resolution.
+type ImportDeclarer interface {
+	RequiredImports() []*dst.ImportSpec
+}
+
+// declaredImports returns the imports of the original file along with the ones
+// declared by the modifier, in the form of the imports of a parsed file.
+func declaredImports(astFile *ast.File, declarer ImportDeclarer) []*ast.ImportSpec {
+	imports := slices.Clone(astFile.Imports)
+	for _, spec := range declarer.RequiredImports() {
+		imports = append(imports, &ast.ImportSpec{
+			Path: &ast.BasicLit{Kind: token.STRING, Value: spec.Path.Value},
+		})
+	}
+
+	return imports
+}
+
+// importsC reports whether the file imports the cgo pseudo-package "C".
+func importsC(f *ast.File) bool {
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil && path == "C" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,308 @@
+package goinject
+
+import (
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/guess"
+)
+
+// declaringModifier is appendCall reporting the imports it requires.
+type declaringModifier struct {
+	Modifier
+	imports []string
+}
+
+func (m declaringModifier) RequiredImports() []*dst.ImportSpec {
+	var specs []*dst.ImportSpec
+	for _, path := range m.imports {
+		specs = append(specs, &dst.ImportSpec{Path: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)}})
+	}
+
+	return specs
+}
+
+// testUnit returns the config and the compile unit of a package consisting of the files,
+// which resolves the names of the packages from their import paths alone.
+func testUnit(tb testing.TB, files map[string]string) (*config, *compileUnit) {
+	tb.Helper()
+
+	dir := tb.TempDir()
+	writeFiles(tb, dir, files)
+
+	var goFiles []string
+	for name := range files {
+		goFiles = append(goFiles, filepath.Join(dir, name))
+	}
+	slices.Sort(goFiles)
+
+	config := &config{
+		logger:           noopLogger{},
+		identPrefix:      DefaultIdentPrefix,
+		build:            newBuildContext(nil, nil, ""),
+		restorerResolver: guess.New(),
+	}
+	unit := &compileUnit{
+		pkgPath:     "main",
+		goFiles:     goFiles,
+		tmpDir:      tb.TempDir(),
+		fset:        token.NewFileSet(),
+		identPrefix: DefaultIdentPrefix,
+	}
+
+	return config, unit
+}
+
+// modifiedFile decorates the file of the unit and applies the modifier to it.
+func modifiedFile(tb testing.TB, config *config, unit *compileUnit, path string, modifier Modifier) *PackageFile {
+	tb.Helper()
+
+	file, err := decorateFile(config, unit, path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	file.File, err = modify(modifier, file.Context, file.File, file.Decorator, file.Restorer)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return file
+}
+
+// modifyAndProcess modifies the file of the unit and writes it, returning the imports of the modified file.
+func modifyAndProcess(tb testing.TB, config *config, unit *compileUnit, path string, modifier Modifier) []*ast.ImportSpec {
+	tb.Helper()
+
+	file := modifiedFile(tb, config, unit, path, modifier)
+	_, imports, err := processFile(config, unit, file, modifier)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return imports
+}
+
+// lineDirectives matches the line directives mapping the modified file to the original one.
+var lineDirectives = regexp.MustCompile(`/\*line [^*]*\*/|(?m)^//line .*\n`)
+
+// modifiedSource applies the modifier to the main.go file of a package with the source,
+// and returns the source of the modified file without its line directives.
+func modifiedSource(tb testing.TB, src string, modifier Modifier) string {
+	tb.Helper()
+
+	config, unit := testUnit(tb, map[string]string{"main.go": src})
+	file := modifiedFile(tb, config, unit, unit.goFiles[0], modifier)
+	path, _, err := processFile(config, unit, file, modifier)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return lineDirectives.ReplaceAllString(string(content), "")
+}
+
+func TestProcessFileImports(t *testing.T) {
+	files := map[string]string{"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n"}
+
+	tests := []struct {
+		name     string
+		modifier Modifier
+		want     []string
+	}{
+		{
+			name:     "reread",
+			modifier: appendCall("main", "strings", "ToUpper"),
+			want:     []string{"fmt", "strings"},
+		},
+		{
+			// The declared imports are taken as they are, so a package the file
+			// does not actually import shows that the file was not reread.
+			name:     "declared",
+			modifier: declaringModifier{Modifier: appendCall("main", "strings", "ToUpper"), imports: []string{"strings", "os"}},
+			want:     []string{"fmt", "strings", "os"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, unit := testUnit(t, files)
+			imports := modifyAndProcess(t, config, unit, unit.goFiles[0], tt.modifier)
+
+			if got := importPaths(imports); !slices.Equal(got, tt.want) {
+				t.Errorf("got imports %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// importingModifier adds the import to the file and appends the call to the main function.
+func importingModifier(name string, path string, call dst.Expr) Modifier {
+	return ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		if path != "" {
+			spec := &dst.ImportSpec{Path: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)}}
+			if name != "" {
+				spec.Name = dst.NewIdent(name)
+			}
+			f.Imports = append(f.Imports, spec)
+			f.Decls = append([]dst.Decl{&dst.GenDecl{Tok: token.IMPORT, Specs: []dst.Spec{spec}}}, f.Decls...)
+		}
+
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == "main" {
+				fn.Body.List = append(fn.Body.List, &dst.ExprStmt{X: &dst.CallExpr{Fun: call}})
+			}
+		}
+		return f
+	})
+}
+
+func TestProcessFileAliasedImports(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		modifier Modifier
+		// want are the lines expected in the modified file.
+		want    []string
+		imports []string
+		// typed reports whether the original file is type-checked, which needs the export data of its imports.
+		typed bool
+	}{
+		{
+			name:     "added alias",
+			src:      "package main\n\nfunc main() {}\n",
+			modifier: importingModifier("f", "fmt", &dst.SelectorExpr{X: dst.NewIdent("f"), Sel: dst.NewIdent("Println")}),
+			want:     []string{`import f "fmt"`, "f.Println()"},
+			imports:  []string{"fmt"},
+		},
+		{
+			name:     "added dot import",
+			src:      "package main\n\nfunc main() {}\n",
+			modifier: importingModifier(".", "strings", &dst.Ident{Path: "strings", Name: "ToUpper"}),
+			want:     []string{`import . "strings"`, "ToUpper()"},
+			imports:  []string{"strings"},
+		},
+		{
+			name:     "original alias",
+			src:      "package main\n\nimport f \"fmt\"\n\nfunc main() { f.Print() }\n",
+			modifier: importingModifier("", "", &dst.Ident{Path: "fmt", Name: "Println"}),
+			want:     []string{`import f "fmt"`, "f.Println()"},
+			imports:  []string{"fmt"},
+		},
+		{
+			name:     "original dot import",
+			src:      "package main\n\nimport . \"fmt\"\n\nfunc main() { Print() }\n",
+			modifier: importingModifier("", "", &dst.Ident{Path: "fmt", Name: "Println"}),
+			want:     []string{`import . "fmt"`, "; Println()"},
+			imports:  []string{"fmt"},
+			// The identifiers of dot imports are told apart from the local ones by their types.
+			typed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, unit := testUnit(t, map[string]string{"main.go": tt.src})
+			if tt.typed {
+				unit.importcfg = exportImportcfg(t, "fmt")
+			}
+			file := modifiedFile(t, config, unit, unit.goFiles[0], tt.modifier)
+			path, imports, err := processFile(config, unit, file, tt.modifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(string(content), want) {
+					t.Errorf("modified file does not contain %q:\n%s", want, content)
+				}
+			}
+			if got := importPaths(imports); !slices.Equal(got, tt.imports) {
+				t.Errorf("got imports %q, want %q", got, tt.imports)
+			}
+		})
+	}
+}
+
+func BenchmarkProcessFileImports(b *testing.B) {
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n"
+	for idx := range 200 {
+		src += "\nfunc f" + strconv.Itoa(idx) + "() { fmt.Println(" + strconv.Itoa(idx) + ") }\n"
+	}
+	files := map[string]string{"main.go": src}
+
+	modifiers := map[string]Modifier{
+		"reread":   appendCall("main", "strings", "ToUpper"),
+		"declared": declaringModifier{Modifier: appendCall("main", "strings", "ToUpper"), imports: []string{"strings"}},
+	}
+	for _, name := range []string{"reread", "declared"} {
+		b.Run(name, func(b *testing.B) {
+			config, unit := testUnit(b, files)
+			b.ResetTimer()
+			for range b.N {
+				// Only the writing of the modified file is measured, which is where the imports are found.
+				b.StopTimer()
+				file := modifiedFile(b, config, unit, unit.goFiles[0], modifiers[name])
+				b.StartTimer()
+
+				if _, _, err := processFile(config, unit, file, modifiers[name]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkProcessFileInMemory(b *testing.B) {
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n"
+	for idx := range 200 {
+		src += "\nfunc f" + strconv.Itoa(idx) + "() { fmt.Println(" + strconv.Itoa(idx) + ") }\n"
+	}
+	files := make(map[string]string)
+	for idx := range 10 {
+		files["file"+strconv.Itoa(idx)+".go"] = strings.Replace(src, "main()", "main"+strconv.Itoa(idx)+"()", 1)
+	}
+	modifier := declaringModifier{Modifier: appendCall("main", "strings", "ToUpper"), imports: []string{"strings"}}
+
+	for _, inMemory := range []bool{false, true} {
+		b.Run("inMemory="+strconv.FormatBool(inMemory), func(b *testing.B) {
+			config, unit := testUnit(b, files)
+			config.inMemory = inMemory
+			b.ResetTimer()
+			for range b.N {
+				// Only the writing of the modified files is measured, up to their being on disk for the compiler.
+				b.StopTimer()
+				modified := make([]*PackageFile, 0, len(unit.goFiles))
+				for _, path := range unit.goFiles {
+					modified = append(modified, modifiedFile(b, config, unit, path, modifier))
+				}
+				b.StartTimer()
+
+				for _, file := range modified {
+					if _, _, err := processFile(config, unit, file, modifier); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := unit.flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package goinject
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// LineDirectives is a mode of mapping positions in the modified files back to the original source.
+type LineDirectives int
+
+const (
+	// LineDirectivesFile prepends a single /*line*/ directive pointing to the
+	// beginning of the original file. Positions are exact as long as modifiers
+	// don't add or remove lines, and drift by the number of lines added otherwise.
+	// This is the default mode.
+	LineDirectivesFile LineDirectives = iota
+
+	// LineDirectivesOff disables line directives entirely, so positions point
+	// to the modified files. Useful for users doing their own mapping.
+	LineDirectivesOff
+
+	// LineDirectivesAccurate additionally puts a /*line*/ directive in front of every
+	// original function and statement, so the positions of original code stay exact
+	// however many lines are injected around it. Injected code is attributed to
+	// the lines following the closest preceding original node.
+	LineDirectivesAccurate
+)
+
+// lineDirective formats a /*line*/ directive. Unlike //line, it doesn't need to start
+// at the beginning of a line and applies to the character immediately following it.
+func lineDirective(path string, line int, col int) string {
+	return fmt.Sprintf("/*line %s:%d:%d*/", path, line, col)
+}
+
+// addLineDirectives decorates every original function declaration and statement
+// of the file with a /*line*/ directive pointing to its position in the original source.
+// Nodes added by modifiers are unknown to the decorator, so they are left as is.
+func addLineDirectives(f *dst.File, dec *decorator.Decorator, path string) {
+	annotate := func(node dst.Node, decs *dst.Decorations) {
+		astNode, ok := dec.Ast.Nodes[node]
+		if !ok {
+			return
+		}
+
+		position := dec.Fset.Position(astNode.Pos())
+		if !position.IsValid() {
+			return
+		}
+
+		// The directive goes last, so that it stays right in front of
+		// the node even if the node has comments above it.
+		decs.Append(lineDirective(path, position.Line, position.Column))
+	}
+
+	for _, decl := range f.Decls {
+		funcDecl, ok := decl.(*dst.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		// The directive is put after the func keyword rather than in front of
+		// the declaration, so it never gets between the declaration and its //go: directives.
+		annotate(funcDecl, &funcDecl.Decs.Func)
+	}
+
+	dst.Inspect(f, func(node dst.Node) bool {
+		var stmts []dst.Stmt
+		switch n := node.(type) {
+		case *dst.BlockStmt:
+			stmts = n.List
+		case *dst.CaseClause:
+			stmts = n.Body
+		case *dst.CommClause:
+			stmts = n.Body
+		}
+
+		for _, stmt := range stmts {
+			annotate(stmt, &stmt.Decorations().Start)
+		}
+
+		return true
+	})
+}
+
+// attachLineDirectives moves every /*line*/ directive added by [addLineDirectives]
+// past the whitespace following it. The restorer may print a directive on its own line
+// or separate it from the node with a space, which would make it point to that
+// whitespace instead of the node. Moving the whitespace in front of the directive
+// keeps the number of lines intact, so other directives stay correct.
+func attachLineDirectives(src []byte, path string) []byte {
+	directive := regexp.MustCompile(`(/\*line ` + regexp.QuoteMeta(path) + `:\d+:\d+\*/)(\s+)`)
+
+	return directive.ReplaceAll(src, []byte("$2$1"))
+}
@@ -0,0 +1,225 @@
+package goinject
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runLinker runs the linker, adding the packages required by the modified code to its importcfg file.
+//
+// cmd/go lists in importcfg.link only the packages the original sources depend on,
+// while the modified code may import packages the program does not link otherwise.
+// Every compile records such packages, along with their dependencies, under its action ID
+// in the build cache, see [writeLinkDeps]. The records outlive the build, since compiled packages
+// may come from the build cache, and are found by the action IDs in the build IDs of the archives.
+// The missing packages are added to importcfg at once, before the linker is run.
+func runLinker(build buildContext, tool string, args []string) error {
+	importCfg := flagValue(args, "-importcfg")
+	if importCfg != "" {
+		if err := addLinkDeps(build, importCfg, args); err != nil {
+			return err
+		}
+	}
+
+	return runCommand(build, tool, args)
+}
+
+// addLinkDeps adds the packages recorded for the linked archives that are missing from the importcfg file.
+func addLinkDeps(build buildContext, importCfgPath string, args []string) error {
+	listed, _, err := readImportcfg(importCfgPath)
+	if err != nil {
+		return err
+	}
+
+	cacheDir, err := buildCacheDir(build)
+	if err != nil {
+		return err
+	}
+
+	archives := make([]string, 0, len(listed)+1)
+	for _, archive := range listed {
+		archives = append(archives, archive)
+	}
+	// The archive of the main package is given as an argument rather than in importcfg.
+	for _, arg := range args {
+		if strings.HasSuffix(arg, ".a") {
+			archives = append(archives, arg)
+		}
+	}
+
+	deps := make(map[string]string)
+	for _, archive := range archives {
+		actionID, err := archiveActionID(archive)
+		if err != nil || !isActionID(actionID) {
+			continue
+		}
+
+		recorded, err := readLinkDeps(cacheDir, actionID)
+		if err != nil {
+			return err
+		}
+		for name, path := range recorded {
+			if _, ok := listed[name]; !ok {
+				deps[name] = path
+			}
+		}
+	}
+
+	if len(deps) == 0 {
+		return nil
+	}
+
+	// The dependencies are added in a stable order, so the patched importcfg
+	// does not vary between builds.
+	entries := make([]ImportcfgEntry, 0, len(deps))
+	for name, path := range deps {
+		entries = append(entries, ImportcfgEntry{Name: name, Path: path})
+	}
+	slices.SortFunc(entries, func(a, b ImportcfgEntry) int { return strings.Compare(a.Name, b.Name) })
+
+	if err := addImportcfgEntries(importCfgPath, entries, nil); err != nil {
+		return fmt.Errorf("failed adding packages to importcfg: %w", err)
+	}
+
+	return nil
+}
+
+// linkDepsMtimeInterval is how often the modification time of a record that is read is updated,
+// like the one of the entries of the build cache, so the record is trimmed along with them.
+const linkDepsMtimeInterval = time.Hour
+
+// buildCacheDir returns the build cache directory of the go command, see `go help cache`.
+func buildCacheDir(build buildContext) (string, error) {
+	dir, err := goEnv(build, "GOCACHE")
+	if err != nil {
+		return "", fmt.Errorf("locating the build cache: %w", err)
+	}
+	if dir == "" || dir == "off" {
+		return "", errors.New("the build cache is disabled, while the link dependencies are recorded in it")
+	}
+
+	return dir, nil
+}
+
+// linkDepsPath returns the path of the link dependencies recorded under the action ID.
+//
+// The records are kept in the build cache, since they describe the archives cached there:
+// they are named like its data files, so the go command trims them along with its entries
+// once unused, and `go clean -cache` removes them. A record holds the import paths
+// and archives of the packages, which the go command never reads.
+func linkDepsPath(cacheDir string, actionID string) string {
+	sum := sha256.Sum256([]byte("goinject linkdeps " + actionID))
+	name := hex.EncodeToString(sum[:])
+
+	return filepath.Join(cacheDir, name[:2], name+"-d")
+}
+
+// writeLinkDeps records the link dependencies collected by the compile under its action ID,
+// the first part of the -buildid flag the go command passes to the compiler.
+// The action ID ends up in the build ID of the archive, including the cached one,
+// which is how [runLinker] finds the record.
+func writeLinkDeps(build buildContext, buildID string) error {
+	deps := collectedLinkDeps()
+	actionID, _, _ := strings.Cut(buildID, "/")
+	if len(deps) == 0 || !isActionID(actionID) {
+		return nil
+	}
+
+	cacheDir, err := buildCacheDir(build)
+	if err != nil {
+		return err
+	}
+
+	path := linkDepsPath(cacheDir, actionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return fmt.Errorf("creating link dependencies directory: %w", err)
+	}
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var content bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&content, "packagefile %s=%s\n", name, deps[name])
+	}
+
+	if err := replaceFile(path, content.Bytes()); err != nil {
+		return fmt.Errorf("writing link dependencies: %w", err)
+	}
+
+	return nil
+}
+
+// readLinkDeps returns the link dependencies recorded under the action ID, if any.
+func readLinkDeps(cacheDir string, actionID string) (map[string]string, error) {
+	path := linkDepsPath(cacheDir, actionID)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading link dependencies: %w", err)
+	}
+
+	// The record is in use, so it must not be trimmed before the archive it describes.
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > linkDepsMtimeInterval {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+
+	deps, _ := parseImportcfg(content)
+
+	return deps, nil
+}
+
+// isActionID reports whether the string is a well-formed action ID, which is used as a file name.
+func isActionID(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+	})
+}
+
+// archiveActionID returns the action ID of the package archive, the first part of its build ID.
+// The build ID is stored in the header of the export data, the first member of the archive:
+//
+//	!<arch>
+//	__.PKGDEF ...
+//	go object linux amd64 go1.22.0 X:...
+//	build id "actionID/contentID"
+func archiveActionID(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(io.LimitReader(file, 1024))
+	for {
+		line, err := reader.ReadString('\n')
+		if value, ok := strings.CutPrefix(line, "build id "); ok {
+			buildID, err := strconv.Unquote(strings.TrimSpace(value))
+			if err != nil {
+				return "", fmt.Errorf("malformed build id in %s: %w", path, err)
+			}
+			actionID, _, _ := strings.Cut(buildID, "/")
+			return actionID, nil
+		}
+		if err != nil {
+			return "", nil
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package goinject
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeArchive writes a package archive with the build ID in the header of its export data.
+func fakeArchive(t *testing.T, path string, buildID string) {
+	t.Helper()
+
+	header := "!<arch>\n__.PKGDEF       0           0     0     644     100       `\ngo object linux amd64 go1.22.0 X:none\nbuild id \"" + buildID + "\"\n"
+	if err := os.WriteFile(path, []byte(header), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLinkDepsInBuildCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("GOCACHE", cacheDir)
+	t.Cleanup(resetImportcfgAdditions)
+
+	deps := map[string]string{"example.com/lib": "/cache/lib.a", "example.com/lib/internal": "/cache/internal.a"}
+	recordLinkDeps(deps)
+	if err := writeLinkDeps(newBuildContext(nil, nil, ""), "actionA/contentA"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record is named like a data file of the build cache, so the go command trims it.
+	path := linkDepsPath(cacheDir, "actionA")
+	if rel, _ := filepath.Rel(cacheDir, path); len(rel) < 3 || rel[2] != filepath.Separator || !strings.HasSuffix(rel, "-d") {
+		t.Errorf("record %s is not named like a data file of the build cache", rel)
+	}
+
+	got, err := readLinkDeps(cacheDir, "actionA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, deps) {
+		t.Errorf("got link dependencies %v, want %v", got, deps)
+	}
+
+	// Another cache does not know about the record.
+	if got, err := readLinkDeps(t.TempDir(), "actionA"); err != nil || got != nil {
+		t.Errorf("got link dependencies %v and error %v from another cache, want none", got, err)
+	}
+}
+
+func TestAddLinkDeps(t *testing.T) {
+	t.Setenv("GOCACHE", t.TempDir())
+	t.Cleanup(resetImportcfgAdditions)
+	build := newBuildContext(nil, nil, "")
+
+	// The dependency of the package was added by the compile of the package, which recorded it.
+	recordLinkDeps(map[string]string{"example.com/lib": "/cache/lib.a", "fmt": "/cache/fmt.a"})
+	if err := writeLinkDeps(build, "actionSub/contentSub"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	subArchive, mainArchive := filepath.Join(dir, "sub.a"), filepath.Join(dir, "main.a")
+	fakeArchive(t, subArchive, "actionSub/contentSub")
+	fakeArchive(t, mainArchive, "actionMain/contentMain")
+
+	importcfg := filepath.Join(dir, "importcfg.link")
+	listed := "packagefile example.com/sub=" + subArchive + "\npackagefile fmt=/cache/fmt.a\n"
+	if err := os.WriteFile(importcfg, []byte(listed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addLinkDeps(build, importcfg, []string{"-o", filepath.Join(dir, "a.out"), "-importcfg", importcfg, mainArchive}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(importcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := listed + "packagefile example.com/lib=/cache/lib.a\n"; string(content) != want {
+		t.Errorf("got importcfg:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestBuildCacheDirDisabled(t *testing.T) {
+	t.Setenv("GOCACHE", "off")
+
+	if _, err := buildCacheDir(newBuildContext(nil, nil, "")); err == nil {
+		t.Error("got the build cache directory with the cache disabled")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package goinject
+
+// lockDir is a no-op on the platforms without flock,
+// where only the patches within the process are serialized.
+func lockDir(dir string) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,64 @@
+package goinject
+
+import (
+	"go/ast"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// modifiedMarkerPrefix starts the comments marking the modified files, see [MarkModified].
+const modifiedMarkerPrefix = "//goinject:modified "
+
+// modifiedMarker returns the comment marking a file modified by the modifier with the given id.
+func modifiedMarker(id string) string {
+	return modifiedMarkerPrefix + id
+}
+
+// hasModifiedMarker reports whether the parsed file was stamped as modified by any modifier,
+// which means it is the output of an earlier preprocessor rather than the original source.
+func hasModifiedMarker(f *ast.File) bool {
+	for _, group := range f.Comments {
+		if group.Pos() >= f.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, modifiedMarkerPrefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MarkModified stamps the file as modified by the modifier with the given id,
+// unless it is already stamped so. The stamp is a `//goinject:modified <id>` comment
+// above the package clause, which is kept in the modified file passed to the compiler.
+//
+// Every build modifies the original files anew, so a modifier never sees its own output
+// in a regular build. The stamp guards against the same modifier being applied twice,
+// by [Chain] or by nested -toolexec preprocessors each handing the modified files on,
+// when used together with [AlreadyModified]:
+//
+//	func (m modifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+//		if goinject.AlreadyModified(f, "tracing") {
+//			return f
+//		}
+//		goinject.MarkModified(f, "tracing")
+//		...
+//	}
+func MarkModified(f *dst.File, id string) {
+	if AlreadyModified(f, id) {
+		return
+	}
+
+	f.Decs.Start.Append(modifiedMarker(id))
+}
+
+// AlreadyModified reports whether the file was stamped as modified by the modifier
+// with the given id, see [MarkModified].
+func AlreadyModified(f *dst.File, id string) bool {
+	return slices.Contains(f.Decs.Start.All(), modifiedMarker(id))
+}
@@ -0,0 +1,67 @@
+package goinject
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// tracingModifier appends a trace call to every function, guarded by its marker if asked to.
+func tracingModifier(guarded bool) Modifier {
+	return ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		if guarded {
+			if AlreadyModified(f, "tracing") {
+				return f
+			}
+			MarkModified(f, "tracing")
+		}
+		return appendCall("main", "", "trace").Modify(f, dec, res)
+	})
+}
+
+func TestAlreadyModified(t *testing.T) {
+	tests := []struct {
+		name    string
+		guarded bool
+		// wantCalls is the number of trace calls after the modifier is applied twice.
+		wantCalls int
+	}{
+		{name: "guarded", guarded: true, wantCalls: 1},
+		{name: "unguarded", guarded: false, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modifier := tracingModifier(tt.guarded)
+			got := modifiedSource(t, "package main\n\nfunc trace() {}\n\nfunc main() {}\n", Chain(modifier, modifier))
+
+			// The declaration of trace is not a call.
+			if calls := strings.Count(got, "trace()") - 1; calls != tt.wantCalls {
+				t.Errorf("got %d trace calls, want %d:\n%s", calls, tt.wantCalls, got)
+			}
+			if marked := strings.Contains(got, "//goinject:modified tracing\n"); marked != tt.guarded {
+				t.Errorf("file marked: %t, want %t:\n%s", marked, tt.guarded, got)
+			}
+			if markers := strings.Count(got, "//goinject:modified"); markers > 1 {
+				t.Errorf("file marked %d times, want once:\n%s", markers, got)
+			}
+		})
+	}
+}
+
+func TestAlreadyModifiedByOtherModifier(t *testing.T) {
+	f, err := decorator.Parse("package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	MarkModified(f, "metrics")
+	if AlreadyModified(f, "tracing") {
+		t.Error("file marked by another modifier is reported as modified by tracing")
+	}
+	if !AlreadyModified(f, "metrics") {
+		t.Error("file marked by metrics is not reported as modified by it")
+	}
+}
@@ -0,0 +1,167 @@
+package goinject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// module describes a main module of the build, as reported by `go list -m -json`.
+type module struct {
+	Path string // The module path
+	Dir  string // The directory holding the module's files
+}
+
+// goEnv returns the value of the given go environment variable.
+// It utilizes `go env <name>`, so the value respects both the process
+// environment and the user's go env configuration file.
+func goEnv(build buildContext, name string) (string, error) {
+	cmd := build.goCommand("env", name)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %q: %w", cmd.Args, err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// goWork returns the path to the go.work file in effect, or an empty string
+// if the build is not running in workspace mode.
+func goWork(build buildContext) (string, error) {
+	goWork, err := goEnv(build, "GOWORK")
+	if err != nil {
+		return "", err
+	}
+
+	// GOWORK=off explicitly disables workspace mode, while os.DevNull
+	// is reported by some go versions for the same purpose.
+	if goWork == "off" || goWork == os.DevNull {
+		return "", nil
+	}
+
+	return goWork, nil
+}
+
+// mainModules lists the main modules of the build. Outside of workspace mode
+// it is only the current module, and in workspace mode it is every module
+// listed in go.work.
+func mainModules(build buildContext) ([]module, error) {
+	cmd := build.goCommand("list", "-m", "-json")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %q: %w", cmd.Args, err)
+	}
+
+	var modules []module
+
+	dec := json.NewDecoder(&stdout)
+	for {
+		var mod module
+		if err := dec.Decode(&mod); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing `go list -m` output: %w", err)
+		}
+		modules = append(modules, mod)
+	}
+
+	return modules, nil
+}
+
+// ImportPathForDir resolves a filesystem directory to the canonical import path
+// of the package located in it.
+// The directory must belong to one of the main modules of the build
+// (the current module, or any module listed in go.work in workspace mode).
+//
+// It is useful for modifiers that discover packages by scanning directories
+// and then need to import them in the modified code.
+func ImportPathForDir(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolving absolute path of %q: %w", dir, err)
+	}
+
+	modules, err := mainModules(buildContext{})
+	if err != nil {
+		return "", err
+	}
+
+	// Pick the innermost module containing the directory, since
+	// workspace modules may be nested in each other.
+	var owner *module
+	var relPath string
+	for idx := range modules {
+		mod := &modules[idx]
+		if mod.Dir == "" {
+			continue
+		}
+
+		rel, err := filepath.Rel(mod.Dir, absDir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		if owner == nil || len(mod.Dir) > len(owner.Dir) {
+			owner, relPath = mod, rel
+		}
+	}
+
+	if owner == nil {
+		return "", fmt.Errorf("directory %q is outside of the main modules", dir)
+	}
+
+	// A go.mod file between the module root and the directory means that
+	// the directory belongs to a nested module that is not part of the build.
+	for sub := absDir; sub != owner.Dir && sub != filepath.Dir(sub); sub = filepath.Dir(sub) {
+		if _, err := os.Stat(filepath.Join(sub, "go.mod")); err == nil {
+			return "", fmt.Errorf("directory %q belongs to module %q, which is not a main module", dir, sub)
+		}
+	}
+
+	if relPath == "." {
+		return owner.Path, nil
+	}
+
+	return path.Join(owner.Path, filepath.ToSlash(relPath)), nil
+}
+
+// moduleRoot returns the directory of the go.mod file of the module containing dir,
+// or an empty string if dir is not inside of a module.
+func moduleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// moduleGoVersion returns the version of the go directive of the module at root,
+// in the form of [go/version], e.g. go1.21, or an empty string if there is none.
+func moduleGoVersion(root string) string {
+	content, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "go" {
+			return "go" + fields[1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,128 @@
+package goinject
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestImportPathForDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                "module example.com/app\n\ngo 1.22\n",
+		"main.go":               "package main\n\nfunc main() {}\n",
+		"internal/util/util.go": "package util\n",
+		"tools/go.mod":          "module example.com/tools\n\ngo 1.22\n",
+		"tools/gen/gen.go":      "package gen\n",
+	})
+	chdir(t, dir)
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr string
+	}{
+		{name: "root", dir: dir, want: "example.com/app"},
+		{name: "subdirectory", dir: filepath.Join(dir, "internal", "util"), want: "example.com/app/internal/util"},
+		{name: "relative", dir: "internal/util", want: "example.com/app/internal/util"},
+		{name: "nested module", dir: filepath.Join(dir, "tools", "gen"), wantErr: "is not a main module"},
+		{name: "outside", dir: t.TempDir(), wantErr: "outside of the main modules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImportPathForDir(tt.dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got %q and error %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got import path %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModifyContextModule(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "go directive", want: "go1.21"},
+		{name: "lang flag", args: []string{"-lang=go1.20"}, want: "go1.20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":     "module example.com/app\n\ngo 1.21\n",
+				"lib/lib.go": "package lib\n\nfunc Hello() {}\n",
+				"importcfg":  "",
+			})
+
+			var goVersion, moduleRoot string
+			modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				goVersion, moduleRoot = ctx.GoVersion, ctx.ModuleRoot
+			})
+
+			tool, _ := fakeCompiler(t, 0)
+			args := append([]string{"-p", "example.com/app/lib", "-importcfg", filepath.Join(dir, "importcfg")}, tt.args...)
+			args = append(args, "-pack", filepath.Join(dir, "lib", "lib.go"))
+			if err := runCompile(t, dir, tool, args, modifier); err != nil {
+				t.Fatal(err)
+			}
+
+			if goVersion != tt.want {
+				t.Errorf("got Go version %q, want %q", goVersion, tt.want)
+			}
+			if moduleRoot != dir {
+				t.Errorf("got module root %q, want %q", moduleRoot, dir)
+			}
+		})
+	}
+}
+
+func TestProcessOutsideModule(t *testing.T) {
+	tests := []struct {
+		name string
+		// go111module is the module mode, in which `go env GOMOD` reports an empty path or os.DevNull.
+		go111module string
+	}{
+		{name: "GOPATH mode", go111module: "off"},
+		{name: "no go.mod", go111module: "on"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GO111MODULE", tt.go111module)
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			tool, argsFile := fakeCompiler(t, 0)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			if err := runCompile(t, dir, tool, args, identity); err != nil {
+				t.Fatal(err)
+			}
+
+			// The working directory is the root of the project, so the file is modified.
+			if compiled := compiledArgs(t, argsFile); compiled[len(compiled)-1] == mainFile {
+				t.Error("file in the working directory was compiled as is")
+			}
+		})
+	}
+}
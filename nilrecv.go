@@ -0,0 +1,120 @@
+package goinject
+
+import (
+	"go/token"
+	"strconv"
+
+	"github.com/dave/dst"
+)
+
+// NilReceiverAction selects what a method does when it is called on a nil receiver,
+// see [NilReceiverCheck].
+type NilReceiverAction int
+
+const (
+	// NilReceiverReturnZero returns the zero values of the results from the method.
+	NilReceiverReturnZero NilReceiverAction = iota
+	// NilReceiverCallHandler calls the handler of [NilReceiverCheck] with the name of the method,
+	// and then returns the zero values of the results.
+	NilReceiverCallHandler
+	// NilReceiverPanic panics with a message naming the method, which is easier to trace
+	// than the nil pointer dereference the method would panic with somewhere inside of it.
+	NilReceiverPanic
+)
+
+// NilReceiverCheck describes a check preventing methods with pointer receivers
+// from panicking when they are called on a nil receiver.
+type NilReceiverCheck struct {
+	// Action is what the method does when it is called on a nil receiver.
+	Action NilReceiverAction
+	// Path is the import path of the package providing the handler, used with [NilReceiverCallHandler].
+	Path string
+	// Handler is the name of the handler function, e.g. `func NilReceiver(method string)`.
+	// The method is named like `Type.Method`.
+	Handler string
+}
+
+// Inject adds the check at the entry of the method:
+//
+//	func (s *Server) Addr() (string, error) {
+//		if s == nil {
+//			return *new(string), *new(error)
+//		}
+//		...
+//	}
+//
+// Methods with named results return with a bare return statement, which returns
+// the zero values as well, since nothing could be assigned to the results yet.
+// Unnamed receivers are given the name `__goinject_recv`, prefixed as configured with [WithIdentPrefix].
+//
+// Inject reports whether the method was instrumented. Functions, methods with value
+// receivers and methods without a body are skipped.
+func (c NilReceiverCheck) Inject(ctx *ModifyContext, decl *dst.FuncDecl) bool {
+	if decl.Body == nil || decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return false
+	}
+
+	recv := decl.Recv.List[0]
+	if _, ok := recv.Type.(*dst.StarExpr); !ok {
+		return false
+	}
+
+	if len(recv.Names) == 0 || recv.Names[0].Name == "_" {
+		recv.Names = []*dst.Ident{dst.NewIdent(ctx.Ident("recv"))}
+	}
+
+	var body []dst.Stmt
+	switch c.Action {
+	case NilReceiverPanic:
+		body = append(body, &dst.ExprStmt{
+			X: &dst.CallExpr{
+				Fun:  dst.NewIdent("panic"),
+				Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcName(decl) + " called on nil receiver")}},
+			},
+		})
+	case NilReceiverCallHandler:
+		body = append(body, &dst.ExprStmt{
+			X: &dst.CallExpr{
+				Fun:  &dst.Ident{Path: c.Path, Name: c.Handler},
+				Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcName(decl))}},
+			},
+		})
+		fallthrough
+	default:
+		body = append(body, &dst.ReturnStmt{Results: zeroResults(decl.Type.Results)})
+	}
+
+	check := &dst.IfStmt{
+		Cond: &dst.BinaryExpr{
+			X:  dst.NewIdent(recv.Names[0].Name),
+			Op: token.EQL,
+			Y:  dst.NewIdent("nil"),
+		},
+		Body: &dst.BlockStmt{List: body},
+	}
+	check.Decs.After = dst.EmptyLine
+
+	decl.Body.List = append([]dst.Stmt{check}, decl.Body.List...)
+
+	return true
+}
+
+// zeroResults returns the zero values of the results, as `*new(T)` for every result of type T.
+// It returns nil for named results, which are returned with a bare return statement.
+func zeroResults(results *dst.FieldList) []dst.Expr {
+	if results == nil || len(results.List) == 0 || len(results.List[0].Names) > 0 {
+		return nil
+	}
+
+	var zeros []dst.Expr
+	for _, field := range results.List {
+		zeros = append(zeros, &dst.StarExpr{
+			X: &dst.CallExpr{
+				Fun:  dst.NewIdent("new"),
+				Args: []dst.Expr{dst.Clone(field.Type).(dst.Expr)},
+			},
+		})
+	}
+
+	return zeros
+}
@@ -0,0 +1,97 @@
+package goinject
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestNilReceiverCheckInject(t *testing.T) {
+	src := `package main
+
+type T struct{ n int }
+
+func (t *T) None() { t.n++ }
+
+func (t *T) Single() int { return t.n }
+
+func (t *T) Multiple() (int, error) { return t.n, nil }
+
+func (t *T) Named() (n int, err error) { return t.n, nil }
+
+func (*T) Unnamed() string { return "" }
+
+func (t T) Value() int { return t.n }
+
+func Func() {}
+`
+
+	tests := []struct {
+		name  string
+		check NilReceiverCheck
+		// want are the expected checks by the instrumented methods.
+		want map[string]string
+	}{
+		{
+			name:  "return zero",
+			check: NilReceiverCheck{Action: NilReceiverReturnZero},
+			want: map[string]string{
+				"None":     "if t == nil {\n\t\treturn\n\t}",
+				"Single":   "if t == nil {\n\t\treturn *new(int)\n\t}",
+				"Multiple": "if t == nil {\n\t\treturn *new(int), *new(error)\n\t}",
+				"Named":    "if t == nil {\n\t\treturn\n\t}",
+				"Unnamed":  "if __goinject_recv == nil {\n\t\treturn *new(string)\n\t}",
+			},
+		},
+		{
+			name:  "call handler",
+			check: NilReceiverCheck{Action: NilReceiverCallHandler, Path: "example.com/hardening", Handler: "NilReceiver"},
+			want: map[string]string{
+				"None":     "if t == nil {\n\t\thardening.NilReceiver(\"T.None\")\n\t\treturn\n\t}",
+				"Single":   "if t == nil {\n\t\thardening.NilReceiver(\"T.Single\")\n\t\treturn *new(int)\n\t}",
+				"Multiple": "if t == nil {\n\t\thardening.NilReceiver(\"T.Multiple\")\n\t\treturn *new(int), *new(error)\n\t}",
+				"Named":    "if t == nil {\n\t\thardening.NilReceiver(\"T.Named\")\n\t\treturn\n\t}",
+				"Unnamed":  "if __goinject_recv == nil {\n\t\thardening.NilReceiver(\"T.Unnamed\")\n\t\treturn *new(string)\n\t}",
+			},
+		},
+		{
+			name:  "panic",
+			check: NilReceiverCheck{Action: NilReceiverPanic},
+			want: map[string]string{
+				"None":     "if t == nil {\n\t\tpanic(\"T.None called on nil receiver\")\n\t}",
+				"Single":   "if t == nil {\n\t\tpanic(\"T.Single called on nil receiver\")\n\t}",
+				"Multiple": "if t == nil {\n\t\tpanic(\"T.Multiple called on nil receiver\")\n\t}",
+				"Named":    "if t == nil {\n\t\tpanic(\"T.Named called on nil receiver\")\n\t}",
+				"Unnamed":  "if __goinject_recv == nil {\n\t\tpanic(\"T.Unnamed called on nil receiver\")\n\t}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instrumented := make(map[string]bool)
+			got := modifiedSource(t, src, funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				if tt.check.Inject(ctx, decl) {
+					instrumented[decl.Name.Name] = true
+				}
+			}))
+
+			for method, check := range tt.want {
+				if !instrumented[method] {
+					t.Errorf("method %s was not instrumented", method)
+				}
+				// The check is the first statement of the method.
+				_, decl, _ := strings.Cut(got, ") "+method+"(")
+				decl, _, _ = strings.Cut(decl, "\nfunc ")
+				if !strings.Contains(decl, "{\n\t"+check+"\n\n") {
+					t.Errorf("method %s is not guarded by:\n%s\ngot:\n%s", method, check, got)
+				}
+			}
+			// Value receivers can not be nil, and functions have no receiver at all.
+			if len(instrumented) != len(tt.want) {
+				t.Errorf("got instrumented methods %v, want %d", instrumented, len(tt.want))
+			}
+		})
+	}
+}
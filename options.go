@@ -1,7 +1,87 @@
 package goinject
 
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator/resolver/guess"
+)
+
 type config struct {
-	logger Logger
+	logger         Logger
+	buildTags      []string
+	changedSince   string
+	keepTempFiles  bool
+	validateOutput bool
+	format         bool
+	inMemory       bool
+	collectErrors  bool
+	skipGenerated  bool
+	extraRoots     []string
+	archConstraint func(goarch string) bool
+	fileFilter     func(path string, f *dst.File) bool
+	pathFilter     func(path string) bool
+	packageWindow  int
+	parallelism    int
+	testFiles      testFilesMode
+
+	includePackages   []string
+	excludePackages   []string
+	dependencyModules []string
+
+	beforeCompile func(args []string) error
+	afterCompile  func(args []string, err error)
+	asmHook       func(tool string, args []string)
+	processStdlib bool
+	reportPath    string
+
+	importCycleCheck bool
+	allocMeasurement bool
+
+	lineDirectives      LineDirectives
+	directiveValidation DirectiveValidation
+	identPrefix         string
+
+	featureFlagsFile string
+	featureFlagsEnv  string
+	featureFlags     map[string]any
+
+	configFile     string
+	packageConfigs map[string]PackageConfig
+
+	// cacheInputs are mixed into the build ID reported to cmd/go,
+	// so that changing them recompiles the affected packages.
+	cacheInputs [][]byte
+
+	buildEnv []string
+	goBinary string
+
+	build          buildContext
+	resolver       PackageResolver
+	resolveRetries int
+	resolveBackoff time.Duration
+
+	restorerResolver guess.RestorerResolver
+	resolverMap      map[string]string
+	runtimeImports   []runtimeImport
+
+	timeout      time.Duration
+	sourceMapDir string
+
+	translateErrors bool
+
+	stdout io.Writer
+	stderr io.Writer
+
+	profileWriter  io.Writer
+	cpuProfileDir  string
+	modifierPlugin string
+	reproducible   bool
+	recover        bool
+
+	importcfgRewriter func(lines []string) []string
 }
 
 type Option func(*config)
@@ -21,3 +101,628 @@ func WithLogger(logger Logger) Option {
 		c.logger = logger
 	}
 }
+
+// WithBuildTags specifies the build tags the project is built with.
+// The go toolchain does not pass the -tags flag of `go build` to the tools it runs,
+// so they must be repeated here (or set via GOFLAGS) for import resolution
+// to see the same files as the compiler.
+func WithBuildTags(tags ...string) Option {
+	return func(c *config) {
+		c.buildTags = append(c.buildTags, tags...)
+	}
+}
+
+// WithBuildEnv adds the given `KEY=value` variables to the environment of every subprocess
+// goinject runs: the tool it wraps and the go command used to inspect the project.
+// It allows hermetic builds to control GOFLAGS, GOCACHE, GOPATH and the like.
+func WithBuildEnv(env []string) Option {
+	return func(c *config) {
+		c.buildEnv = append(c.buildEnv, env...)
+	}
+}
+
+// WithGoBinary sets the path to the go command to use instead of the one found in PATH.
+// The binary must be named go, since [golang.org/x/tools/go/packages] only looks up
+// the go command in PATH, where the directory of the binary is put first.
+func WithGoBinary(path string) Option {
+	return func(c *config) {
+		c.goBinary = path
+	}
+}
+
+// WithExtraRoots adds directories whose packages are modified along with the project.
+//
+// The project consists of the main module, or every module of the workspace in workspace mode,
+// and the modules replaced with a local directory. Files outside of these directories,
+// e.g. code generated into a shared location, are compiled as is
+// unless they are under one of the extra roots.
+func WithExtraRoots(dirs ...string) Option {
+	return func(c *config) {
+		c.extraRoots = append(c.extraRoots, dirs...)
+	}
+}
+
+// WithArchConstraint restricts modification to the architectures the injected code supports,
+// e.g. code using 64-bit atomics on 32-bit targets. The constraint is called with the GOARCH
+// of the compilation, and if it returns false, the package is compiled as is.
+func WithArchConstraint(constraint func(goarch string) bool) Option {
+	return func(c *config) {
+		c.archConstraint = constraint
+	}
+}
+
+// WithBeforeCompile sets a hook called right before the compiler is run on a project package,
+// after its files were modified. The hook receives a copy of the compiler command line:
+// the path to the compiler followed by its arguments, with the modified files substituted.
+// If the hook returns an error, the package is not compiled and the error is returned.
+//
+// Packages compiled as is, like the ones of the std library, do not trigger the hook.
+func WithBeforeCompile(hook func(args []string) error) Option {
+	return func(c *config) {
+		c.beforeCompile = hook
+	}
+}
+
+// WithAfterCompile sets a hook called right after the compiler is run on a project package,
+// with the same command line as [WithBeforeCompile] and the error of the compiler, if any.
+func WithAfterCompile(hook func(args []string, err error)) Option {
+	return func(c *config) {
+		c.afterCompile = hook
+	}
+}
+
+// WithAsmHook sets a hook called right before the assembler is run on the .s files
+// of a package, with the path to the assembler and a copy of its arguments.
+// The assembler is always run on the original files, so the hook only allows
+// a preprocessor to observe the assembly stage.
+func WithAsmHook(hook func(tool string, args []string)) Option {
+	return func(c *config) {
+		c.asmHook = hook
+	}
+}
+
+// WithProcessStdlib passes the packages of the std library to the modifier as well,
+// which are compiled as is by default. It is meant for building instrumented toolchains,
+// and should be used with care: the code injected into the std library must not import
+// packages depending on the modified package, and the runtime package in particular
+// tolerates very little code. Every modified std library package is reported via the logger.
+func WithProcessStdlib() Option {
+	return func(c *config) {
+		c.processStdlib = true
+	}
+}
+
+// WithReport writes a JSON report of the modifications to the file at path: for every modified
+// package, the modified files with the imports added to them, the packages added to importcfg,
+// the time it took and the error it failed with, if any. Packages compiled as is are not reported.
+//
+// The go command compiles every package in a separate process, so the report of each package
+// is merged into the file rather than overwriting it, replacing the one of its previous build.
+func WithReport(path string) Option {
+	return func(c *config) {
+		c.reportPath = path
+	}
+}
+
+// WithChangedFilesOnly restricts modification to files that differ from the given
+// git ref in the working tree, including untracked files. Unchanged files are
+// compiled as is, which speeds up local builds where only the files being edited matter.
+//
+// Files outside of the git repository are treated as unchanged.
+// If the project is not a git repository at all, every file is modified.
+func WithChangedFilesOnly(ref string) Option {
+	return func(c *config) {
+		c.changedSince = ref
+	}
+}
+
+// WithKeepTempFiles preserves the modified files after compilation, so the
+// generated code can be inspected when the compiler reports an error in it.
+// The path to the retained directory is reported via the logger.
+func WithKeepTempFiles() Option {
+	return func(c *config) {
+		c.keepTempFiles = true
+	}
+}
+
+// WithValidateOutput parses the modified code before compiling it, so a modifier producing
+// invalid syntax is reported with the name of the original file and a snippet of the generated code.
+// It costs an extra parse of every modified file.
+func WithValidateOutput() Option {
+	return func(c *config) {
+		c.validateOutput = true
+	}
+}
+
+// WithFormat runs the modified code through gofmt before compiling it, which makes the files
+// retained with [WithKeepTempFiles] easier to read and diff. If the code can not be formatted,
+// it is compiled as is and a warning is reported via the logger.
+func WithFormat() Option {
+	return func(c *config) {
+		c.format = true
+	}
+}
+
+// WithInMemory keeps the modified files in memory until all of them are done,
+// and writes them to disk only right before the compiler is called,
+// since the compiler reads its input from files.
+//
+// A compile unit failing to be modified then leaves no files behind. This option is experimental.
+func WithInMemory() Option {
+	return func(c *config) {
+		c.inMemory = true
+	}
+}
+
+// WithRecover makes a file the modifier fails on be compiled as is instead of failing the build.
+// The modifier fails if it panics or returns nil, and the failure is reported as a warning
+// against the file. For a [PackageModifier], all the files it was given are compiled as is.
+//
+// It suits instrumentation that is not essential to the program, like tracing,
+// where a bug of the modifier must not break the build. Without it, a nil file
+// fails the build with an error naming the file and the modifier.
+func WithRecover() Option {
+	return func(c *config) {
+		c.recover = true
+	}
+}
+
+// WithConfigFile configures the modifier per package with the JSON file at path,
+// which lists the configurations of the packages by the patterns of their import paths:
+//
+//	{
+//		"packages": {
+//			"example.com/monorepo/...": {"settings": {"sampleRate": 0.1}},
+//			"example.com/monorepo/payments/...": {"settings": {"sampleRate": 1}},
+//			"example.com/monorepo/legacy": {"enabled": false}
+//		}
+//	}
+//
+// Patterns are import paths, optionally with wildcards, like in [WithPackages].
+// A package gets the configuration of the most specific pattern it matches, the one with
+// the longest literal prefix, preferring an exact pattern to a wildcard one and,
+// between patterns equally specific, the first one in lexical order.
+// Test packages get the configuration of the package they test. Packages whose configuration is
+// disabled are compiled as is, and the settings of the others are available to the modifier
+// via [ModifyContext.Settings].
+//
+// The file is a part of the build cache key, so changing it recompiles the project.
+func WithConfigFile(path string) Option {
+	return func(c *config) {
+		c.configFile = path
+	}
+}
+
+// WithFailFast stops processing a compile unit at the first file that fails
+// to be modified and reports only that error. This is the default behavior.
+func WithFailFast() Option {
+	return func(c *config) {
+		c.collectErrors = false
+	}
+}
+
+// WithCollectErrors processes every file of a compile unit even if some of them
+// fail to be modified, and reports all the errors together.
+func WithCollectErrors() Option {
+	return func(c *config) {
+		c.collectErrors = true
+	}
+}
+
+// WithSkipGenerated compiles generated files as is, without passing them to the modifier.
+// A file is considered generated if it has a `// Code generated ... DO NOT EDIT.` comment
+// before its package clause, as described in https://go.dev/s/generatedcode.
+func WithSkipGenerated() Option {
+	return func(c *config) {
+		c.skipGenerated = true
+	}
+}
+
+// testFilesMode selects which of the files compiled under `go test` are modified.
+type testFilesMode int
+
+const (
+	allFiles testFilesMode = iota
+	skipTestFiles
+	onlyTestFiles
+)
+
+// WithSkipTestFiles leaves _test.go files unmodified, so only production code
+// is instrumented when the project is built with `go test`. This applies both to
+// the test files of the package itself and to the ones of its external _test package.
+func WithSkipTestFiles() Option {
+	return func(c *config) {
+		c.testFiles = skipTestFiles
+	}
+}
+
+// WithOnlyTestFiles is the inverse of [WithSkipTestFiles]: only _test.go files
+// are modified, which allows to instrument the tests without touching the code under test.
+func WithOnlyTestFiles() Option {
+	return func(c *config) {
+		c.testFiles = onlyTestFiles
+	}
+}
+
+// WithImportCycleCheck verifies that imports injected by the modifier do not depend
+// on the package being modified. Otherwise the build fails with an error describing
+// the cycle, instead of the compiler's error about the modified file.
+// The check lists the dependencies of the injected imports with `go list` once per package,
+// so it slows the build down.
+func WithImportCycleCheck() Option {
+	return func(c *config) {
+		c.importCycleCheck = true
+	}
+}
+
+// WithAllocMeasurement enables the allocation measurement injected by [AllocMeasure].
+// Without it, the measurement is not injected at all, so it can be enabled for development
+// builds only. The toggle is part of the build cache key, so switching it recompiles the project.
+func WithAllocMeasurement(enabled bool) Option {
+	return func(c *config) {
+		c.allocMeasurement = enabled
+	}
+}
+
+// PackageResolver maps the import path of a package to the paths of the compiled archives
+// of the package and all its dependencies, keyed by their import paths. See [ResolvePkg].
+type PackageResolver func(pkgName string) (map[string]string, error)
+
+// WithPackageResolver replaces the resolver used to find the archives of packages
+// that are missing from importcfg after modification. It defaults to [ResolvePkg]
+// running within the build context of the compilation, see [WithBuildTags].
+//
+// A custom resolver lets users who already have this data, e.g. from their own
+// [golang.org/x/tools/go/packages.Load] call with NeedExportFile, avoid spawning `go list` for every injected package.
+func WithPackageResolver(resolver PackageResolver) Option {
+	return func(c *config) {
+		c.resolver = resolver
+	}
+}
+
+// WithPackageWindow makes a [PackageModifier] process the files of a package in consecutive
+// windows of at most size files, instead of all of them at once.
+//
+// ModifyPackage is then called once per window, with the files in the order they are
+// passed to the compiler. The files of a window are written to disk and released before
+// the next window is decorated, so the modifier must not retain them between calls.
+// This bounds the memory used for huge packages, and suits transforms that only need
+// local state. Transforms relying on seeing the whole package must not use it.
+func WithPackageWindow(size int) Option {
+	return func(c *config) {
+		c.packageWindow = size
+	}
+}
+
+// FileFilter is the type of the filters of [WithFileFilter]: a function of either the path
+// to the original file alone, or the path and the decorated file.
+type FileFilter interface {
+	func(path string) bool | func(path string, f *dst.File) bool
+}
+
+// WithFileFilter makes only the files the filter accepts be modified,
+// and the files it rejects are compiled as is. A [PackageModifier] does not get them either.
+//
+// A filter of the path alone is called before the file is even parsed, so the files
+// the modifier does not care about cost nothing beyond the call, which speeds up large builds:
+//
+//	goinject.WithFileFilter(func(path string) bool { return !strings.Contains(path, "/vendor/") })
+//
+// The path is the one the compiler is given, see [ModifyContext.Path]. The compiler runs
+// in the directory of the package, so [filepath.Abs] resolves it to the absolute path.
+//
+// A filter of the path and the decorated file is called before the modifier sees the file,
+// and allows decisions based on its content, e.g. modifying only the files declaring an exported type.
+// It is the general form of the narrower options selecting files, like [WithSkipGenerated],
+// which are applied first.
+func WithFileFilter[F FileFilter](filter F) Option {
+	return func(c *config) {
+		switch filter := any(filter).(type) {
+		case func(path string) bool:
+			c.pathFilter = filter
+		case func(path string, f *dst.File) bool:
+			c.fileFilter = filter
+		}
+	}
+}
+
+// WithPackages limits the modification to the packages whose import paths match the patterns.
+// Patterns prefixed with ! exclude the packages they match instead, and win over the others:
+//
+//	goinject.WithPackages("github.com/me/app/internal/...", "!**/testdata/**")
+//
+// Patterns may contain the wildcards of `go list`, where ... matches any string,
+// and the ones of globs, where * matches any string without slashes and ** any string.
+// A trailing /... or /** also matches the package before it, and a leading **/
+// also matches the packages at the root. Without patterns including packages, all the
+// packages but the excluded ones are modified. Test packages are selected along with
+// the package they test, while main packages are compiled with the import path "main".
+// The packages left out are compiled as is.
+func WithPackages(patterns ...string) Option {
+	return func(c *config) {
+		for _, pattern := range patterns {
+			if excluded, ok := strings.CutPrefix(pattern, "!"); ok {
+				c.excludePackages = append(c.excludePackages, excluded)
+			} else {
+				c.includePackages = append(c.includePackages, pattern)
+			}
+		}
+	}
+}
+
+// WithDependencyModules makes the packages of dependencies whose import paths match
+// the patterns be modified along with the project, e.g. to instrument a client library:
+//
+//	goinject.WithDependencyModules("github.com/redis/go-redis/...")
+//
+// The patterns are the ones of [WithPackages], which still applies to the matched packages.
+// Dependencies live in the read-only module cache or in the vendor directory, but like
+// any other file, their modified copies are written to the temporary directory
+// and compiled instead of them, so the originals are left untouched.
+func WithDependencyModules(patterns ...string) Option {
+	return func(c *config) {
+		c.dependencyModules = append(c.dependencyModules, patterns...)
+	}
+}
+
+// WithParallelism makes up to n files of a package be modified concurrently.
+// By default, the files are modified one by one.
+//
+// The modifier, and the logger, must then be safe for concurrent use. The modified files
+// are still passed to the compiler in their original order, and the errors are reported
+// in that order as well. However, the file receiving the declarations added with
+// [ModifyContext.EnsureInit] and [ModifyContext.EnsurePackageVar] is then the first one
+// to get there, rather than the first one of the package.
+//
+// Only the files given to [Modifier] and [ModifierV2] are modified concurrently,
+// a [PackageModifier] always sees the whole package at once.
+func WithParallelism(n int) Option {
+	return func(c *config) {
+		c.parallelism = n
+	}
+}
+
+// WithModifierPlugin loads the modifier from the Go plugin at path, see [plugin], instead of using
+// the one passed to [Process]. The plugin must export the Modifier symbol, a variable of a type
+// implementing [Modifier]:
+//
+//	var Modifier modifier
+//
+// This allows iterating on the modifier during development by rebuilding just the plugin with
+// `go build -buildmode=plugin`, without rebuilding the preprocessor. Changing the plugin
+// recompiles the project, since its content is a part of the build cache key.
+// The plugin must be built with the same Go version and the same version of goinject as the preprocessor.
+//
+// Go plugins are only supported on Linux, macOS and FreeBSD, and require cgo. Elsewhere, the modifier
+// passed to Process is used instead, and a warning is reported via the logger.
+func WithModifierPlugin(path string) Option {
+	return func(c *config) {
+		c.modifierPlugin = path
+	}
+}
+
+// WithImportcfgRewriter lets the rewriter edit the importcfg file of every modified package
+// after the packages imported by the modifications are added to it, right before the compiler
+// is called. The rewriter gets the lines of the file and returns the new ones, which replace
+// the file at once. This allows custom linking schemes, like redirecting a package
+// to a substituted archive, removing entries, or adding importmap lines.
+//
+// The packages compiled as is keep their importcfg files, and the importcfg of the linker
+// is not rewritten, so a substituted archive must keep the export data of the original package.
+func WithImportcfgRewriter(rewriter func(lines []string) []string) Option {
+	return func(c *config) {
+		c.importcfgRewriter = rewriter
+	}
+}
+
+// WithReproducible names the tmp dir of every package after the hash of the project root,
+// the package, its files and the build ID of the preprocessor, rather than randomly.
+// The compiler may record the paths to the modified files in its output, so with random
+// names identical inputs do not produce byte-identical binaries.
+//
+// The directories are kept after compilation, as the builds of the same package share them,
+// and are overwritten by the next build of the package. They are locked while in use,
+// so concurrent builds of the same package wait for each other. Locking is not supported
+// on Windows, where such builds must not run concurrently.
+func WithReproducible() Option {
+	return func(c *config) {
+		c.reproducible = true
+	}
+}
+
+// WithSourceMap writes a source map for every modified file to dir, mapping the lines
+// of the modified file to the lines of the original one. The maps outlive the modified
+// files, which are removed after compilation, so tools can translate the positions
+// reported against the modified files, e.g. in coverage profiles or by debuggers,
+// back to the original source.
+//
+// The maps are JSON files named after the original files, e.g. dir/1f2e3d4c5b6a7980/main.go.map.json,
+// and list the paths of both files along with the mapping of the lines where original code starts.
+// The lines of the generated file count from the beginning of the file, regardless of the line directives.
+// The go command runs the compiler in the directory of every package, so dir should be absolute.
+func WithSourceMap(dir string) Option {
+	return func(c *config) {
+		c.sourceMapDir = dir
+	}
+}
+
+// WithErrorTranslation rewrites the positions the compiler reports in the modified files
+// to the positions in the original files, so an error caused by the code injected by the modifier,
+// like a reference to an undefined identifier, points to the original source rather than
+// to a line of a removed temporary file, or to a line of the original file it does not come from:
+//
+//	./main.go:12: undefined: trace (in code injected by the modifier)
+//
+// The injected code is attributed to the closest preceding line of the original code.
+// The output of the compiler is buffered to be translated, so it is reported once the compiler exits.
+// With [LineDirectivesAccurate], the compiler reports the positions of the original code exactly
+// by itself, so no translation is done.
+func WithErrorTranslation() Option {
+	return func(c *config) {
+		c.translateErrors = true
+	}
+}
+
+// WithProfile writes a summary of every compiled package that has files to modify to w:
+// the number of the modified files and of the bytes written, the number of the go command
+// subprocesses spawned to resolve the imports, and the time spent in each stage of the run.
+// cmd/go compiles packages in separate processes running concurrently, so w is typically
+// os.Stderr, or a file opened with [os.O_APPEND] that keeps every summary in one piece.
+func WithProfile(w io.Writer) Option {
+	return func(c *config) {
+		c.profileWriter = w
+	}
+}
+
+// WithStdout forwards the standard output of the tools run by [Process], like the compiler
+// and the linker, to w rather than to [os.Stdout]. It allows the embedders to capture
+// or suppress the output, e.g. in tests. The output cmd/go reads from the preprocessor itself,
+// like the version of the tool probed with -V=full, is always written to [os.Stdout].
+func WithStdout(w io.Writer) Option {
+	return func(c *config) {
+		c.stdout = w
+	}
+}
+
+// WithStderr forwards the standard error of the tools run by [Process], like the diagnostics
+// of the compiler, to w rather than to [os.Stderr]. The warnings reported by the modifiers
+// with [ModifyContext.Warnf] are written to w as well.
+func WithStderr(w io.Writer) Option {
+	return func(c *config) {
+		c.stderr = w
+	}
+}
+
+// WithCPUProfile profiles the CPU usage of the modification of every compiled package
+// with [runtime/pprof], writing the profiles to dir, named after the import paths of the packages,
+// e.g. dir/example.com_app_server.cpu.pprof. The profiles can be explored with `go tool pprof`.
+// The go command runs the compiler in the directory of every package, so dir should be absolute.
+func WithCPUProfile(dir string) Option {
+	return func(c *config) {
+		c.cpuProfileDir = dir
+	}
+}
+
+// WithTimeout aborts the run of [Process] if it takes longer than d, with an error naming
+// the stage that was in flight, like a hung `go list` or a modifier that never returns.
+// The timeout covers the whole run of a single tool, including the tool itself.
+// See [ProcessContext] for what happens on expiry.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// WithResolverMap adds the names of packages, keyed by their import paths, to the ones
+// the decorator and restorer use to manage the imports of the modified files.
+//
+// By default, the names of the packages imported by the package being compiled are loaded
+// with `go list`, and the names of other packages, e.g. the ones only imported by the injected
+// code, are guessed from their import paths. The extra names take precedence over both,
+// which helps with packages whose names differ from their paths, like forks or packages
+// with major version suffixes. They are ignored if the resolver is replaced with [WithResolver].
+func WithResolverMap(extra map[string]string) Option {
+	return func(c *config) {
+		c.resolverMap = extra
+	}
+}
+
+// WithRuntimeImport registers a support package of the injected code, like the runtime
+// of a tracing or metrics library, which the project may not depend on otherwise.
+//
+// The name of the package is known to the restorer, so the imports it adds for the package
+// are always correct, like with [WithResolverMap], which takes precedence over it.
+// The package is also added to importcfg before the files of every modified package are
+// modified, so a package that can not be resolved fails the build right away.
+// Like all the packages imported by the injected code, it must be resolvable with `go list`
+// in the module being built, e.g. required in its go.mod.
+func WithRuntimeImport(importPath string, name string) Option {
+	return func(c *config) {
+		c.runtimeImports = append(c.runtimeImports, runtimeImport{path: importPath, name: name})
+	}
+}
+
+// runtimeImport is a support package registered with [WithRuntimeImport].
+type runtimeImport struct {
+	path string
+	name string
+}
+
+// WithResolver replaces the resolver the decorator and restorer use to manage the imports
+// of the modified files. The packages of the compiled package are then not loaded with `go list`,
+// and [WithResolverMap] has no effect, so the resolver must know the names of all the packages
+// the modified files import.
+func WithResolver(resolver guess.RestorerResolver) Option {
+	return func(c *config) {
+		c.restorerResolver = resolver
+	}
+}
+
+// WithResolveRetries retries the calls to the go command that locate packages, like
+// the ones of [PackageResolver], up to n times if they fail. This helps with failures
+// caused by contention on large monorepos or networked filesystems, like a module
+// cache locked by another process.
+//
+// The first retry happens after backoff, and the delay doubles with every next one.
+// Failures that would not go away on their own, like a missing package, are not retried.
+// Each retry is reported via the logger.
+func WithResolveRetries(n int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.resolveRetries = n
+		c.resolveBackoff = backoff
+	}
+}
+
+// WithLineDirectives controls how positions in the modified files are mapped
+// back to the original source. See [LineDirectives] for the available modes.
+func WithLineDirectives(mode LineDirectives) Option {
+	return func(c *config) {
+		c.lineDirectives = mode
+	}
+}
+
+// WithDirectiveValidation validates `//go:` directives injected by the modifier against
+// the grammar of the known directives. See [DirectiveValidation] for the available modes.
+func WithDirectiveValidation(mode DirectiveValidation) Option {
+	return func(c *config) {
+		c.directiveValidation = mode
+	}
+}
+
+// WithIdentPrefix sets the prefix of identifiers injected by the helpers of this package
+// and returned by [ModifyContext.Ident]. It defaults to [DefaultIdentPrefix].
+// The build fails if a file handed to the modifier already declares or uses an identifier
+// with this prefix. The files compiled as is are not checked, and neither are the ones
+// stamped with [MarkModified] by an earlier preprocessor, which carry its injected identifiers.
+func WithIdentPrefix(prefix string) Option {
+	return func(c *config) {
+		c.identPrefix = prefix
+	}
+}
+
+// WithFeatureFlagsFile bakes feature flag defaults from the given JSON file into
+// package-level variables at build time. See [WithFeatureFlagsEnv] for the format.
+func WithFeatureFlagsFile(path string) Option {
+	return func(c *config) {
+		c.featureFlagsFile = path
+	}
+}
+
+// WithFeatureFlagsEnv bakes feature flag defaults from the given environment variable
+// into package-level variables at build time.
+//
+// The value is a JSON object mapping `<import path>.<variable name>` to a boolean,
+// number or string, e.g. {"example.com/app/config.EnableCache": true}.
+// Initializers of the matching variables, like `var EnableCache = false`,
+// are replaced with the configured values. If both the file and the environment
+// variable are given, values from the environment variable take precedence.
+//
+// The values are baked into every project file declaring the variables, including the files
+// and packages the modifier does not modify, e.g. the ones left out with [WithPackages].
+//
+// The flags are part of the build cache key, so changing them recompiles the project.
+func WithFeatureFlagsEnv(name string) Option {
+	return func(c *config) {
+		c.featureFlagsEnv = name
+	}
+}
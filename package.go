@@ -0,0 +1,175 @@
+package goinject
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/guess"
+)
+
+// PackageModifier is an extension of [Modifier] for modifiers that need to see
+// several files of a package at once, e.g. to collect declarations from one file
+// and generate code in another.
+//
+// If the modifier passed to [Process] implements PackageModifier,
+// ModifyPackage is called instead of Modify.
+type PackageModifier interface {
+	Modifier
+	ModifyPackage(files []*PackageFile)
+}
+
+// PackageFilesModifier is a simpler form of [PackageModifier] for modifiers that only need
+// the syntax of the files, e.g. to find the types declared in sibling files
+// or to avoid declaring duplicate init functions.
+//
+// ModifyPackage receives every file of the package at once and returns the modified files
+// in the same order. It is subject to the same options as [PackageModifier].
+type PackageFilesModifier interface {
+	Modifier
+	ModifyPackage(files []*dst.File) []*dst.File
+}
+
+// isPackageModifier reports whether the modifier modifies all the files of a package at once.
+func isPackageModifier(modifier Modifier) bool {
+	switch modifier.(type) {
+	case PackageModifier, PackageFilesModifier:
+		return true
+	default:
+		return false
+	}
+}
+
+// modifyPackageFiles passes the files to the package modifier.
+func modifyPackageFiles(modifier Modifier, files []*PackageFile) error {
+	switch modifier := modifier.(type) {
+	case PackageModifier:
+		modifier.ModifyPackage(files)
+	case PackageFilesModifier:
+		dstFiles := make([]*dst.File, len(files))
+		for idx, file := range files {
+			dstFiles[idx] = file.File
+		}
+
+		dstFiles = modifier.ModifyPackage(dstFiles)
+		if len(dstFiles) != len(files) {
+			return fmt.Errorf("modifier %T returned %d files for the %d files of the package", modifier, len(dstFiles), len(files))
+		}
+		for idx, file := range files {
+			file.File = dstFiles[idx]
+		}
+	}
+
+	return nil
+}
+
+// PackageFile is a decorated file of the package being modified.
+// A modifier may change File in place or replace it altogether.
+type PackageFile struct {
+	Context   *ModifyContext
+	File      *dst.File
+	Decorator *decorator.Decorator
+	Restorer  *decorator.Restorer
+
+	astFile  *ast.File
+	resolver guess.RestorerResolver
+}
+
+// modifyPackage passes the files to the package modifier and returns the paths to the modified
+// files by the paths of the original ones.
+//
+// By default all the files are passed in a single call. With [WithPackageWindow], the files are
+// passed in consecutive windows of at most the given size. The files of a window are written
+// and released before the next window is decorated, so the memory held at once is bounded by
+// the window rather than by the size of the package.
+func modifyPackage(config *config, unit *compileUnit, paths []string, modifier Modifier) (map[string]string, []error) {
+	modified := make(map[string]string)
+	var errs []error
+
+	window := config.packageWindow
+	if window <= 0 {
+		window = len(paths)
+	}
+
+	for start := 0; start < len(paths); start += window {
+		end := min(start+window, len(paths))
+
+		var files []*PackageFile
+		for _, path := range paths[start:end] {
+			file, err := decorateFile(config, unit, path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("modifying %s: %w", path, err))
+				if !config.collectErrors {
+					return nil, errs
+				}
+				continue
+			}
+			if !config.fileFilterMatch(file) {
+				continue
+			}
+			if err := unit.checkIdentPrefix(path); err != nil {
+				errs = append(errs, err)
+				if !config.collectErrors {
+					return nil, errs
+				}
+				continue
+			}
+			files = append(files, file)
+		}
+
+		if len(files) == 0 {
+			continue
+		}
+
+		err := config.recovering(files[0].Context, func() error {
+			restoreSkipped := make([]func(*dst.File), len(files))
+			for idx, file := range files {
+				restoreSkipped[idx] = protectSkippedFuncs(file.File)
+			}
+
+			if err := modifyPackageFiles(modifier, files); err != nil {
+				return err
+			}
+			for idx, file := range files {
+				if file.File == nil {
+					return fmt.Errorf("modifier %T set nil file for %s", modifier, file.Context.Path)
+				}
+				restoreSkipped[idx](file.File)
+			}
+			return nil
+		})
+		if errors.Is(err, errRecovered) {
+			for _, file := range files {
+				modified[file.Context.Path] = file.Context.Path
+			}
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if !config.collectErrors {
+				return nil, errs
+			}
+			continue
+		}
+
+		for _, file := range files {
+			if unit.isDropped(file.Context.Path) {
+				continue
+			}
+
+			newPath, err := completeFile(config, unit, file, modifier)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("modifying %s: %w", file.Context.Path, err))
+				if !config.collectErrors {
+					return nil, errs
+				}
+				continue
+			}
+			modified[file.Context.Path] = newPath
+		}
+	}
+
+	return modified, errs
+}
@@ -0,0 +1,227 @@
+package goinject
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// typeListModifier declares the names of the types of the whole package in the file declaring main.
+type typeListModifier struct {
+	// drop is the number of files to leave out of the result.
+	drop int
+}
+
+func (m typeListModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (m typeListModifier) ModifyPackage(files []*dst.File) []*dst.File {
+	var names []string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if genDecl, ok := decl.(*dst.GenDecl); ok && genDecl.Tok == token.TYPE {
+				names = append(names, genDecl.Specs[0].(*dst.TypeSpec).Name.Name)
+			}
+		}
+	}
+
+	for _, f := range files {
+		if !slices.ContainsFunc(f.Decls, func(decl dst.Decl) bool {
+			fn, ok := decl.(*dst.FuncDecl)
+			return ok && fn.Name.Name == "main"
+		}) {
+			continue
+		}
+		f.Decls = append(f.Decls, &dst.GenDecl{Tok: token.CONST, Specs: []dst.Spec{&dst.ValueSpec{
+			Names:  []*dst.Ident{dst.NewIdent("typeNames")},
+			Values: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: `"` + strings.Join(names, ",") + `"`}},
+		}}})
+	}
+
+	return files[:len(files)-m.drop]
+}
+
+func TestPackageFilesModifier(t *testing.T) {
+	config, unit := testUnit(t, map[string]string{
+		"a.go":    "package main\n\ntype A struct{}\n",
+		"b.go":    "package main\n\ntype B int\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	unit.importcfg = filepath.Join(t.TempDir(), "importcfg")
+	if err := os.WriteFile(unit.importcfg, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	modified, errs := modifyPackage(config, unit, unit.goFiles, typeListModifier{})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	content, err := os.ReadFile(modified[unit.goFiles[2]])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `const typeNames = "A,B"`; !strings.Contains(string(content), want) {
+		t.Errorf("modified main.go does not contain %s:\n%s", want, content)
+	}
+
+	// Each file must be returned, since the results are matched to the files by their order.
+	if _, errs := modifyPackage(config, unit, unit.goFiles, typeListModifier{drop: 1}); len(errs) == 0 {
+		t.Error("got no error for a file missing from the result")
+	}
+}
+
+// windowModifier renames the functions of each window after the first file of the window,
+// and records the sizes of the windows.
+type windowModifier struct {
+	t       *testing.T
+	unit    *compileUnit
+	windows []int
+	// written are the modified files of the previous windows.
+	written []string
+}
+
+func (m *windowModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (m *windowModifier) ModifyPackage(files []*PackageFile) {
+	// The files of the previous windows are written before this one is decorated.
+	for _, path := range m.written {
+		if _, err := os.Stat(path); err != nil {
+			m.t.Errorf("file of a previous window was not written: %s", err)
+		}
+	}
+
+	m.windows = append(m.windows, len(files))
+	first := strings.TrimSuffix(filepath.Base(files[0].Context.Path), ".go")
+	for _, file := range files {
+		for _, decl := range file.File.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok {
+				fn.Name.Name += "_" + first
+			}
+		}
+		m.written = append(m.written, m.unit.tmpPath(file.Context.Path))
+	}
+}
+
+func TestPackageWindow(t *testing.T) {
+	files := make(map[string]string)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		files[name+".go"] = "package main\n\nfunc " + name + "() {}\n"
+	}
+
+	tests := []struct {
+		name    string
+		window  int
+		windows []int
+		// want are the names of the functions of the files, in the order of the files.
+		want []string
+	}{
+		{
+			name:    "whole package",
+			windows: []int{5},
+			want:    []string{"a_a", "b_a", "c_a", "d_a", "e_a"},
+		},
+		{
+			name:    "windows",
+			window:  2,
+			windows: []int{2, 2, 1},
+			want:    []string{"a_a", "b_a", "c_c", "d_c", "e_e"},
+		},
+		{
+			name:    "larger than the package",
+			window:  10,
+			windows: []int{5},
+			want:    []string{"a_a", "b_a", "c_a", "d_a", "e_a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, unit := testUnit(t, files)
+			WithPackageWindow(tt.window)(config)
+			unit.importcfg = filepath.Join(t.TempDir(), "importcfg")
+			if err := os.WriteFile(unit.importcfg, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			modifier := &windowModifier{t: t, unit: unit}
+			modified, errs := modifyPackage(config, unit, unit.goFiles, modifier)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if !slices.Equal(modifier.windows, tt.windows) {
+				t.Errorf("got windows of %v files, want %v", modifier.windows, tt.windows)
+			}
+
+			for idx, path := range unit.goFiles {
+				content, err := os.ReadFile(modified[path])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := "func " + tt.want[idx] + "()"; !strings.Contains(string(content), want) {
+					t.Errorf("modified %s does not contain %s:\n%s", filepath.Base(path), want, content)
+				}
+			}
+		})
+	}
+}
+
+// groupsModifier records the base names of the files of every call of ModifyPackage.
+type groupsModifier struct {
+	groups [][]string
+}
+
+func (m *groupsModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (m *groupsModifier) ModifyPackage(files []*PackageFile) {
+	var group []string
+	for _, file := range files {
+		group = append(group, filepath.Base(filepath.Dir(file.Context.Path))+"/"+filepath.Base(file.Context.Path))
+	}
+	m.groups = append(m.groups, group)
+}
+
+func TestProcessGroupsFilesByPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"a/a1.go":   "package a\n\nfunc A1() {}\n",
+		"b/b.go":    "package b\n\nfunc B() {}\n",
+		"a/a2.go":   "package a\n\nfunc A2() {}\n",
+		"importcfg": "",
+	})
+	// The package outside of the project is compiled as is.
+	outside := filepath.Join(t.TempDir(), "lib", "lib.go")
+	writeFiles(t, filepath.Dir(outside), map[string]string{"lib.go": "package lib\n"})
+
+	files := []string{filepath.Join(dir, "a", "a1.go"), filepath.Join(dir, "b", "b.go"), outside, filepath.Join(dir, "a", "a2.go")}
+	tool, argsFile := fakeCompiler(t, 0)
+	args := append([]string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack"}, files...)
+	modifier := &groupsModifier{}
+	if err := runCompile(t, dir, tool, args, modifier); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{"a/a1.go", "a/a2.go"}, {"b/b.go"}}
+	if !slices.EqualFunc(modifier.groups, want, slices.Equal[[]string]) {
+		t.Errorf("got package groups %q, want %q", modifier.groups, want)
+	}
+
+	compiled := compiledArgs(t, argsFile)
+	compiled = compiled[len(compiled)-len(files):]
+	for idx, file := range compiled {
+		if modified := file != files[idx]; modified != (files[idx] != outside) {
+			t.Errorf("compiled %s for %s, modified: %t", file, files[idx], modified)
+		}
+	}
+}
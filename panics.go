@@ -0,0 +1,110 @@
+package goinject
+
+import (
+	"go/token"
+	"strconv"
+
+	"github.com/dave/dst"
+)
+
+// HasRecover reports whether the function calls recover, directly or in any function literal
+// it declares. Recovering through a named function, like `defer handlePanic()`, is not detected.
+//
+// Modifiers may use it to leave alone functions handling their own panics, since an injected
+// deferred call that recovers may consume a panic the function meant to handle itself.
+func HasRecover(decl *dst.FuncDecl) bool {
+	if decl.Body == nil {
+		return false
+	}
+
+	return callsRecover(decl.Body)
+}
+
+// callsRecover reports whether the node calls recover, directly or in any function literal it contains.
+func callsRecover(node dst.Node) bool {
+	found := false
+	dst.Inspect(node, func(node dst.Node) bool {
+		call, ok := node.(*dst.CallExpr)
+		if !ok {
+			return !found
+		}
+
+		if ident, ok := call.Fun.(*dst.Ident); ok && ident.Path == "" && ident.Name == "recover" {
+			found = true
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// PanicObserver describes a user-provided function receiving the panics
+// propagating out of an instrumented function.
+type PanicObserver struct {
+	// Path is the import path of the package providing the observing function.
+	Path string
+	// Observe is the name of the observing function, e.g. `func Observe(name string, v any)`.
+	Observe string
+	// SkipRecovering leaves alone the functions that call recover themselves, see [HasRecover].
+	SkipRecovering bool
+}
+
+// Inject reports the panics propagating out of the function, and lets them propagate further:
+//
+//	func Handle() {
+//		defer func() {
+//			if __goinject_panic := recover(); __goinject_panic != nil {
+//				observe.Observe("Handle", __goinject_panic)
+//				panic(__goinject_panic)
+//			}
+//		}()
+//		...
+//	}
+//
+// The deferred call is the first one of the function, so it runs after all the deferred calls
+// of the function itself. A panic recovered by them is never seen by the observer, and the panic
+// handling of the function stays unchanged. A panic that is not recovered is raised again with
+// the same value, which the runtime reports as a repanic in the crash output.
+//
+// The name of the variable is prefixed as configured with [WithIdentPrefix].
+//
+// Inject reports whether the function was instrumented. Functions without a body are skipped,
+// and so are the ones calling recover if SkipRecovering is set.
+func (p PanicObserver) Inject(ctx *ModifyContext, decl *dst.FuncDecl) bool {
+	if decl.Body == nil {
+		return false
+	}
+
+	if p.SkipRecovering && HasRecover(decl) {
+		return false
+	}
+
+	value := ctx.Ident("panic")
+	observe := &dst.DeferStmt{
+		Call: &dst.CallExpr{
+			Fun: &dst.FuncLit{
+				Type: &dst.FuncType{},
+				Body: &dst.BlockStmt{List: []dst.Stmt{
+					&dst.IfStmt{
+						Init: &dst.AssignStmt{
+							Lhs: []dst.Expr{dst.NewIdent(value)},
+							Tok: token.DEFINE,
+							Rhs: []dst.Expr{&dst.CallExpr{Fun: dst.NewIdent("recover")}},
+						},
+						Cond: &dst.BinaryExpr{X: dst.NewIdent(value), Op: token.NEQ, Y: dst.NewIdent("nil")},
+						Body: &dst.BlockStmt{List: []dst.Stmt{
+							&dst.ExprStmt{X: Call(p.Path, p.Observe, &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcName(decl))}, dst.NewIdent(value))},
+							&dst.ExprStmt{X: &dst.CallExpr{Fun: dst.NewIdent("panic"), Args: []dst.Expr{dst.NewIdent(value)}}},
+						}},
+					},
+				}},
+			},
+		},
+	}
+	observe.Decs.After = dst.EmptyLine
+
+	decl.Body.List = append([]dst.Stmt{observe}, decl.Body.List...)
+
+	return true
+}
@@ -0,0 +1,102 @@
+package goinject
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestHasRecover(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{name: "deferred literal", src: "func f() { defer func() { recover() }() }", want: true},
+		{name: "nested literal", src: "func f() { g := func() { defer func() { _ = recover() }() }; g() }", want: true},
+		{name: "none", src: "func f() { defer println() }"},
+		{name: "named function", src: "func f() { defer handle() }"},
+		{name: "shadowed", src: "func f() { recover := func() {}; recover() }", want: true},
+		{name: "qualified", src: "func f() { errs.recover() }"},
+		{name: "no body", src: "func f()"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := decorator.Parse("package main\n\n" + tt.src + "\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := HasRecover(f.Decls[0].(*dst.FuncDecl)); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPanicObserverInject(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var observed []string
+
+func observe(name string, v any) { observed = append(observed, fmt.Sprint(name, ":", v)) }
+
+func recovering() (err string) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Sprint("recovered ", r)
+		}
+	}()
+	panic("own")
+}
+
+func propagating() { panic("propagated") }
+
+func main() {
+	fmt.Println(recovering())
+	func() {
+		defer func() { fmt.Println("caller recovered", recover()) }()
+		propagating()
+	}()
+	fmt.Println("observed", strings.Join(observed, ","))
+}
+`
+
+	tests := []struct {
+		name         string
+		observer     PanicObserver
+		instrumented []string
+	}{
+		{name: "coordinated", observer: PanicObserver{Observe: "observe"}, instrumented: []string{"observe", "recovering", "propagating", "main"}},
+		{name: "skip recovering", observer: PanicObserver{Observe: "observe", SkipRecovering: true}, instrumented: []string{"observe", "propagating"}},
+	}
+
+	// The panic handling of the program is the same as without the observer,
+	// which only sees the panic nothing recovered inside of the function.
+	const want = "recovered own\ncaller recovered propagated\nobserved propagating:propagated\n"
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instrumented []string
+			got := modifiedSource(t, src, funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				if tt.observer.Inject(ctx, decl) {
+					instrumented = append(instrumented, decl.Name.Name)
+				}
+			}))
+			if !slices.Equal(instrumented, tt.instrumented) {
+				t.Errorf("got instrumented functions %q, want %q", instrumented, tt.instrumented)
+			}
+
+			if out := runProgram(t, got); out != want {
+				t.Errorf("got output:\n%s\nwant:\n%s", out, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package goinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PackageConfig is the configuration of the packages matching a pattern of the file given to [WithConfigFile].
+type PackageConfig struct {
+	// Enabled, if set to false, makes the packages be compiled as is.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Settings are free-form settings of the modifier for the packages, see [ModifyContext.Settings].
+	Settings map[string]any `json:"settings,omitempty"`
+}
+
+// configFile is the content of the file given to [WithConfigFile].
+type configFile struct {
+	// Packages are the configurations of the packages by the patterns of their import paths.
+	Packages map[string]PackageConfig `json:"packages"`
+}
+
+// loadConfigFile reads the file given to [WithConfigFile] and registers it as a cache input.
+func (c *config) loadConfigFile() error {
+	if c.configFile == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(c.configFile)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var parsed configFile
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", c.configFile, err)
+	}
+
+	c.packageConfigs = parsed.Packages
+	c.cacheInputs = append(c.cacheInputs, content)
+
+	return nil
+}
+
+// packageConfig returns the configuration of the package given by the most specific pattern
+// matching its import path. Test packages get the configuration of the package they test.
+// Of the patterns equally specific, the first one in lexical order wins, so the configuration
+// does not depend on the order of the file, let alone on the iteration order of the map.
+func (c *config) packageConfig(pkgPath string) PackageConfig {
+	pkgPath = strings.TrimSuffix(pkgPath, "_test")
+
+	var match string
+	var matched bool
+	for pattern := range c.packageConfigs {
+		if !matchPackagePattern(pattern, pkgPath) {
+			continue
+		}
+
+		// The longer the literal prefix of a pattern, the more specific it is,
+		// and an exact pattern is more specific than a wildcard one with the same prefix.
+		prefix, exact := literalPrefix(pattern)
+		matchPrefix, matchExact := literalPrefix(match)
+		switch {
+		case !matched, len(prefix) > len(matchPrefix):
+		case len(prefix) < len(matchPrefix):
+			continue
+		case exact != matchExact:
+			if !exact {
+				continue
+			}
+		case pattern > match:
+			continue
+		}
+		match, matched = pattern, true
+	}
+
+	return c.packageConfigs[match]
+}
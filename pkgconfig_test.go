@@ -0,0 +1,72 @@
+package goinject
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestProcessConfigFile(t *testing.T) {
+	config := `{
+	"packages": {
+		"example.com/app/...": {"settings": {"sampleRate": 0.1}},
+		"example.com/app/payments/...": {"settings": {"sampleRate": 1}},
+		"example.com/app/legacy": {"enabled": false},
+		"example.com/app/legacy/...": {"enabled": true}
+	}
+}
+`
+
+	tests := []struct {
+		pkgPath string
+		// wantSettings are the settings the modifier gets, nil if the package is compiled as is.
+		wantSettings map[string]any
+	}{
+		{pkgPath: "example.com/app", wantSettings: map[string]any{"sampleRate": 0.1}},
+		{pkgPath: "example.com/app/payments/card", wantSettings: map[string]any{"sampleRate": 1.0}},
+		{pkgPath: "example.com/app/payments_test", wantSettings: map[string]any{"sampleRate": 1.0}},
+		{pkgPath: "example.com/app/legacy"},
+		{pkgPath: "example.com/app/legacy_test"},
+		// An enabled configuration has no settings of its own.
+		{pkgPath: "example.com/app/legacy/v2", wantSettings: map[string]any{}},
+		// The packages matching no pattern are modified without settings.
+		{pkgPath: "example.com/other", wantSettings: map[string]any{}},
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/app\n\ngo 1.22\n",
+		"main.go":     "package main\n\nfunc main() {}\n",
+		"config.json": config,
+		"importcfg":   "",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.pkgPath, func(t *testing.T) {
+			var settings map[string]any
+			modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				settings = map[string]any{}
+				for key, value := range ctx.Settings {
+					settings[key] = value
+				}
+			})
+
+			tool, argsFile := fakeCompiler(t, 0)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", tt.pkgPath, "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			if err := runCompile(t, dir, tool, args, modifier, WithConfigFile(filepath.Join(dir, "config.json"))); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(settings, tt.wantSettings) {
+				t.Errorf("modifier got settings %v, want %v", settings, tt.wantSettings)
+			}
+			compiled := compiledArgs(t, argsFile)
+			if disabled := compiled[len(compiled)-1] == mainFile; disabled != (tt.wantSettings == nil) {
+				t.Errorf("compiled %s as is: %t, want %t", mainFile, disabled, tt.wantSettings == nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package goinject
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// packagePatterns caches the regular expressions of the package patterns by the patterns.
+var packagePatterns sync.Map
+
+// matchPackagePattern reports whether the import path matches the pattern, see [WithPackages].
+func matchPackagePattern(pattern string, pkgPath string) bool {
+	re, ok := packagePatterns.Load(pattern)
+	if !ok {
+		re, _ = packagePatterns.LoadOrStore(pattern, packagePatternRegexp(pattern))
+	}
+
+	return re.(*regexp.Regexp).MatchString(pkgPath)
+}
+
+// packagePatternRegexp translates the package pattern to a regular expression.
+func packagePatternRegexp(pattern string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("^")
+
+	rest := pattern
+	if trimmed, ok := strings.CutPrefix(rest, "**/"); ok {
+		re.WriteString("(.*/)?")
+		rest = trimmed
+	}
+
+	var suffix string
+	for _, wildcard := range []string{"/...", "/**"} {
+		if trimmed, ok := strings.CutSuffix(rest, wildcard); ok {
+			suffix = "(/.*)?"
+			rest = trimmed
+			break
+		}
+	}
+
+	for len(rest) > 0 {
+		switch {
+		case strings.HasPrefix(rest, "..."):
+			re.WriteString(".*")
+			rest = rest[3:]
+		case strings.HasPrefix(rest, "**"):
+			re.WriteString(".*")
+			rest = rest[2:]
+		case rest[0] == '*':
+			re.WriteString("[^/]*")
+			rest = rest[1:]
+		default:
+			re.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+
+	re.WriteString(suffix)
+	re.WriteString("$")
+
+	return regexp.MustCompile(re.String())
+}
+
+// literalPrefix returns the part of the package pattern before its first wildcard,
+// and whether the pattern has no wildcards at all.
+func literalPrefix(pattern string) (string, bool) {
+	idx := strings.Index(pattern, "...")
+	if star := strings.IndexByte(pattern, '*'); star >= 0 && (idx < 0 || star < idx) {
+		idx = star
+	}
+
+	if idx < 0 {
+		return pattern, true
+	}
+
+	return strings.TrimSuffix(pattern[:idx], "/"), false
+}
+
+// dependencySelected reports whether the package is a dependency selected for modification
+// with [WithDependencyModules]. Test packages are selected along with the package they test.
+func (c *config) dependencySelected(pkgPath string) bool {
+	pkgPath = strings.TrimSuffix(pkgPath, "_test")
+
+	return slices.ContainsFunc(c.dependencyModules, func(pattern string) bool {
+		return matchPackagePattern(pattern, pkgPath)
+	})
+}
+
+// packageSelected reports whether the package is selected by the patterns given to [WithPackages].
+// Test packages are selected along with the package they test.
+func (c *config) packageSelected(pkgPath string) bool {
+	pkgPath = strings.TrimSuffix(pkgPath, "_test")
+
+	included := len(c.includePackages) == 0
+	for _, pattern := range c.includePackages {
+		if matchPackagePattern(pattern, pkgPath) {
+			included = true
+			break
+		}
+	}
+
+	for _, pattern := range c.excludePackages {
+		if matchPackagePattern(pattern, pkgPath) {
+			return false
+		}
+	}
+
+	return included
+}
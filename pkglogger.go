@@ -0,0 +1,99 @@
+package goinject
+
+import (
+	"go/types"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// LoggerNames are the conventional names of package-level logger variables
+// recognized by [FindPackageLogger].
+var LoggerNames = []string{"log", "logger", "Log", "Logger"}
+
+// PackageLogger describes how injected code should log from within a package.
+//
+// If the package already declares a logger variable, injected calls go through it,
+// so no new logging package is imported into the modified code.
+// Otherwise the fallback function is called instead.
+type PackageLogger struct {
+	// Var is the name of the package-level logger variable.
+	// Leave it empty to always use the fallback function.
+	Var string
+	// Method is the method called on Var, e.g. "Printf".
+	Method string
+
+	// FallbackPath and FallbackFunc name the function called when
+	// the package has no logger of its own, e.g. "log" and "Printf".
+	FallbackPath string
+	FallbackFunc string
+}
+
+// Call builds a logging call with the given arguments.
+// It references the package logger when there is one, and the
+// fallback function otherwise. In the latter case [decorator.Restorer]
+// will add the import of FallbackPath automatically.
+func (l PackageLogger) Call(args ...dst.Expr) *dst.CallExpr {
+	if l.Var != "" {
+		return &dst.CallExpr{
+			Fun:  &dst.SelectorExpr{X: dst.NewIdent(l.Var), Sel: dst.NewIdent(l.Method)},
+			Args: args,
+		}
+	}
+
+	return &dst.CallExpr{
+		Fun:  &dst.Ident{Path: l.FallbackPath, Name: l.FallbackFunc},
+		Args: args,
+	}
+}
+
+// FindPackageLogger looks for a package-level logger variable in the package
+// of the file being modified and returns its name.
+//
+// A variable is considered a logger if its name is one of [LoggerNames],
+// or if its type is named like a logger, e.g. *log.Logger or *slog.Logger.
+// In both cases the method set of its type must have the given method, e.g. "Printf",
+// so a variable named log of an unrelated type is not mistaken for a logger.
+// Variables named like a logger take precedence, in the order of [LoggerNames].
+//
+// It reports false if the package has no logger, or if it failed to type-check,
+// in which case [PackageLogger] falls back to its fallback function.
+func FindPackageLogger(ctx *ModifyContext, method string) (string, bool) {
+	pkg, err := ctx.TypesPackage()
+	if err != nil {
+		return "", false
+	}
+
+	hasMethod := func(v *types.Var) bool {
+		obj, _, _ := types.LookupFieldOrMethod(v.Type(), true, pkg, method)
+		_, ok := obj.(*types.Func)
+		return ok
+	}
+
+	scope := pkg.Scope()
+	for _, name := range LoggerNames {
+		if v, ok := scope.Lookup(name).(*types.Var); ok && hasMethod(v) {
+			return name, true
+		}
+	}
+
+	// The names of the scope are sorted, so the choice among the loggers is stable.
+	for _, name := range scope.Names() {
+		if v, ok := scope.Lookup(name).(*types.Var); ok && isLoggerType(v.Type()) && hasMethod(v) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// isLoggerType reports whether the type, or the type it points to, is a named type called like a logger,
+// like *log.Logger, *slog.Logger, zerolog.Logger or logrus.FieldLogger.
+func isLoggerType(typ types.Type) bool {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	named, ok := typ.(*types.Named)
+
+	return ok && strings.HasSuffix(named.Obj().Name(), "Logger")
+}
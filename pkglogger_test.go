@@ -0,0 +1,102 @@
+package goinject
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// loggingModifier appends a call to the logger of the package to the main function.
+type loggingModifier struct {
+	logger PackageLogger
+}
+
+func (m loggingModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return f
+}
+
+func (m loggingModifier) ModifyWithContext(ctx *ModifyContext, f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	logger := m.logger
+	logger.Var, _ = FindPackageLogger(ctx, logger.Method)
+
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == "main" {
+			call := logger.Call(&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote("main")})
+			fn.Body.List = append(fn.Body.List, &dst.ExprStmt{X: call})
+		}
+	}
+
+	return f
+}
+
+func TestFindPackageLogger(t *testing.T) {
+	const loggerType = "type Logger struct{}\n\nfunc (*Logger) Printf(format string, args ...any) {}\n\n"
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "by name",
+			src:  loggerType + "var log = &Logger{}\n",
+			want: "log",
+		},
+		{
+			name: "by type",
+			src:  loggerType + "var std *Logger\n",
+			want: "std",
+		},
+		{
+			// The name alone does not make a logger of a variable without the method.
+			name: "without method",
+			src:  loggerType + "var log = 1\n\nvar std Logger\n",
+			want: "std",
+		},
+		{
+			name: "none",
+			src:  "var log = 1\n",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, unit := testUnit(t, map[string]string{"main.go": "package main\n\n" + tt.src + "\nfunc main() {}\n"})
+			unit.importcfg = filepath.Join(t.TempDir(), "importcfg")
+			if err := os.WriteFile(unit.importcfg, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			modifier := loggingModifier{logger: PackageLogger{Method: "Printf", FallbackPath: "log", FallbackFunc: "Printf"}}
+			file := modifiedFile(t, config, unit, unit.goFiles[0], modifier)
+			path, imports, err := processFile(config, unit, file, modifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == "" {
+				if got := importPaths(imports); len(got) != 1 || got[0] != "log" {
+					t.Errorf("got imports %q, want the fallback log", got)
+				}
+				return
+			}
+			if len(imports) != 0 {
+				t.Errorf("got imports %q, want none", importPaths(imports))
+			}
+			if call := tt.want + ".Printf(\"main\")"; !strings.Contains(string(content), call) {
+				t.Errorf("modified file does not call %s:\n%s", call, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !((darwin || freebsd || linux) && cgo)
+
+package goinject
+
+// loadModifierPlugin reports that Go plugins are not supported on this platform,
+// or without cgo, see [WithModifierPlugin].
+func loadModifierPlugin(path string) (Modifier, error) {
+	return nil, errPluginUnsupported
+}
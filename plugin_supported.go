@@ -0,0 +1,33 @@
+//go:build (darwin || freebsd || linux) && cgo
+
+package goinject
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// loadModifierPlugin opens the Go plugin at path and returns the modifier
+// it exports as the Modifier symbol, see [WithModifierPlugin].
+func loadModifierPlugin(path string) (Modifier, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening modifier plugin: %w", err)
+	}
+
+	sym, err := p.Lookup("Modifier")
+	if err != nil {
+		return nil, fmt.Errorf("looking up modifier of plugin %s: %w", path, err)
+	}
+
+	// Lookup returns a pointer to the exported variable, which is either
+	// declared as a Modifier or has a type implementing it.
+	switch modifier := sym.(type) {
+	case *Modifier:
+		return *modifier, nil
+	case Modifier:
+		return modifier, nil
+	}
+
+	return nil, fmt.Errorf("symbol Modifier of plugin %s is %T, which does not implement goinject.Modifier", path, sym)
+}
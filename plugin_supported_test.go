@@ -0,0 +1,117 @@
+//go:build (darwin || freebsd || linux) && cgo
+
+package goinject
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// buildModifierPlugin builds a Go plugin exporting a modifier that appends a call to injected
+// to every function. It only depends on dst, which it shares with the test binary.
+func buildModifierPlugin(t *testing.T) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("building a plugin in short mode")
+	}
+
+	// The plugin requires the versions of the modules the test binary was built with,
+	// since the packages shared by both must be identical.
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		t.Skip("versions of the dependencies are unknown")
+	}
+	goMod := "module example.com/plugin\n\ngo 1.22\n"
+	for _, dep := range info.Deps {
+		goMod += "\nrequire " + dep.Path + " " + dep.Version
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": goMod + "\n",
+		"plugin.go": `package main
+
+import (
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+type modifier struct{}
+
+func (modifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Body != nil {
+			fn.Body.List = append(fn.Body.List, &dst.ExprStmt{X: &dst.CallExpr{Fun: dst.NewIdent("injected")}})
+		}
+	}
+	return f
+}
+
+var Modifier modifier
+`,
+	})
+
+	path := filepath.Join(dir, "modifier.so")
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", path, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("building the plugin: %s\n%s", err, out)
+	}
+
+	return path
+}
+
+// modifierPluginEnv is the path to the plugin the test binary loads when it runs
+// [TestProcessModifierPlugin] in a process of its own.
+const modifierPluginEnv = "GOINJECT_TEST_MODIFIER_PLUGIN"
+
+func TestProcessModifierPlugin(t *testing.T) {
+	pluginPath := os.Getenv(modifierPluginEnv)
+	if pluginPath == "" {
+		// A loaded plugin can not be unloaded, and it breaks the type switches of dst
+		// in the other tests, so it is only loaded by a test binary of its own.
+		cmd := exec.Command(os.Args[0], "-test.run=^TestProcessModifierPlugin$", "-test.v")
+		cmd.Env = append(os.Environ(), modifierPluginEnv+"="+buildModifierPlugin(t))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s\n%s", err, out)
+		}
+		return
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+
+	// The built-in modifier is replaced by the one of the plugin.
+	sources := compiledSources(t, dir, []string{"main.go"}, appendCall("main", "", "builtin"), WithModifierPlugin(pluginPath))
+	if got := sources["main.go"]; !strings.Contains(got, "injected()") || strings.Contains(got, "builtin()") {
+		t.Errorf("got source:\n%s\nwant the call injected by the plugin only", got)
+	}
+}
+
+func TestProcessModifierPluginMissing(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+	if err := runCompile(t, dir, tool, args, identity, WithModifierPlugin(filepath.Join(dir, "missing.so"))); err == nil {
+		t.Fatal("missing plugin did not fail the build")
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Error("compiler was run without the modifier of the plugin")
+	}
+}
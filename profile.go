@@ -0,0 +1,143 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// profile collects the counters of a single run of [Process] written with [WithProfile].
+// A nil profile collects nothing, so the counters may be updated unconditionally.
+type profile struct {
+	// files is the number of the modified files.
+	files atomic.Int64
+	// bytesWritten is the total size of the modified files.
+	bytesWritten atomic.Int64
+	// goCommands is the number of the go command subprocesses, including the ones of packages.Load.
+	goCommands atomic.Int64
+	// packageLoads is the number of the packages.Load calls.
+	packageLoads atomic.Int64
+
+	mu         sync.Mutex
+	phases     []profilePhase
+	phaseStart time.Time
+}
+
+// profilePhase is the time spent in a stage of the run.
+type profilePhase struct {
+	name     string
+	duration time.Duration
+}
+
+// enter ends the current phase and starts the one with the name.
+func (p *profile) enter(name string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.endPhase()
+	p.phases = append(p.phases, profilePhase{name: name})
+	p.phaseStart = time.Now()
+}
+
+// endPhase records the duration of the current phase. It must be called with mu held.
+func (p *profile) endPhase() {
+	if len(p.phases) > 0 && !p.phaseStart.IsZero() {
+		p.phases[len(p.phases)-1].duration = time.Since(p.phaseStart)
+		p.phaseStart = time.Time{}
+	}
+}
+
+// recordFile counts the modified file of the given size.
+func (p *profile) recordFile(size int) {
+	if p == nil {
+		return
+	}
+
+	p.files.Add(1)
+	p.bytesWritten.Add(int64(size))
+}
+
+// countGoCommand counts a go command subprocess.
+func (p *profile) countGoCommand() {
+	if p == nil {
+		return
+	}
+
+	p.goCommands.Add(1)
+}
+
+// countPackagesLoad counts a packages.Load call, which runs `go list` under the hood.
+func (p *profile) countPackagesLoad() {
+	if p == nil {
+		return
+	}
+
+	p.packageLoads.Add(1)
+	p.goCommands.Add(1)
+}
+
+// write ends the current phase and writes the summary of the compile unit to w:
+//
+//	goinject profile example.com/app/server: 3 files, 1204 bytes written, 2 go commands, 1 package loads
+//		locating the project    12.1ms
+//		modifying files         48.7ms
+//		compiling               210.3ms
+//
+// cmd/go compiles packages in separate processes, so the summary is written with a single call,
+// which keeps the summaries of concurrent compilations from interleaving in a shared writer.
+func (p *profile) write(w io.Writer, pkgPath string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.endPhase()
+
+	var summary bytes.Buffer
+	fmt.Fprintf(&summary, "goinject profile %s: %d files, %d bytes written, %d go commands, %d package loads\n",
+		pkgPath, p.files.Load(), p.bytesWritten.Load(), p.goCommands.Load(), p.packageLoads.Load())
+	for _, phase := range p.phases {
+		fmt.Fprintf(&summary, "\t%-24s%s\n", phase.name, phase.duration.Round(100*time.Microsecond))
+	}
+
+	_, err := w.Write(summary.Bytes())
+
+	return err
+}
+
+// startCPUProfile starts the CPU profiling of the compile unit into dir, see [WithCPUProfile],
+// and returns the function stopping it, which may be called several times.
+func startCPUProfile(dir string, unit *compileUnit) (stop func() error, err error) {
+	name := strings.ReplaceAll(unit.pkgPath, "/", "_")
+	if unit.hasTestFiles() {
+		name += ".test"
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating CPU profile dir: %w", err)
+	}
+
+	file, err := os.Create(filepath.Join(dir, name+".cpu.pprof"))
+	if err != nil {
+		return nil, fmt.Errorf("creating CPU profile: %w", err)
+	}
+
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("starting CPU profile: %w", err)
+	}
+
+	return sync.OnceValue(func() error {
+		pprof.StopCPUProfile()
+		return file.Close()
+	}), nil
+}
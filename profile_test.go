@@ -0,0 +1,55 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfileSummary(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"a.go":      "package main\n\nfunc a() {}\n",
+		"b.go":      "package main\n\nfunc b() {}\n",
+		"skip.go":   "package main\n\nfunc skip() {}\n",
+		"importcfg": "",
+	})
+
+	var files []string
+	for _, name := range []string{"main.go", "a.go", "b.go", "skip.go"} {
+		files = append(files, filepath.Join(dir, name))
+	}
+	filter := func(path string) bool { return filepath.Base(path) != "skip.go" }
+
+	// The modified files are kept to be measured, in a tmp dir removed along with the test.
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var summary bytes.Buffer
+	tool, argsFile := fakeCompiler(t, 0)
+	args := append([]string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack"}, files...)
+	if err := runCompile(t, dir, tool, args, identity, WithProfile(&summary), WithFileFilter(filter), WithKeepTempFiles()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The summary counts the modified copies the compiler got, and not the file compiled as is.
+	var size int64
+	for _, arg := range compiledArgs(t, argsFile) {
+		if isGoFile(arg) && !strings.HasPrefix(arg, dir) {
+			info, err := os.Stat(arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += info.Size()
+		}
+	}
+
+	want := fmt.Sprintf("goinject profile main: 3 files, %d bytes written, ", size)
+	if !strings.HasPrefix(summary.String(), want) {
+		t.Errorf("got summary:\n%s\nwant it to start with %q", summary.String(), want)
+	}
+}
@@ -0,0 +1,23 @@
+package goinject
+
+import "github.com/dave/dst"
+
+// Qual returns a reference to the name declared by the package with the given import path,
+// like fmt.Println. The restorer prints it qualified with the name the package is imported
+// with in the file, and adds the import if the file does not import the package yet.
+//
+// An identifier built with [dst.NewIdent] instead has no import path, so it is printed
+// unqualified and fails to compile, unless the name is declared by the package being modified.
+// An empty pkgPath refers to such a local name.
+func Qual(pkgPath string, name string) *dst.Ident {
+	return &dst.Ident{Path: pkgPath, Name: name}
+}
+
+// Call returns a call of the function declared by the package with the given import path,
+// like fmt.Println(args...). See [Qual].
+func Call(pkgPath string, funcName string, args ...dst.Expr) *dst.CallExpr {
+	return &dst.CallExpr{
+		Fun:  Qual(pkgPath, funcName),
+		Args: args,
+	}
+}
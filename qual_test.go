@@ -0,0 +1,61 @@
+package goinject
+
+import (
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestQualCall(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		expr dst.Expr
+		// want are the lines expected in the modified file.
+		want []string
+	}{
+		{
+			name: "added import",
+			src:  "package main\n\nfunc main() {}\n",
+			expr: Call("fmt", "Println", &dst.BasicLit{Kind: token.STRING, Value: `"hello"`}),
+			want: []string{`import "fmt"`, `fmt.Println("hello")`},
+		},
+		{
+			name: "existing alias",
+			src:  "package main\n\nimport f \"fmt\"\n\nfunc main() { f.Print() }\n",
+			expr: Call("fmt", "Println"),
+			want: []string{`import f "fmt"`, "f.Println()"},
+		},
+		{
+			name: "qualified value",
+			src:  "package main\n\nfunc main() {}\n",
+			expr: Call("fmt", "Println", Qual("os", "Args")),
+			want: []string{`"fmt"`, `"os"`, "fmt.Println(os.Args)"},
+		},
+		{
+			// A local name is not qualified, and needs no import.
+			name: "local",
+			src:  "package main\n\nfunc main() {}\n\nfunc local() {}\n",
+			expr: Call("", "local"),
+			want: []string{"package main\n\nfunc main() { local() }"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := modifiedSource(t, tt.src, funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				if decl.Name.Name == "main" {
+					decl.Body.List = append(decl.Body.List, &dst.ExprStmt{X: dst.Clone(tt.expr).(dst.Expr)})
+				}
+			}))
+
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("modified file does not contain %s:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package goinject
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// nilModifier is a buggy modifier returning nil instead of the file.
+type nilModifier struct{}
+
+func (nilModifier) Modify(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+	return nil
+}
+
+func TestProcessFailingModifier(t *testing.T) {
+	panicking := ModifierFunc(func(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer) *dst.File {
+		panic("boom")
+	})
+
+	tests := []struct {
+		name     string
+		modifier Modifier
+		opts     []Option
+		// want is a part of the error, or of the warning if the failure is recovered.
+		want    string
+		wantErr bool
+	}{
+		{
+			name:     "nil",
+			modifier: nilModifier{},
+			want:     "modifier goinject.nilModifier returned nil",
+			wantErr:  true,
+		},
+		{
+			name:     "nil in chain",
+			modifier: Chain(identity, nilModifier{}),
+			want:     "modifier goinject.nilModifier returned nil",
+			wantErr:  true,
+		},
+		{
+			name:     "nil recovered",
+			modifier: nilModifier{},
+			opts:     []Option{WithRecover()},
+			want:     "modifier failed, compiling as is: modifier goinject.nilModifier returned nil",
+		},
+		{
+			name:     "panic recovered",
+			modifier: panicking,
+			opts:     []Option{WithRecover()},
+			want:     "modifier failed, compiling as is: modifier panicked: boom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			var stderr bytes.Buffer
+			tool, argsFile := fakeCompiler(t, 0)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			err := runCompile(t, dir, tool, args, tt.modifier, append(tt.opts, WithStderr(&stderr))...)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("failing modifier did not fail the build")
+				}
+				if msg := err.Error(); !strings.Contains(msg, mainFile) || !strings.Contains(msg, tt.want) {
+					t.Errorf("got error %q, want %q naming %s", msg, tt.want, mainFile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The original file is compiled, and the failure is reported against it.
+			compiled := compiledArgs(t, argsFile)
+			if file := compiled[len(compiled)-1]; file != mainFile {
+				t.Errorf("compiled %s, want the original %s", file, mainFile)
+			}
+			if !strings.Contains(stderr.String(), mainFile) || !strings.Contains(stderr.String(), tt.want) {
+				t.Errorf("got stderr %q, want a warning %q against %s", stderr.String(), tt.want, mainFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package goinject
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// report is the content of the file written with [WithReport].
+type report struct {
+	Units []reportUnit
+}
+
+// reportUnit describes the modifications of a single compile unit.
+type reportUnit struct {
+	// Package is the import path of the package.
+	Package string
+	// Test reports whether the unit is the package compiled with its _test.go files.
+	Test bool
+	// Files are the modified files of the package.
+	Files []reportFile
+	// ImportcfgAdditions are the packages added to the importcfg file.
+	ImportcfgAdditions []ImportcfgEntry
+	// Duration is the time the unit took to be modified and compiled, in nanoseconds.
+	Duration time.Duration
+	// Error is the error the unit failed with, if any.
+	Error string `json:",omitempty"`
+}
+
+// reportFile describes a modified file.
+type reportFile struct {
+	// Original is the path to the original file.
+	Original string
+	// Modified is the path to the modified copy of the file compiled instead of it.
+	Modified string
+	// AddedImports are the import paths the modified file imports in addition to the original one.
+	AddedImports []string
+}
+
+// recordFile records the modified file for the report.
+func (u *compileUnit) recordFile(file reportFile) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reportFiles = append(u.reportFiles, file)
+}
+
+// hasTestFiles reports whether the unit is the package compiled with its _test.go files.
+func (u *compileUnit) hasTestFiles() bool {
+	return slices.ContainsFunc(u.goFiles, func(file string) bool { return strings.HasSuffix(file, "_test.go") })
+}
+
+// addedImports returns the import paths of the modified file that the original file does not import.
+func addedImports(original []string, modified []string) []string {
+	var added []string
+	for _, path := range modified {
+		if !slices.Contains(original, path) && !slices.Contains(added, path) {
+			added = append(added, path)
+		}
+	}
+
+	return added
+}
+
+// importPaths returns the unquoted paths of the imports.
+func importPaths(specs []*ast.ImportSpec) []string {
+	var paths []string
+	for _, spec := range specs {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// writeReport merges the report of the compile unit into the report file at path.
+//
+// cmd/go compiles packages in separate processes, so the file is shared by all of them:
+// it is updated under a lock, like importcfg, and the unit replaces the previous
+// report of the same package, so the file describes the latest build of every package.
+func writeReport(path string, unit *compileUnit, duration time.Duration, unitErr error) error {
+	entry := reportUnit{
+		Package:            unit.pkgPath,
+		Test:               unit.hasTestFiles(),
+		Files:              unit.reportFiles,
+		ImportcfgAdditions: LastImportcfgAdditions(),
+		Duration:           duration,
+	}
+	if unitErr != nil {
+		entry.Error = unitErr.Error()
+	}
+
+	unlock, err := lockDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("locking report: %w", err)
+	}
+	defer unlock()
+
+	var rep report
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading report: %w", err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &rep); err != nil {
+			return fmt.Errorf("parsing report: %w", err)
+		}
+	}
+
+	rep.Units = slices.DeleteFunc(rep.Units, func(u reportUnit) bool {
+		return u.Package == entry.Package && u.Test == entry.Test
+	})
+	rep.Units = append(rep.Units, entry)
+	slices.SortFunc(rep.Units, func(a, b reportUnit) int {
+		if c := strings.Compare(a.Package, b.Package); c != 0 {
+			return c
+		}
+		if a.Test == b.Test {
+			return 0
+		}
+		if a.Test {
+			return 1
+		}
+		return -1
+	})
+
+	content, err = json.MarshalIndent(rep, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+
+	if err := replaceFile(path, append(content, '\n')); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	return nil
+}
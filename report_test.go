@@ -0,0 +1,78 @@
+package goinject
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProcessReport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":        "module example.com/app\n\ngo 1.22\n",
+		"main.go":       "package main\n\nfunc main() {}\n",
+		"lib/lib.go":    "package lib\n\nfunc Hello() {}\n",
+		"importcfg":     "",
+		"lib/importcfg": "",
+	})
+	t.Cleanup(resetImportcfgAdditions)
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	resolver := func(pkgName string) (map[string]string, error) {
+		return map[string]string{pkgName: "/fake/trace.a"}, nil
+	}
+	opts := []Option{WithReport(reportPath), WithPackageResolver(resolver)}
+	modifier := appendCall("main", "example.com/trace", "Start")
+
+	// Each package is compiled in a process of its own, and main is built twice.
+	for _, pkg := range []struct{ path, file string }{
+		{"main", "main.go"},
+		{"example.com/app/lib", "lib/lib.go"},
+		{"main", "main.go"},
+	} {
+		tool, _ := fakeCompiler(t, 0)
+		importcfg := filepath.Join(dir, filepath.Dir(pkg.file), "importcfg")
+		args := []string{"-p", pkg.path, "-importcfg", importcfg, "-pack", filepath.Join(dir, pkg.file)}
+		if err := runCompile(t, dir, tool, args, modifier, opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep report
+	if err := json.Unmarshal(content, &rep); err != nil {
+		t.Fatalf("parsing report: %s\n%s", err, content)
+	}
+
+	// The units are sorted by package, and the second build of main replaced the first one.
+	if len(rep.Units) != 2 || rep.Units[0].Package != "example.com/app/lib" || rep.Units[1].Package != "main" {
+		t.Fatalf("got report units %+v, want lib and main once each", rep.Units)
+	}
+
+	libUnit, mainUnit := rep.Units[0], rep.Units[1]
+	if len(libUnit.Files) != 1 || libUnit.Files[0].Original != filepath.Join(dir, "lib", "lib.go") || len(libUnit.Files[0].AddedImports) != 0 {
+		t.Errorf("got lib files %+v, want lib.go without added imports", libUnit.Files)
+	}
+	if len(libUnit.ImportcfgAdditions) != 0 {
+		t.Errorf("got lib importcfg additions %v, want none", libUnit.ImportcfgAdditions)
+	}
+
+	if len(mainUnit.Files) != 1 || mainUnit.Files[0].Original != filepath.Join(dir, "main.go") {
+		t.Fatalf("got main files %+v, want main.go", mainUnit.Files)
+	}
+	if file := mainUnit.Files[0]; file.Modified == file.Original || !slices.Equal(file.AddedImports, []string{"example.com/trace"}) {
+		t.Errorf("got main file %+v, want a modified copy importing example.com/trace", file)
+	}
+	// The package was added to importcfg by the first build only.
+	if len(mainUnit.ImportcfgAdditions) != 0 {
+		t.Errorf("got main importcfg additions %v from the second build, want none", mainUnit.ImportcfgAdditions)
+	}
+	if mainUnit.Duration <= 0 || mainUnit.Error != "" {
+		t.Errorf("got duration %s and error %q, want a successful build", mainUnit.Duration, mainUnit.Error)
+	}
+}
@@ -0,0 +1,73 @@
+package goinject
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst/decorator/resolver/guess"
+)
+
+func TestProcessResolverOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// want is the call to the injected package, named after the resolved name of the package.
+		want   string
+		wantGo bool
+	}{
+		{
+			// The name of the package is otherwise guessed from its path.
+			name: "guessed",
+			want: "fork.Run()",
+			// The packages imported by the compiled one are loaded.
+			wantGo: true,
+		},
+		{
+			name:   "extra map",
+			opts:   []Option{WithResolverMap(map[string]string{"example.com/fork": "orig"})},
+			want:   "orig.Run()",
+			wantGo: true,
+		},
+		{
+			name: "resolver",
+			opts: []Option{WithResolver(guess.WithMap(map[string]string{"example.com/fork": "orig"}))},
+			want: "orig.Run()",
+		},
+		{
+			name: "resolver over extra map",
+			opts: []Option{
+				WithResolver(guess.WithMap(map[string]string{"example.com/fork": "replaced"})),
+				WithResolverMap(map[string]string{"example.com/fork": "orig"}),
+			},
+			want: "replaced.Run()",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(resetImportcfgAdditions)
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":  "module example.com/app\n\ngo 1.22\n",
+				"main.go": "package main\n\nfunc main() {}\n",
+				// The injected package is known to the compiler, so it is not located either.
+				"importcfg": "packagefile example.com/fork=/cache/fork.a\n",
+			})
+
+			goBinary, runsFile := countingGo(t)
+			opts := append([]Option{WithGoBinary(goBinary)}, tt.opts...)
+			sources := compiledSources(t, dir, []string{"main.go"}, appendCall("main", "example.com/fork", "Run"), opts...)
+
+			if got := sources["main.go"]; !strings.Contains(got, `"example.com/fork"`) || !strings.Contains(got, tt.want) {
+				t.Errorf("got source:\n%s\nwant it to import example.com/fork and call %s", got, tt.want)
+			}
+
+			runs, _ := os.ReadFile(runsFile)
+			if ran := strings.Contains(string(runs), "list"); ran != tt.wantGo {
+				t.Errorf("go list ran %t with %q, want %t", ran, strings.Fields(string(runs)), tt.wantGo)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package goinject
+
+import (
+	"strings"
+	"time"
+)
+
+// permanentErrors are the messages of the go command reporting problems that do not
+// go away on their own, like a missing package, so the failed calls are not retried.
+var permanentErrors = []string{
+	"cannot find package",
+	"no required module provides package",
+	"is not in std",
+	"is not in GOROOT",
+	"malformed import path",
+	"invalid import path",
+	"build constraints exclude all Go files",
+	"no Go files in",
+}
+
+// isTransient reports whether the failed call may succeed if it is retried.
+func isTransient(err error) bool {
+	for _, msg := range permanentErrors {
+		if strings.Contains(err.Error(), msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// retry calls fn until it succeeds, fails with an error that is not transient,
+// or is retried as many times as configured with [WithResolveRetries].
+// The delay between the attempts doubles after each of them.
+func retry[T any](config *config, what string, fn func() (T, error)) (T, error) {
+	delay := config.resolveBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || attempt > config.resolveRetries || !isTransient(err) {
+			return result, err
+		}
+
+		config.logger.Printf("Retrying %s in %s after attempt %d failed: %s", what, delay, attempt, err)
+		select {
+		case <-time.After(delay):
+		case <-config.build.ctx.Done():
+			return result, err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,63 @@
+package goinject
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessResolveRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		// failure is the error of the resolver in the first two attempts.
+		failure  error
+		attempts int
+		wantErr  bool
+	}{
+		{name: "transient", retries: 3, failure: errors.New("go: module cache locked"), attempts: 3},
+		{name: "too many failures", retries: 1, failure: errors.New("go: module cache locked"), attempts: 2, wantErr: true},
+		{name: "permanent", retries: 3, failure: errors.New("no required module provides package example.com/trace"), attempts: 1, wantErr: true},
+		{name: "no retries", failure: errors.New("go: module cache locked"), attempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+			t.Cleanup(resetImportcfgAdditions)
+
+			attempts := 0
+			resolver := func(pkgName string) (map[string]string, error) {
+				attempts++
+				if attempts <= 2 {
+					return nil, tt.failure
+				}
+				return map[string]string{pkgName: "/fake/trace.a"}, nil
+			}
+
+			var logs strings.Builder
+			opts := []Option{WithPackageResolver(resolver), WithResolveRetries(tt.retries, time.Millisecond), WithLogger(log.New(&logs, "", 0))}
+			tool, _ := fakeCompiler(t, 0)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+			err := runCompile(t, dir, tool, args, appendCall("main", "example.com/trace", "Start"), opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tt.wantErr)
+			}
+
+			if attempts != tt.attempts {
+				t.Errorf("resolver was called %d times, want %d", attempts, tt.attempts)
+			}
+			if retries := strings.Count(logs.String(), "Retrying resolving example.com/trace"); retries != tt.attempts-1 {
+				t.Errorf("got %d retries logged, want %d:\n%s", retries, tt.attempts-1, logs.String())
+			}
+		})
+	}
+}
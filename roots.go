@@ -0,0 +1,140 @@
+package goinject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// projectRoots returns the directories whose files belong to the project: the root
+// of the main module or workspace, the directories of workspace modules and local
+// replacements, and the extra roots given with [WithExtraRoots].
+//
+// Directories reached through symlinks are returned both as given and resolved,
+// since the compiler may receive the files by either path.
+func projectRoots(config *config, wd string) ([]string, error) {
+	roots := []string{wd}
+
+	localDirs, err := localModuleDirs(config.build, wd)
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, localDirs...)
+
+	for _, root := range config.extraRoots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("resolving absolute path of %q: %w", root, err)
+		}
+		roots = append(roots, absRoot)
+	}
+
+	for _, root := range roots {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil && resolved != root {
+			roots = append(roots, resolved)
+		}
+	}
+
+	return roots, nil
+}
+
+// modEdit is the subset of the `go mod edit -json` and `go work edit -json` output
+// describing where the modules of the build are located on disk.
+type modEdit struct {
+	Use []struct {
+		DiskPath string
+	}
+	Replace []struct {
+		New struct {
+			Path    string
+			Version string
+		}
+	}
+}
+
+// localModuleDirs returns the directories of the modules used from the local filesystem:
+// the modules listed in go.work in workspace mode, and the targets of replace directives
+// pointing to a directory. Relative paths are resolved against wd, the directory
+// of the go.mod or go.work file.
+func localModuleDirs(build buildContext, wd string) ([]string, error) {
+	goWork, err := goWork(build)
+	if err != nil {
+		return nil, err
+	}
+
+	editCmd := "mod"
+	if goWork != "" {
+		editCmd = "work"
+	} else if _, err := os.Stat(filepath.Join(wd, "go.mod")); err != nil {
+		// Outside of a module, there is neither go.mod nor go.work to list the modules.
+		return nil, nil
+	}
+
+	cmd := build.goCommand(editCmd, "edit", "-json")
+	cmd.Dir = wd
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %q: %w", cmd.Args, err)
+	}
+
+	var edit modEdit
+	if err := json.Unmarshal(stdout.Bytes(), &edit); err != nil {
+		return nil, fmt.Errorf("parsing `go %s edit` output: %w", editCmd, err)
+	}
+
+	var dirs []string
+	for _, use := range edit.Use {
+		dirs = append(dirs, absDir(wd, use.DiskPath))
+	}
+
+	for _, replace := range edit.Replace {
+		// A replacement with a version refers to another module,
+		// while one without it refers to a directory.
+		if replace.New.Version != "" || !isLocalPath(replace.New.Path) {
+			continue
+		}
+		dirs = append(dirs, absDir(wd, replace.New.Path))
+	}
+
+	return dirs, nil
+}
+
+// isLocalPath reports whether the replacement path of a replace directive is a filesystem path.
+// The go command requires such paths to be absolute or to start with ./ or ../.
+func isLocalPath(path string) bool {
+	return filepath.IsAbs(path) ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") ||
+		strings.HasPrefix(path, `.\`) || strings.HasPrefix(path, `..\`)
+}
+
+// absDir resolves the directory path relative to base.
+func absDir(base string, path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+
+	return filepath.Join(base, path)
+}
+
+// isWithin reports whether the path is located in one of the dirs,
+// but not in their vendor directories. Vendored packages are dependencies
+// like the ones in the module cache, so they are not modified either.
+func isWithin(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if strings.HasPrefix(rel, "vendor"+string(filepath.Separator)) {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,62 @@
+package goinject
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessOutsideModuleRoot(t *testing.T) {
+	tests := []struct {
+		name  string
+		goMod string
+		opts  []Option
+		// modified reports whether the file of the sibling module is expected to be modified.
+		modified bool
+	}{
+		{
+			name:     "replace",
+			goMod:    "module example.com/app\n\ngo 1.22\n\nrequire example.com/lib v0.0.0\n\nreplace example.com/lib => ../lib\n",
+			modified: true,
+		},
+		{
+			name:  "unrelated",
+			goMod: "module example.com/app\n\ngo 1.22\n",
+		},
+		{
+			name:     "extra root",
+			goMod:    "module example.com/app\n\ngo 1.22\n",
+			opts:     []Option{WithExtraRoots("../lib")},
+			modified: true,
+		},
+		{
+			// A replacement by another module version is located in the module cache.
+			name:  "replace by version",
+			goMod: "module example.com/app\n\ngo 1.22\n\nrequire example.com/lib v0.0.0\n\nreplace example.com/lib => example.com/fork v1.0.0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{
+				"app/go.mod":    tt.goMod,
+				"app/main.go":   "package main\n\nfunc main() {}\n",
+				"app/importcfg": "",
+				"lib/go.mod":    "module example.com/lib\n\ngo 1.22\n",
+				"lib/lib.go":    "package lib\n\nfunc Hello() {}\n",
+			})
+
+			tool, argsFile := fakeCompiler(t, 0)
+			libFile := filepath.Join(root, "lib", "lib.go")
+			args := []string{"-p", "example.com/lib", "-importcfg", filepath.Join(root, "app", "importcfg"), "-pack", libFile}
+			if err := runCompile(t, filepath.Join(root, "app"), tool, args, identity, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			compiled := compiledArgs(t, argsFile)
+			if modified := compiled[len(compiled)-1] != libFile; modified != tt.modified {
+				t.Errorf("file of the sibling module modified: %t, want %t", modified, tt.modified)
+			}
+		})
+	}
+}
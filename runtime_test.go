@@ -0,0 +1,78 @@
+package goinject
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessRuntimeImport(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// want is the call to the support package, qualified with its resolved name.
+		want string
+	}{
+		{
+			// The name is guessed from the path of the package, which is not imported by the project.
+			name: "unregistered",
+			want: "rtsupport.Start()",
+		},
+		{
+			name: "registered",
+			opts: []Option{WithRuntimeImport("example.com/app/rtsupport", "rt")},
+			want: "rt.Start()",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(resetImportcfgAdditions)
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":          "module example.com/app\n\ngo 1.22\n",
+				"main.go":         "package main\n\nfunc main() {}\n",
+				"rtsupport/rt.go": "package rt\n\nfunc Start() {}\n",
+				"importcfg":       "",
+			})
+			chdir(t, dir)
+
+			sources := compiledSources(t, dir, []string{"main.go"}, appendCall("main", "example.com/app/rtsupport", "Start"), tt.opts...)
+			if got := sources["main.go"]; !strings.Contains(got, `"example.com/app/rtsupport"`) || !strings.Contains(got, tt.want) {
+				t.Errorf("got source:\n%s\nwant it to import example.com/app/rtsupport and call %s", got, tt.want)
+			}
+
+			importcfg, err := os.ReadFile(filepath.Join(dir, "importcfg"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(importcfg), "packagefile example.com/app/rtsupport=") {
+				t.Errorf("support package is missing from importcfg:\n%s", importcfg)
+			}
+		})
+	}
+}
+
+func TestProcessRuntimeImportUnresolved(t *testing.T) {
+	t.Cleanup(resetImportcfgAdditions)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"importcfg": "",
+	})
+	chdir(t, dir)
+
+	tool, argsFile := fakeCompiler(t, 0)
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+	err := runCompile(t, dir, tool, args, identity, WithRuntimeImport("example.com/app/missing", "missing"))
+	if err == nil || !strings.Contains(err.Error(), "adding runtime imports") {
+		t.Fatalf("got error %v, want the missing support package to fail the build", err)
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Error("compiler was run without the support package")
+	}
+}
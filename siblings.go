@@ -0,0 +1,56 @@
+package goinject
+
+import (
+	"fmt"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	dstresolver "github.com/dave/dst/decorator/resolver"
+)
+
+// SiblingFiles returns the other Go files of the package, in the order they are compiled,
+// so a modifier may look up declarations of the package outside of the file it modifies,
+// like the struct type of a method receiver.
+//
+// The files are parsed and decorated on the first call, and are shared by all the files
+// of the package, which may be modified concurrently, see [WithParallelism]. They are
+// read-only: the changes made to them are not compiled. Modifiers changing several files
+// at once implement [PackageModifier] instead.
+func (c *ModifyContext) SiblingFiles() ([]*dst.File, error) {
+	files, err := c.unit.decoratedSiblings(c.identResolver)
+	if err != nil {
+		return nil, err
+	}
+
+	siblings := make([]*dst.File, 0, len(c.unit.goFiles))
+	for _, path := range c.unit.goFiles {
+		if path != c.Path {
+			siblings = append(siblings, files[path])
+		}
+	}
+
+	return siblings, nil
+}
+
+// decoratedSiblings decorates the original Go files of the compile unit once, see [ModifyContext.SiblingFiles].
+func (u *compileUnit) decoratedSiblings(identResolver dstresolver.DecoratorResolver) (map[string]*dst.File, error) {
+	u.siblingsOnce.Do(func() {
+		u.siblings = make(map[string]*dst.File, len(u.goFiles))
+		for _, path := range u.goFiles {
+			astFile, err := u.parse(path)
+			if err != nil {
+				u.siblingsErr = err
+				return
+			}
+
+			f, err := decorator.NewDecoratorWithImports(u.fset, path, identResolver).DecorateFile(astFile)
+			if err != nil {
+				u.siblingsErr = fmt.Errorf("decorating sibling file %s: %w", path, err)
+				return
+			}
+			u.siblings[path] = f
+		}
+	})
+
+	return u.siblings, u.siblingsErr
+}
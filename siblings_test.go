@@ -0,0 +1,80 @@
+package goinject
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+// structFieldModifier injects into main a print of the first field of the struct type
+// with the given name, looked up in the other files of the package.
+func structFieldModifier(t *testing.T, typeName string, siblings *int) Modifier {
+	return funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		if decl.Name.Name != "main" {
+			return
+		}
+
+		files, err := ctx.SiblingFiles()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		*siblings = len(files)
+
+		for _, f := range files {
+			for _, d := range f.Decls {
+				gen, ok := d.(*dst.GenDecl)
+				if !ok {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					typeSpec, ok := spec.(*dst.TypeSpec)
+					if !ok || typeSpec.Name.Name != typeName {
+						continue
+					}
+					if st, ok := typeSpec.Type.(*dst.StructType); ok && len(st.Fields.List) > 0 {
+						field := st.Fields.List[0].Names[0].Name
+						code := fmt.Sprintf("println(%s{%s: %q}.%s)", typeName, field, "found in a sibling", field)
+						decl.Body.List = append(decl.Body.List, &dst.ExprStmt{X: dst.NewIdent(code)})
+					}
+				}
+			}
+		}
+	})
+}
+
+func TestModifyContextSiblingFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeName string
+		want     string
+	}{
+		{name: "declared in sibling", typeName: "Config", want: "found in a sibling\n"},
+		{name: "not declared", typeName: "Missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"a.go": "package main\n\nfunc main() {}\n",
+				"b.go": "package main\n\ntype Config struct {\n\tName string\n}\n",
+			}
+			writeFiles(t, dir, files)
+			writeFiles(t, dir, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n", "importcfg": ""})
+
+			var siblings int
+			sources := compiledSources(t, dir, []string{"a.go", "b.go"}, structFieldModifier(t, tt.typeName, &siblings))
+			if siblings != 1 {
+				t.Errorf("got %d sibling files, want b.go only", siblings)
+			}
+
+			// The sibling is compiled as is, and the modified file refers to its type.
+			files["a.go"] = sources["a.go"]
+			if out := runPackage(t, files); out != tt.want {
+				t.Errorf("got output %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
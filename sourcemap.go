@@ -0,0 +1,126 @@
+package goinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// sourceMap is the content of the file written for every modified file with [WithSourceMap].
+type sourceMap struct {
+	// Original is the path to the original file.
+	Original string
+	// Generated is the path to the modified copy of the file compiled instead of it.
+	Generated string
+	// Lines maps the lines of the generated file to the lines of the original file.
+	// Only the lines where original code starts are listed, so the lines of injected code
+	// are best attributed to the closest preceding listed line.
+	Lines map[int]int
+}
+
+// sourceMapLines maps the lines of the generated code to the lines of the original file.
+//
+// The restorer does not know the final positions of the nodes, since it adds imports and
+// the code may be formatted and annotated afterwards. The generated code is parsed instead,
+// and its nodes are matched with the ones restored from the same tree in the order of traversal.
+// It must be called before the restorer restores another file.
+func sourceMapLines(f *dst.File, dec *decorator.Decorator, res *decorator.Restorer, generated []byte) (map[int]int, error) {
+	restoredFile, ok := res.Ast.Nodes[f]
+	if !ok {
+		return nil, fmt.Errorf("file was not restored")
+	}
+
+	fset := token.NewFileSet()
+	generatedFile, err := parser.ParseFile(fset, "", generated, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("parsing generated code: %w", err)
+	}
+
+	restoredNodes, generatedNodes := astNodes(restoredFile), astNodes(generatedFile)
+	if len(restoredNodes) != len(generatedNodes) {
+		return nil, fmt.Errorf("generated code does not match the restored file")
+	}
+
+	restoredIdx := make(map[ast.Node]int, len(restoredNodes))
+	for idx, node := range restoredNodes {
+		restoredIdx[node] = idx
+	}
+
+	lines := make(map[int]int)
+	dst.Inspect(f, func(node dst.Node) bool {
+		if node == nil {
+			return false
+		}
+
+		original, ok := dec.Ast.Nodes[node]
+		if !ok {
+			return true
+		}
+		idx, ok := restoredIdx[res.Ast.Nodes[node]]
+		if !ok {
+			return true
+		}
+
+		// Line directives of the generated code are ignored, since they point to the original file.
+		originalPos := dec.Fset.Position(original.Pos())
+		generatedPos := fset.PositionFor(generatedNodes[idx].Pos(), false)
+		if !originalPos.IsValid() || !generatedPos.IsValid() {
+			return true
+		}
+
+		// The outermost node starting on a line describes it best.
+		if _, ok := lines[generatedPos.Line]; !ok {
+			lines[generatedPos.Line] = originalPos.Line
+		}
+
+		return true
+	})
+
+	return lines, nil
+}
+
+// astNodes returns the nodes of the tree in the order of traversal.
+func astNodes(root ast.Node) []ast.Node {
+	var nodes []ast.Node
+	ast.Inspect(root, func(node ast.Node) bool {
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+
+	return nodes
+}
+
+// writeSourceMap writes the source map of the modified file to dir. The maps are namespaced
+// by the same hashes of the original paths as the modified files are within the tmp dir,
+// e.g. dir/1f2e3d4c5b6a7980/main.go.map.json, so files sharing a basename never overwrite each other.
+func writeSourceMap(dir string, unit *compileUnit, sm sourceMap) error {
+	rel, err := filepath.Rel(unit.tmpDir, sm.Generated)
+	if err != nil {
+		return fmt.Errorf("resolving source map path: %w", err)
+	}
+	path := filepath.Join(dir, rel+".map.json")
+
+	content, err := json.MarshalIndent(sm, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding source map: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating source map dir: %w", err)
+	}
+
+	if err := replaceFile(path, append(content, '\n')); err != nil {
+		return fmt.Errorf("writing source map: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package goinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestProcessSourceMap(t *testing.T) {
+	src := `package main
+
+func main() {
+	a := 1
+	_ = a
+}
+
+func other() {}
+`
+
+	for _, injected := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d injected", injected), func(t *testing.T) {
+			dir, mapDir := t.TempDir(), t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   src,
+				"importcfg": "",
+			})
+
+			// Every injected statement takes a line of its own at the start of main.
+			modifier := funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+				if decl.Name.Name != "main" {
+					return
+				}
+				var stmts []dst.Stmt
+				for range injected {
+					stmt := &dst.ExprStmt{X: &dst.CallExpr{Fun: dst.NewIdent("println")}}
+					stmt.Decs.Before = dst.NewLine
+					stmts = append(stmts, stmt)
+				}
+				decl.Body.List = append(stmts, decl.Body.List...)
+			})
+
+			tool, _ := fakeCompiler(t, 0)
+			mainFile := filepath.Join(dir, "main.go")
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile}
+			if err := runCompile(t, dir, tool, args, modifier, WithSourceMap(mapDir)); err != nil {
+				t.Fatal(err)
+			}
+
+			maps, err := filepath.Glob(filepath.Join(mapDir, "*", "main.go.map.json"))
+			if err != nil || len(maps) != 1 {
+				t.Fatalf("got source maps %v, want one for main.go", maps)
+			}
+			content, err := os.ReadFile(maps[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sm sourceMap
+			if err := json.Unmarshal(content, &sm); err != nil {
+				t.Fatal(err)
+			}
+			if sm.Original != mainFile {
+				t.Errorf("got original file %s, want %s", sm.Original, mainFile)
+			}
+
+			// generated maps the lines of the original file to the ones of the generated file.
+			generated := make(map[int]int)
+			for gen, orig := range sm.Lines {
+				generated[orig] = gen
+			}
+
+			// The lines up to the injected statements are not shifted, the ones after them are.
+			shift := generated[1] - 1
+			for orig, wantShift := range map[int]int{1: 0, 3: 0, 4: injected, 5: injected, 8: injected} {
+				gen, ok := generated[orig]
+				if !ok {
+					t.Errorf("original line %d is not mapped in %v", orig, sm.Lines)
+					continue
+				}
+				if got := gen - orig - shift; got != wantShift {
+					t.Errorf("original line %d is mapped to line %d, shifted by %d, want %d", orig, gen, got, wantShift)
+				}
+			}
+		})
+	}
+}
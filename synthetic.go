@@ -0,0 +1,142 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// syntheticFile is a file added to the package by a modifier, see [ModifyContext.AddFile].
+type syntheticFile struct {
+	name string
+	file *dst.File
+}
+
+// AddFile adds a new file with the given name to the package being compiled, for the code
+// that belongs to none of the original files, like a registry of the instrumented functions:
+//
+//	ctx.AddFile("zz_goinject_registry.go", &dst.File{
+//		Decls: []dst.Decl{...},
+//	})
+//
+// The name must be the base name of a Go file. The package clause of the file is set
+// to the one of the package unless the file has it. Like in the modified files,
+// the imports the code of the file needs are added when it is written.
+//
+// The file is written and compiled along with the modified files, once all of them
+// are modified, so it may still be changed until then. AddFile reports whether the file
+// was added, which it is not if a file with the same name was already added to the package,
+// so modifiers processing the files one by one may call it for every file of the package.
+func (c *ModifyContext) AddFile(name string, f *dst.File) bool {
+	return c.unit.addSyntheticFile(name, f)
+}
+
+// addSyntheticFile records the file added by a modifier and reports whether it was not recorded yet.
+func (u *compileUnit) addSyntheticFile(name string, f *dst.File) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, synthetic := range u.synthetic {
+		if synthetic.name == name {
+			return false
+		}
+	}
+	u.synthetic = append(u.synthetic, syntheticFile{name: name, file: f})
+
+	return true
+}
+
+// DropFile removes the file being modified from the package being compiled, e.g. to swap
+// a stub for the implementation the modifier adds with [ModifyContext.AddFile] or injects into
+// another file. The file is neither written nor compiled, whatever the modifier returns for it.
+func (c *ModifyContext) DropFile() {
+	c.unit.drop(c.Path)
+}
+
+// drop records the original file removed from the package by a modifier.
+func (u *compileUnit) drop(path string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.dropped == nil {
+		u.dropped = make(map[string]bool)
+	}
+	u.dropped[path] = true
+}
+
+// isDropped reports whether the original file was removed from the package by a modifier.
+func (u *compileUnit) isDropped(path string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.dropped[path]
+}
+
+// writeSyntheticFiles writes the files added by the modifiers to the tmp dir, patching importcfg
+// with the packages they import, and returns their paths to be passed to the compiler.
+func writeSyntheticFiles(config *config, unit *compileUnit) ([]string, error) {
+	if len(unit.synthetic) == 0 {
+		return nil, nil
+	}
+
+	original, err := unit.parse(unit.goFiles[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, synthetic := range unit.synthetic {
+		name, f := synthetic.name, synthetic.file
+		if filepath.Base(name) != name || !strings.HasSuffix(name, ".go") {
+			return nil, fmt.Errorf("added file %q is not the base name of a Go file", name)
+		}
+
+		if f.Name == nil {
+			f.Name = dst.NewIdent(original.Name.Name)
+		}
+
+		// The file is resolved like the files of the package, so the imports it needs are named alike.
+		resolver, err := unit.packagesResolver(config, filepath.Dir(unit.goFiles[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(unit.tmpDir, "added", name)
+
+		var code bytes.Buffer
+		if err := decorator.NewRestorerWithImports(path, resolver).Fprint(&code, f); err != nil {
+			return nil, fmt.Errorf("printing added file %s: %w", name, err)
+		}
+
+		if config.validateOutput {
+			if err := validateOutput(path, code.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+
+		if config.inMemory {
+			unit.hold(path, code.Bytes())
+		} else if err := unit.output(path, code.Bytes()); err != nil {
+			return nil, fmt.Errorf("writing added file %s: %w", name, err)
+		}
+		config.build.profile.recordFile(code.Len())
+
+		imports, err := fileImports(path, code.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		if err := addMissingPkgs(config, unit.pkgPath, unit.importcfg, imports); err != nil {
+			return nil, err
+		}
+
+		config.logger.Printf("Added file to the package: %s", path)
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,100 @@
+package goinject
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotToolexec is returned when the preprocessor is run directly rather than
+// by the go command with -toolexec, which passes it the tool to run and its arguments.
+var ErrNotToolexec = errors.New("not run by the go command with -toolexec")
+
+// checkToolexec returns an error explaining how to use the preprocessor if args,
+// the arguments of the process, are not the ones passed by the go command:
+// the go command runs the tools from its tool directory, so the path
+// of the tool to run is always absolute.
+func checkToolexec(args []string) error {
+	if len(args) > toolOffset && filepath.IsAbs(args[toolOffset]) {
+		return nil
+	}
+
+	name := "preprocessor"
+	if len(args) > 0 {
+		name = filepath.Base(args[0])
+	}
+
+	toolexec, err := ToolexecFlag()
+	if err != nil {
+		toolexec = "-toolexec=/abs/path/to/" + name
+	}
+
+	return fmt.Errorf("%s is %w: it runs the go tools on behalf of the go command, "+
+		"so it must be passed to it instead of being run directly, like\n\n\tgo build %s ./...", name, ErrNotToolexec, toolexec)
+}
+
+// Main is the entry point of a preprocessor. It does the work of [Process], and exits
+// with a non-zero code if it fails, reporting the error to stderr rather than panicking:
+//
+//	func main() {
+//		goinject.Main(modifier{})
+//	}
+//
+// If the executed command fails, Main exits with its exit code, since the command already
+// reported its diagnostics. If the preprocessor is run directly rather than by the go command,
+// Main exits with the code 2 and a usage message, see [ErrNotToolexec]. The temporary files are removed on every exit path,
+// including the build being interrupted.
+func Main(modifier Modifier, opts ...Option) {
+	defer handleSignals()()
+
+	err := ProcessE(modifier, opts...)
+	if err == nil {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, err)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(max(exitErr.ExitCode(), 1))
+	}
+	if errors.Is(err, ErrNotToolexec) {
+		os.Exit(2)
+	}
+	os.Exit(1)
+}
+
+// ToolexecFlag returns the -toolexec flag of the go command running the current executable
+// as the preprocessor, e.g. `-toolexec=/abs/path/to/preprocessor`. The path is absolute,
+// since the go command runs the tools in the directories of the packages being built.
+// A path containing spaces is quoted, since the go command splits the flag into words.
+func ToolexecFlag() (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("locating the current executable: %w", err)
+	}
+
+	self, err = filepath.Abs(self)
+	if err != nil {
+		return "", fmt.Errorf("resolving absolute path of the current executable: %w", err)
+	}
+
+	return "-toolexec=" + quoteToolexec(self), nil
+}
+
+// quoteToolexec quotes the path for the -toolexec flag, which the go command splits
+// into words at spaces, honoring single and double quotes without escapes.
+func quoteToolexec(path string) string {
+	if !strings.ContainsAny(path, " \t\n\r'\"") {
+		return path
+	}
+
+	if strings.Contains(path, "'") {
+		return `"` + path + `"`
+	}
+
+	return "'" + path + "'"
+}
@@ -0,0 +1,76 @@
+package goinject
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToolexecFlag(t *testing.T) {
+	flag, err := ToolexecFlag()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, ok := strings.CutPrefix(flag, "-toolexec=")
+	if !ok {
+		t.Fatalf("flag %q does not start with -toolexec=", flag)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != quoteToolexec(self) || !filepath.IsAbs(self) {
+		t.Errorf("got flag %q, want the absolute path of the executable %s", flag, self)
+	}
+}
+
+func TestQuoteToolexec(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/usr/local/bin/preprocessor", want: "/usr/local/bin/preprocessor"},
+		{path: "/home/me/my tools/preprocessor", want: "'/home/me/my tools/preprocessor'"},
+		{path: "/home/me/tab\there/preprocessor", want: "'/home/me/tab\there/preprocessor'"},
+		{path: `/home/me/"quoted"/preprocessor`, want: `'/home/me/"quoted"/preprocessor'`},
+		{path: "/home/me/it's mine/preprocessor", want: `"/home/me/it's mine/preprocessor"`},
+	}
+
+	for _, tt := range tests {
+		if got := quoteToolexec(tt.path); got != tt.want {
+			t.Errorf("quoteToolexec(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProcessRunDirectly(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no arguments"},
+		{name: "garbage", args: []string{"garbage"}},
+		{name: "flag", args: []string{"-V=full"}},
+		{name: "relative tool", args: []string{"compile", "-p", "main", "-pack", "main.go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osArgs := os.Args
+			os.Args = append([]string{osArgs[0]}, tt.args...)
+			t.Cleanup(func() { os.Args = osArgs })
+
+			err := ProcessE(identity)
+			if !errors.Is(err, ErrNotToolexec) {
+				t.Fatalf("got error %v, want %v", err, ErrNotToolexec)
+			}
+			if !strings.Contains(err.Error(), "go build -toolexec=") {
+				t.Errorf("got error %q, want it to show the usage with go build", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package goinject
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/types"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// parse parses the Go file at path into the file set of the compile unit.
+// Files are parsed once, so the type information of the unit
+// refers to the same AST nodes the modifiers work on.
+func (u *compileUnit) parse(path string) (*ast.File, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if f, ok := u.parsed[path]; ok {
+		return f, nil
+	}
+
+	f, err := parser.ParseFile(u.fset, path, nil, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.parsed == nil {
+		u.parsed = make(map[string]*ast.File)
+	}
+	u.parsed[path] = f
+
+	return f, nil
+}
+
+// typedPackage is the result of type-checking a package of the compile unit.
+type typedPackage struct {
+	pkg  *types.Package
+	info *types.Info
+	err  error
+}
+
+// typeCheck type-checks the original Go files of the package the file at path belongs to,
+// once per package, and returns the resulting type information.
+//
+// A unit may hold the files of several packages, like the files of a package and of its
+// external test package, so only the files with the same package clause as the file are type-checked.
+//
+// The compiler receives the export data of every dependency via the importcfg file,
+// so the same archives are used to import them, without invoking the go command.
+func (u *compileUnit) typeCheck(path string) (*types.Package, *types.Info, error) {
+	f, err := u.parse(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := f.Name.Name
+
+	u.typesMu.Lock()
+	defer u.typesMu.Unlock()
+
+	typed, ok := u.typed[name]
+	if !ok {
+		typed = &typedPackage{}
+		typed.pkg, typed.info, typed.err = u.doTypeCheck(name)
+		if u.typed == nil {
+			u.typed = make(map[string]*typedPackage)
+		}
+		u.typed[name] = typed
+	}
+
+	return typed.pkg, typed.info, typed.err
+}
+
+func (u *compileUnit) doTypeCheck(name string) (*types.Package, *types.Info, error) {
+	var files []*ast.File
+	for _, path := range u.goFiles {
+		f, err := u.parse(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.Name.Name == name {
+			files = append(files, f)
+		}
+	}
+
+	// The external test package compiled along with the package is named after it.
+	pkgPath := u.pkgPath
+	if strings.HasSuffix(name, "_test") && !strings.HasSuffix(pkgPath, "_test") {
+		pkgPath += "_test"
+	}
+
+	packageFiles, importMap, err := readImportcfg(u.importcfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lookup := func(path string) (io.ReadCloser, error) {
+		if mapped, ok := importMap[path]; ok {
+			path = mapped
+		}
+
+		file, ok := packageFiles[path]
+		if !ok {
+			return nil, fmt.Errorf("package %q is not in importcfg", path)
+		}
+
+		return os.Open(file)
+	}
+
+	goarch := u.goarch
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+
+	conf := types.Config{
+		Importer:  importer.ForCompiler(u.fset, "gc", lookup),
+		GoVersion: u.goVersion,
+		Sizes:     types.SizesFor("gc", goarch),
+	}
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+
+	pkg, err := conf.Check(pkgPath, u.fset, files, info)
+	if err != nil {
+		return nil, nil, fmt.Errorf("type-checking %s: %w", pkgPath, err)
+	}
+
+	return pkg, info, nil
+}
+
+// readImportcfg reads the packagefile and importmap directives of the importcfg file.
+func readImportcfg(path string) (map[string]string, map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading importcfg: %w", err)
+	}
+
+	packageFiles, importMap := parseImportcfg(content)
+	return packageFiles, importMap, nil
+}
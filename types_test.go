@@ -0,0 +1,67 @@
+package goinject
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// exportImportcfg writes an importcfg file with the export data of the packages and their dependencies,
+// like the one cmd/go gives to the compiler, and returns its path.
+func exportImportcfg(t *testing.T, pkgs ...string) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("building the export data of the packages in short mode")
+	}
+
+	args := append([]string{"list", "-export", "-deps", "-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}", "--"}, pkgs...)
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GOFLAGS=")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("listing the export data of %q: %s", pkgs, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "importcfg")
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestTypeCheckPackageClause(t *testing.T) {
+	_, unit := testUnit(t, map[string]string{
+		"foo.go":      "package foo\n\nfunc F() int { return 1 }\n",
+		"foo_test.go": "package foo_test\n\nfunc G() string { return \"\" }\n",
+	})
+	unit.pkgPath = "example.com/foo"
+	unit.importcfg = filepath.Join(t.TempDir(), "importcfg")
+	if err := os.WriteFile(unit.importcfg, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		file    string
+		pkgPath string
+		object  string
+	}{
+		{file: "foo.go", pkgPath: "example.com/foo", object: "F"},
+		{file: "foo_test.go", pkgPath: "example.com/foo_test", object: "G"},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			pkg, _, err := unit.typeCheck(filepath.Join(filepath.Dir(unit.goFiles[0]), tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkg.Path() != tc.pkgPath {
+				t.Errorf("got package %s, want %s", pkg.Path(), tc.pkgPath)
+			}
+			if pkg.Scope().Lookup(tc.object) == nil {
+				t.Errorf("package %s does not declare %s", pkg.Path(), tc.object)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package goinject
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+// snippetContext is the number of lines shown around the offending line of the generated code.
+const snippetContext = 2
+
+// validateOutput parses the generated code of the file at path and returns a descriptive error
+// if it is not valid Go, so a broken modifier is reported against the original file,
+// instead of the compiler failing on a temporary file that no longer exists.
+func validateOutput(path string, src []byte) error {
+	_, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+	if err == nil {
+		return nil
+	}
+
+	// Positions of the error may be adjusted by line directives, so the line
+	// of the generated code is derived from the offset instead.
+	var errList scanner.ErrorList
+	if !errors.As(err, &errList) || len(errList) == 0 {
+		return fmt.Errorf("generated code for %s does not parse: %w", path, err)
+	}
+	first := errList[0]
+	line := bytes.Count(src[:min(max(first.Pos.Offset, 0), len(src))], []byte("\n")) + 1
+
+	return fmt.Errorf("generated code for %s does not parse: line %d: %s\n%s", path, line, first.Msg, snippet(src, line))
+}
+
+// printRestored prints the file restored from f without formatting it.
+// A restorer accepts every node once, so a new one is used.
+func printRestored(path string, res resolver.RestorerResolver, f *dst.File) ([]byte, error) {
+	restorer := decorator.NewRestorerWithImports(path, res)
+	astFile, err := restorer.RestoreFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	err = printer.Fprint(&out, restorer.Fset, astFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// snippet returns the lines of src around the given 1-based line, marking the line itself.
+func snippet(src []byte, line int) string {
+	errLine := line - 1
+
+	lines := strings.Split(string(src), "\n")
+	start := max(errLine-snippetContext, 0)
+	end := min(errLine+snippetContext+1, len(lines))
+
+	var b strings.Builder
+	for idx := start; idx < end; idx++ {
+		marker := "  "
+		if idx == errLine {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%4d | %s\n", marker, idx+1, lines[idx])
+	}
+
+	return b.String()
+}
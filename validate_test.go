@@ -0,0 +1,63 @@
+package goinject
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+// brokenModifier appends a statement that does not parse to every function body.
+var brokenModifier = funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+	decl.Body.List = append(decl.Body.List, &dst.ExprStmt{X: dst.NewIdent("broken(")})
+})
+
+func TestProcessValidateOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// want are the parts expected in the error of the build.
+		want []string
+	}{
+		{
+			name: "validated",
+			opts: []Option{WithValidateOutput()},
+			want: []string{"generated code for", "main.go does not parse: line", ">", "broken("},
+		},
+		{
+			// The compiler reports the broken code instead, here a failing fake one.
+			name: "not validated",
+			want: []string{"exit status 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod":    "module example.com/app\n\ngo 1.22\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"importcfg": "",
+			})
+
+			tool, argsFile := fakeCompiler(t, 1)
+			args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", filepath.Join(dir, "main.go")}
+			err := runCompile(t, dir, tool, args, brokenModifier, tt.opts...)
+			if err == nil {
+				t.Fatal("broken code did not fail the build")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %q, want it to contain %q", err, want)
+				}
+			}
+
+			_, statErr := os.Stat(argsFile)
+			if compiled := statErr == nil; compiled != (tt.opts == nil) {
+				t.Errorf("compiler run: %t, want %t", compiled, tt.opts == nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package goinject
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// changedFiles returns the set of absolute paths of files in the git working tree
+// containing dir that differ from the given ref.
+// Untracked files are considered changed as well, since they do not exist in any ref.
+func changedFiles(dir string, ref string) (map[string]bool, error) {
+	topLevel, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	topLevel = strings.TrimSpace(topLevel)
+
+	// Both commands print paths relative to the top level directory of the repository.
+	diff, err := git(topLevel, "diff", "--name-only", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+
+	untracked, err := git(topLevel, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(diff + "\n" + untracked))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		files[filepath.Join(topLevel, filepath.FromSlash(line))] = true
+	}
+
+	return files, nil
+}
+
+// changedFilesKey returns the hash of the set of changed files, which does not depend on the order of the map.
+func changedFilesKey(files map[string]bool) []byte {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	sum := sha256.Sum256([]byte("changed=" + strings.Join(paths, "\n")))
+
+	return sum[:]
+}
+
+// git runs the git command with the given arguments in dir and returns its output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %q: %w: %s", cmd.Args, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,68 @@
+package goinject
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// gitRepo commits the files to a new git repository in dir.
+func gitRepo(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	writeFiles(t, dir, files)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		if _, err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessChangedFilesOnly(t *testing.T) {
+	dir := t.TempDir()
+	gitRepo(t, dir, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.22\n",
+		"main.go":   "package main\n\nfunc main() {}\n",
+		"other.go":  "package main\n\nfunc other() {}\n",
+		"importcfg": "",
+	})
+	writeFiles(t, dir, map[string]string{"other.go": "package main\n\nfunc other() { println() }\n"})
+
+	tool, argsFile := fakeCompiler(t, 0)
+	mainFile, otherFile := filepath.Join(dir, "main.go"), filepath.Join(dir, "other.go")
+	args := []string{"-p", "main", "-importcfg", filepath.Join(dir, "importcfg"), "-pack", mainFile, otherFile}
+	if err := runCompile(t, dir, tool, args, identity, WithChangedFilesOnly("HEAD")); err != nil {
+		t.Fatal(err)
+	}
+
+	compiled := compiledArgs(t, argsFile)
+	files := compiled[len(compiled)-2:]
+	if files[0] != mainFile {
+		t.Errorf("unchanged file was compiled from %s, want the original %s", files[0], mainFile)
+	}
+	if files[1] == otherFile || filepath.Base(files[1]) != "other.go" {
+		t.Errorf("changed file was compiled from %s, want a modified copy", files[1])
+	}
+}
+
+func TestVersionProbeWithoutChangedFiles(t *testing.T) {
+	repo := t.TempDir()
+	gitRepo(t, repo, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"})
+	// A temporary directory of its own is not in any git repository.
+	outside := t.TempDir()
+
+	tool, _ := fakeCompiler(t, 0)
+
+	// Every file is modified outside of a repository, which is not the same as none having changed.
+	if probeVersion(t, repo, tool, WithChangedFilesOnly("HEAD")) == probeVersion(t, outside, tool, WithChangedFilesOnly("HEAD")) {
+		t.Error("version without the changed files is the same as the one with no file changed")
+	}
+}
@@ -0,0 +1,57 @@
+package goinject
+
+import (
+	"go/token"
+	"strings"
+	"unicode"
+
+	"github.com/dave/dst"
+)
+
+// VersionCheck describes a compatibility assertion between the code injected by a modifier
+// and the runtime support library that code calls into.
+//
+// The library declares a symbol for every version of its API it supports:
+//
+//	const APIVersion_v2 = true
+//
+// and the modifier injects a reference to the one it was written against, so a mismatch fails
+// the compilation with an error naming the expected version, like `undefined: rt.APIVersion_v2`,
+// instead of a subtle breakage somewhere in the injected code.
+type VersionCheck struct {
+	// Path is the import path of the runtime support library.
+	Path string
+	// Symbol is the prefix of the version symbols declared by the library, e.g. APIVersion.
+	Symbol string
+	// Version is the version the modifier expects, e.g. v2.
+	Version string
+}
+
+// Name returns the name of the symbol the library must declare,
+// with the characters not allowed in identifiers replaced by underscores, e.g. APIVersion_v1_2.
+func (v VersionCheck) Name() string {
+	version := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, v.Version)
+
+	return v.Symbol + "_" + version
+}
+
+// Inject adds the assertion to the file as `var _ = rt.APIVersion_v2`.
+// It is enough to inject it into any single file of every instrumented package.
+func (v VersionCheck) Inject(f *dst.File) {
+	decl := &dst.GenDecl{
+		Tok: token.VAR,
+		Specs: []dst.Spec{&dst.ValueSpec{
+			Names:  []*dst.Ident{dst.NewIdent("_")},
+			Values: []dst.Expr{&dst.Ident{Path: v.Path, Name: v.Name()}},
+		}},
+	}
+	decl.Decs.Before = dst.EmptyLine
+	decl.Decs.Start.Append("// " + v.Path + " " + v.Version + " is required by the injected code.")
+
+	f.Decls = append(f.Decls, decl)
+}
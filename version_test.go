@@ -0,0 +1,89 @@
+package goinject
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/guess"
+)
+
+// importerFunc implements [types.Importer] with a function.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+func TestVersionCheck(t *testing.T) {
+	const rtPath = "example.com/rt"
+	// The library supports two versions of its API.
+	const rtSrc = "package rt\n\nconst APIVersion_v1_2 = true\n\nconst APIVersion_v2 = true\n"
+
+	tests := []struct {
+		name    string
+		version string
+		// wantErr is the expected compilation error, empty if the versions match.
+		wantErr string
+	}{
+		{name: "matching", version: "v2"},
+		{name: "matching with dots", version: "v1.2"},
+		{name: "mismatching", version: "v3", wantErr: "undefined: rt.APIVersion_v3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			rtFile, err := parser.ParseFile(fset, "rt.go", rtSrc, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rt, err := (&types.Config{}).Check(rtPath, fset, []*ast.File{rtFile}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := decorator.Parse("package main\n\nfunc main() {}\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			VersionCheck{Path: rtPath, Symbol: "APIVersion", Version: tt.version}.Inject(f)
+
+			var src bytes.Buffer
+			if err := decorator.NewRestorerWithImports("main", guess.New()).Fprint(&src, f); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("// %s %s is required by the injected code.", rtPath, tt.version); !strings.Contains(src.String(), want) {
+				t.Errorf("injected check is not documented with %q:\n%s", want, src.String())
+			}
+
+			file, err := parser.ParseFile(fset, "main.go", src.Bytes(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := types.Config{Importer: importerFunc(func(path string) (*types.Package, error) {
+				if path != rtPath {
+					return nil, fmt.Errorf("unexpected import %s", path)
+				}
+				return rt, nil
+			})}
+			_, err = conf.Check("main", fset, []*ast.File{file}, nil)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("matching version failed the compilation: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package goinject
+
+import "github.com/dave/dst"
+
+// WrapFunc wraps the body of the function with the onEnter statements, run when
+// the function is entered, and the onExit statements, run when it returns:
+//
+//	func Load(path string) (data []byte, err error) {
+//		defer func() {
+//			if r := recover(); r != nil {
+//				err = fmt.Errorf("%v", r)
+//			}
+//		}()
+//		trace.Enter("Load")
+//		defer func() {
+//			trace.Exit("Load", err)
+//		}()
+//		...
+//	}
+//
+// The deferred calls recovering from panics at the start of the function are kept first,
+// so they still handle the panics of the whole function, including the ones of onEnter and onExit.
+// The onExit statements run in a single deferred function literal, so they see the named results
+// of the function as they are returned, both by return statements with values and by bare returns.
+// Unnamed results are not accessible to them.
+//
+// The statements are cloned, so the same ones can be passed for every function of a file.
+// WrapFunc reports whether the function was wrapped. Functions without a body,
+// like the ones implemented in assembly, are skipped.
+func WrapFunc(decl *dst.FuncDecl, onEnter []dst.Stmt, onExit []dst.Stmt) bool {
+	if decl.Body == nil || len(onEnter) == 0 && len(onExit) == 0 {
+		return false
+	}
+
+	// Keep the leading deferred calls recovering from panics in place.
+	leading := 0
+	for _, stmt := range decl.Body.List {
+		deferStmt, ok := stmt.(*dst.DeferStmt)
+		if !ok || !callsRecover(deferStmt) {
+			break
+		}
+		leading++
+	}
+
+	var wrap []dst.Stmt
+	for _, stmt := range onEnter {
+		wrap = append(wrap, CloneStmt(stmt))
+	}
+
+	if len(onExit) > 0 {
+		var exit []dst.Stmt
+		for _, stmt := range onExit {
+			exit = append(exit, CloneStmt(stmt))
+		}
+
+		wrap = append(wrap, &dst.DeferStmt{
+			Call: &dst.CallExpr{
+				Fun: &dst.FuncLit{
+					Type: &dst.FuncType{},
+					Body: &dst.BlockStmt{List: exit},
+				},
+			},
+		})
+	}
+	wrap[len(wrap)-1].Decorations().After = dst.EmptyLine
+
+	decl.Body.List = append(decl.Body.List[:leading:leading], append(wrap, decl.Body.List[leading:]...)...)
+
+	return true
+}
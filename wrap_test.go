@@ -0,0 +1,113 @@
+package goinject
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+func TestWrapFunc(t *testing.T) {
+	src := `package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var trace []string
+
+func rec(s string) { trace = append(trace, s) }
+
+func named(fail bool) (n int, err error) {
+	if fail {
+		return 0, errors.New("failed")
+	}
+	n = 2
+	return
+}
+
+func guarded() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered: %v", r)
+		}
+	}()
+	panic("boom")
+}
+
+type T struct{}
+
+func (T) count(xs ...int) int { return len(xs) }
+
+func main() {
+	fmt.Println(named(true))
+	fmt.Println(named(false))
+	fmt.Println(guarded())
+	fmt.Println(T{}.count(1, 2, 3))
+	fmt.Println(strings.Join(trace, "\n"))
+}
+`
+
+	var wrapped []string
+	got := modifiedSource(t, src, funcModifier(func(ctx *ModifyContext, decl *dst.FuncDecl) {
+		if decl.Name.Name == "main" || decl.Name.Name == "rec" {
+			return
+		}
+
+		// The exit statements see the named results as they are returned.
+		var results []string
+		if decl.Type.Results != nil {
+			for _, field := range decl.Type.Results.List {
+				for _, name := range field.Names {
+					results = append(results, name.Name)
+				}
+			}
+		}
+		onEnter := []dst.Stmt{&dst.ExprStmt{X: dst.NewIdent(fmt.Sprintf("rec(%q)", "enter "+decl.Name.Name))}}
+		onExit := []dst.Stmt{&dst.ExprStmt{X: dst.NewIdent(fmt.Sprintf("rec(fmt.Sprint(%q, []any{%s}))", "exit "+decl.Name.Name+" ", strings.Join(results, ", ")))}}
+		if WrapFunc(decl, onEnter, onExit) {
+			wrapped = append(wrapped, decl.Name.Name)
+		}
+	}))
+
+	if want := "func guarded() (err error) {\n\tdefer func() {\n\t\tif r := recover(); r != nil {"; !strings.Contains(got, want) {
+		t.Errorf("the deferred recover is no longer the first statement:\n%s", got)
+	}
+
+	// The panic is still recovered by the function, after the exit statements ran.
+	want := `0 failed
+2 <nil>
+recovered: boom
+3
+enter named
+exit named [0 failed]
+enter named
+exit named [2 <nil>]
+enter guarded
+exit guarded [<nil>]
+enter count
+exit count []
+`
+	if out := runProgram(t, got); out != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", out, want)
+	}
+	if strings.Join(wrapped, ",") != "named,guarded,count" {
+		t.Errorf("got wrapped functions %q, want named, guarded and count", wrapped)
+	}
+}
+
+func TestWrapFuncWithoutBody(t *testing.T) {
+	f, err := decorator.Parse("package main\n\n// Implemented in assembly.\nfunc add(a, b int) int\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onEnter := []dst.Stmt{&dst.ExprStmt{X: &dst.CallExpr{Fun: dst.NewIdent("println")}}}
+	if WrapFunc(f.Decls[0].(*dst.FuncDecl), onEnter, nil) {
+		t.Error("function without a body was wrapped")
+	}
+}